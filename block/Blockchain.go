@@ -1,6 +1,7 @@
 package block
 
 import (
+	"blockchain/script"
 	"blockchain/utils"
 	"crypto/ecdsa"
 	"crypto/sha256"
@@ -17,6 +18,10 @@ const (
 	MINING_SENDER     = "THE BLOCKCHAIN"
 	MINING_REWARD     = 1.0
 	MINING_TIMER_SEC  = 10
+	// nonces tried between looks at whether mining was stopped
+	POW_CHECK_INTERVAL = 4096
+	// blocks from further in the future than this are rejected
+	MAX_FUTURE_BLOCK_TIME = 2 * time.Hour
 )
 
 type Block struct {
@@ -24,32 +29,99 @@ type Block struct {
 	PrevHash     [32]byte       `json:"prev_hash"`
 	Timestamp    int64          `json:"timestamp"`
 	Transactions []*Transaction `json:"transactions"`
+	// merkle root of the transactions, only set on blocks kept without
+	// their body, see Pruned
+	Root *[32]byte `json:"merkle_root,omitempty"`
 }
 
 type Blockchain struct {
 	TransactionPool   []*Transaction `json:"TransactionPool"`
 	Chain             []*Block       `json:"Chain"`
 	BlockchainAddress string         `json:"BlockchainAddress"`
+	ChainID           string         `json:"ChainID"`
 	Port              uint16
-	mux               sync.Mutex
+	genesis           *Genesis
+	blockHandlers     []func(*Block, int)
+	txHandlers        []func(*Transaction, int)
+	reorgHandlers     []func(*ReorgEvent)
+	miner             miningState
+	base              *chainBase
+	pruneKeep         int
+	// held for writing while the chain or the pool change, the public
+	// getters take it for reading. the unexported ones expect it held
+	mux sync.RWMutex
 }
 
 type Transaction struct {
 	SenderBlockchainAddress    string  `json:"SenderBlockchainAddress"`
 	RecipientBlockchainAddress string  `json:"RecipientBlockchainAddress"`
 	Value                      float32 `json:"Value"`
+	// network the transaction was signed for, see Genesis
+	ChainID string `json:"ChainID,omitempty"`
+	// block height or unix timestamp before which the transaction
+	// can't be included in a block, zero means no lock
+	LockTime int64 `json:"LockTime,omitempty"`
+	// set when spending from a script address, both hex encoded
+	RedeemScript string `json:"RedeemScript,omitempty"`
+	UnlockScript string `json:"UnlockScript,omitempty"`
+	// hex encoded, kept so peers can verify the transaction again
+	SenderPublicKey string `json:"SenderPublicKey,omitempty"`
+	Signature       string `json:"Signature,omitempty"`
 }
 
+// public key and signature are only left out when spending from a
+// script address, see MissingFields
 type TransactionRequest struct {
-	SenderBlockchainAddress    *string  `json:"sender_blockchain_address"`
-	RecipientBlockchainAddress *string  `json:"recipient_blockchain_address"`
-	SenderPublicKey            *string  `json:"sender_public_key"`
-	Value                      *float32 `json:"value"`
-	Signature                  *string  `json:"signature"`
+	SenderBlockchainAddress    *string  `json:"sender_blockchain_address" openapi:"required"`
+	RecipientBlockchainAddress *string  `json:"recipient_blockchain_address" openapi:"required"`
+	SenderPublicKey            *string  `json:"sender_public_key" openapi:"hex=128"`
+	Value                      *float32 `json:"value" openapi:"required"`
+	Signature                  *string  `json:"signature" openapi:"hex=128"`
+	LockTime                   *int64   `json:"lock_time"`
+	ChainID                    *string  `json:"chain_id"`
+	RedeemScript               *string  `json:"redeem_script"`
+	UnlockScript               *string  `json:"unlock_script"`
 }
 
 func NewTransaction(sender string, recipient string, value float32) *Transaction {
-	return &Transaction{sender, recipient, value}
+	return &Transaction{
+		SenderBlockchainAddress:    sender,
+		RecipientBlockchainAddress: recipient,
+		Value:                      value,
+	}
+}
+
+// hash of the signed part of the transaction, same bytes the wallet signs
+func (t *Transaction) SigHash() [32]byte {
+	m, _ := json.Marshal(struct {
+		SenderBlockchainAddress    string  `json:"SenderBlockchainAddress"`
+		RecipientBlockchainAddress string  `json:"RecipientBlockchainAddress"`
+		Value                      float32 `json:"Value"`
+		ChainID                    string  `json:"ChainID,omitempty"`
+		LockTime                   int64   `json:"LockTime,omitempty"`
+	}{
+		SenderBlockchainAddress:    t.SenderBlockchainAddress,
+		RecipientBlockchainAddress: t.RecipientBlockchainAddress,
+		Value:                      t.Value,
+		ChainID:                    t.ChainID,
+		LockTime:                   t.LockTime,
+	})
+	return sha256.Sum256(m)
+}
+
+// transaction id, covers the signature too
+func (t *Transaction) Hash() [32]byte {
+	m, _ := json.Marshal(t)
+	return sha256.Sum256(m)
+}
+
+// a transaction is final once its lock time has passed for a block at
+// the given height and timestamp (unix seconds)
+func (t *Transaction) IsFinal(height int64, timestamp int64) bool {
+	if t.LockTime == 0 {
+		return true
+	}
+	return script.LockTimeReached(t.LockTime, height, timestamp)
 }
 
 func (t *Transaction) PrintTransaction() {
@@ -57,12 +129,27 @@ func (t *Transaction) PrintTransaction() {
 	fmt.Printf(" sender_blockchain_address      %s\n", t.SenderBlockchainAddress)
 	fmt.Printf(" recipient_blockchain_address   %s\n", t.RecipientBlockchainAddress)
 	fmt.Printf(" value                          %.1f\n", t.Value)
+	if t.LockTime != 0 {
+		fmt.Printf(" lock_time                      %d\n", t.LockTime)
+	}
+	if t.RedeemScript != "" {
+		redeem, _ := script.FromHex(t.RedeemScript)
+		unlock, _ := script.FromHex(t.UnlockScript)
+		fmt.Printf(" redeem_script                  %s\n", redeem.Disassemble())
+		fmt.Printf(" unlock_script                  %s\n", unlock.Disassemble())
+	}
 }
 
 func (bc *Blockchain) GetTransactionPool() []*Transaction {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
 	return bc.TransactionPool
 }
 
+func (bc *Blockchain) Genesis() *Genesis {
+	return bc.genesis
+}
+
 func (b *Block) PrintBlock() {
 	fmt.Printf("Nonce: %d\n", b.Nonce)
 	fmt.Printf("PrevHash: %x\n", b.PrevHash)
@@ -76,112 +163,574 @@ func (b *Block) PrintBlock() {
 
 func (bc *Blockchain) PrintBlockchain() {
 	fmt.Println("Blockchain:")
-	for _, block := range bc.Chain {
+	for _, block := range bc.Blocks() {
 		block.PrintBlock()
 	}
 }
-func (bc *Blockchain) CreateBlock(nonce int, prevHash [32]byte) *Block {
-	b := NewBlock(nonce, prevHash, bc.TransactionPool)
-	bc.Chain = append(bc.Chain, b)
+
+func (bc *Blockchain) CreateBlock(nonce int, prevHash [32]byte, timestamp int64) *Block {
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+	b := NewBlock(nonce, prevHash, timestamp, bc.TransactionPool)
+	bc.setChain(append(bc.Chain[:len(bc.Chain):len(bc.Chain)], b))
 	bc.TransactionPool = []*Transaction{}
 	return b
 }
 
-func (bc *Blockchain) CreateTransaction(sender string, recipient string, value float32,
-	senderPublicKey *ecdsa.PublicKey, s *utils.Signature) bool {
-	isTransacted := bc.AddTransaction(sender, recipient, value, senderPublicKey, s)
-
-	return isTransacted
+func (bc *Blockchain) CreateTransaction(t *Transaction,
+	senderPublicKey *ecdsa.PublicKey, s *utils.Signature) error {
+	return bc.AddTransaction(t, senderPublicKey, s)
 }
 
-// sender = sender address etc.
-func (bc *Blockchain) AddTransaction(sender string, recipient string, value float32,
-	senderPublicKey *ecdsa.PublicKey, s *utils.Signature) bool {
-	t := Transaction{sender, recipient, value}
+// spends from script addresses carry their own redeem and unlock scripts,
+// every other spend is checked against the sender's signature. the error
+// wraps one of the Err* reasons
+func (bc *Blockchain) AddTransaction(t *Transaction,
+	senderPublicKey *ecdsa.PublicKey, s *utils.Signature) error {
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
 
-	if sender == MINING_SENDER {
-		bc.TransactionPool = append(bc.TransactionPool, &t)
-		return true
+	if senderPublicKey != nil && s != nil {
+		t.SenderPublicKey = fmt.Sprintf("%x", utils.PublicKeyBytes(senderPublicKey))
+		t.Signature = fmt.Sprintf("%x", s.Bytes())
 	}
 
-	if bc.VerifyTransactionSignature(senderPublicKey, s, &t) {
-		// if bc.CalculateTotalAmount(sender) < value {
-		// 	log.Println("ERROR: Not enough Balance in wallet")
-		// 	return false
-		// }
-		bc.TransactionPool = append(bc.TransactionPool, &t)
-		return true
-	} else {
-		log.Println("ERROR: Verification of Transaction Failed")
+	// rewards are only ever created by Mining
+	if t.SenderBlockchainAddress == MINING_SENDER {
+		return ErrMiningSender
+	}
+
+	if t.ChainID != bc.ChainID {
+		return fmt.Errorf("%w: signed for %q, this is %q", ErrWrongChain, t.ChainID, bc.ChainID)
+	}
+
+	if bc.hasSigned(t.SigHash()) {
+		return ErrDuplicateTransaction
+	}
+
+	if !t.IsFinal(int64(len(bc.Chain)), time.Now().Unix()) {
+		return fmt.Errorf("%w until %d", ErrLocked, t.LockTime)
+	}
+
+	if t.RedeemScript != "" || script.IsScriptAddress(t.SenderBlockchainAddress) {
+		if !bc.VerifyTransactionScript(t, int64(len(bc.Chain)), time.Now().Unix()) {
+			return ErrInvalidScript
+		}
+	} else if !bc.verifyStoredSignature(t) {
+		return ErrInvalidSignature
+	}
+
+	if spendable := bc.spendableAmount(t.SenderBlockchainAddress); spendable < t.Value {
+		return fmt.Errorf("%w: spendable %v, sending %v", ErrInsufficientFunds, spendable, t.Value)
+	}
+	bc.TransactionPool = append(bc.TransactionPool, t)
+	bc.notifyTransaction(t)
+	return nil
+}
+
+// the key must be the one the sender address was made from, otherwise
+// anyone could sign for any address with a key of their own
+func (bc *Blockchain) verifyStoredSignature(t *Transaction) bool {
+	if len(t.SenderPublicKey) != 128 || len(t.Signature) != 128 {
+		return false
+	}
+	publicKey := utils.PublicKeyFromString(t.SenderPublicKey)
+	if utils.AddressFromPublicKey(publicKey) != t.SenderBlockchainAddress {
+		return false
+	}
+	s := utils.SignatureFromString(t.Signature)
+	if !s.LowS() {
+		return false
+	}
+	return bc.VerifyTransactionSignature(publicKey, s, t)
+}
+
+// true if a transfer signed over sigHash is in the pool or a block. a
+// signature can be made again in another form, so the transaction id
+// doesn't tell a replay apart
+func (bc *Blockchain) hasSigned(sigHash [32]byte) bool {
+	for _, t := range bc.TransactionPool {
+		if t.SigHash() == sigHash {
+			return true
+		}
+	}
+	for _, b := range bc.Chain {
+		for _, t := range b.Transactions {
+			if t.SenderBlockchainAddress != MINING_SENDER && t.SigHash() == sigHash {
+				return true
+			}
+		}
 	}
 	return false
 }
 
+// true if the transaction is waiting in the pool or already in a block
+func (bc *Blockchain) HasTransaction(hash [32]byte) bool {
+	return bc.GetTransaction(hash) != nil
+}
+
+func (bc *Blockchain) GetTransaction(hash [32]byte) *Transaction {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+	return bc.getTransaction(hash)
+}
+
+func (bc *Blockchain) getTransaction(hash [32]byte) *Transaction {
+	for _, t := range bc.TransactionPool {
+		if t.Hash() == hash {
+			return t
+		}
+	}
+	for _, b := range bc.Chain {
+		for _, t := range b.Transactions {
+			if t.Hash() == hash {
+				return t
+			}
+		}
+	}
+	return nil
+}
+
 func (bc *Blockchain) VerifyTransactionSignature(senderPublicKey *ecdsa.PublicKey,
 	s *utils.Signature, t *Transaction) bool {
-	m, _ := json.Marshal(t)
-	h := sha256.Sum256([]byte(m))
+	h := t.SigHash()
 	return ecdsa.Verify(senderPublicKey, h[:], s.R, s.S)
 
 }
 
+// height and timestamp (unix seconds) are those of the block the spend
+// ends up in, they are what the script lock times are compared against
+func (bc *Blockchain) VerifyTransactionScript(t *Transaction, height int64, timestamp int64) bool {
+	redeem, err := script.FromHex(t.RedeemScript)
+	if err != nil || len(redeem) == 0 {
+		log.Printf("ERROR: invalid redeem script: %v", err)
+		return false
+	}
+	if redeem.Address() != t.SenderBlockchainAddress {
+		log.Println("ERROR: redeem script does not match sender address")
+		return false
+	}
+	unlock, err := script.FromHex(t.UnlockScript)
+	if err != nil {
+		log.Printf("ERROR: invalid unlock script: %v", err)
+		return false
+	}
+	ctx := &script.Context{SigHash: t.SigHash(), Height: height, Time: timestamp}
+	if err := script.Verify(unlock, redeem, ctx); err != nil {
+		log.Printf("ERROR: %v", err)
+		return false
+	}
+	return true
+}
+
 func (bc *Blockchain) CopyTransactionPool() []*Transaction {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+	return bc.copyPool()
+}
+
+func (bc *Blockchain) copyPool() []*Transaction {
 	transactions := make([]*Transaction, 0)
 	for _, t := range bc.TransactionPool {
-		transactions = append(transactions,
-			NewTransaction(t.SenderBlockchainAddress,
-				t.RecipientBlockchainAddress,
-				t.Value))
+		c := *t
+		transactions = append(transactions, &c)
 	}
 	return transactions
 }
 
-func (bc *Blockchain) ValidProof(nonce int, prevHash [32]byte, txns []*Transaction, difficulty int) bool {
+func (bc *Blockchain) ValidProof(header *BlockHeader, difficulty int) bool {
+	return validProof(header, difficulty)
+}
+
+func validProof(header *BlockHeader, difficulty int) bool {
 	zeroes := strings.Repeat("0", difficulty)
-	guessBlock := Block{nonce, prevHash, time.Now().UnixMilli(), txns}
-	guessHash := fmt.Sprintf("%x", guessBlock.Hash())
+	guessHash := fmt.Sprintf("%x", header.Hash())
 	// fmt.Println(guessHash)
 	return guessHash[:difficulty] == zeroes
 }
 
 // func to get the nonce value by trial and error
-func (bc *Blockchain) ProofOfWork() int {
-	txns := bc.CopyTransactionPool()
-	prevHash := bc.LastBlock().Hash()
-	nonce := 0
-	for !bc.ValidProof(nonce, prevHash, txns, MINING_DIFFICULTY) {
-		nonce++
+func (bc *Blockchain) ProofOfWork(timestamp int64) int {
+	bc.mux.RLock()
+	header := &BlockHeader{
+		PrevHash:   bc.lastBlock().Hash(),
+		MerkleRoot: MerkleRoot(bc.copyPool()),
+		Timestamp:  timestamp,
 	}
-	return nonce
+	bc.mux.RUnlock()
+	bc.proofOfWork(header, nil)
+	return header.Nonce
 }
 
-func (bc *Blockchain) Mining() bool {
+// counts up the nonce of header until it has enough work, false if stop
+// closed first. it runs without the lock, the chain can move meanwhile
+func (bc *Blockchain) proofOfWork(header *BlockHeader, stop <-chan struct{}) bool {
+	for !bc.ValidProof(header, bc.genesis.Difficulty) {
+		header.Nonce++
+		if header.Nonce%POW_CHECK_INTERVAL == 0 {
+			select {
+			case <-stop:
+				return false
+			default:
+			}
+		}
+	}
+	return true
+}
 
-	bc.mux.Lock()
-	defer bc.mux.Unlock()
+// checks a header on its own: it must follow prev and carry enough work
+func (bc *Blockchain) ValidHeader(header *BlockHeader, prev *BlockHeader) bool {
+	return bc.genesis.ValidHeader(header, prev)
+}
+
+func (bc *Blockchain) ValidChain(chain []*Block) bool {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+	return bc.validChain(chain)
+}
 
-	if len(bc.TransactionPool) == 0 {
+func (bc *Blockchain) validChain(chain []*Block) bool {
+	for _, b := range chain {
+		if b == nil || b.Malformed() {
+			return false
+		}
+	}
+	if len(chain) == 0 || chain[0].Hash() != bc.genesis.Hash() {
 		return false
 	}
+	balances := make(map[string]float32)
+	applyBalances(balances, chain[0])
+	// ids of the transactions so far, rewards aside. blocks pruned
+	// before a base have no transactions to add
+	seen := make(map[[32]byte]bool)
+	preBlock := chain[0]
+	currentIndex := 1
+	if bc.fromBase(chain) {
+		// blocks up to the snapshot we started from are taken on its
+		// balances, only their headers are checked
+		for ; currentIndex <= bc.base.height; currentIndex++ {
+			if !bc.ValidHeader(chain[currentIndex].Header(), preBlock.Header()) {
+				return false
+			}
+			addSeen(seen, chain[currentIndex])
+			preBlock = chain[currentIndex]
+		}
+		balances = bc.base.copyBalances()
+	}
+	for currentIndex < len(chain) {
+		b := chain[currentIndex]
+		if b.Pruned() || !bc.ValidHeader(b.Header(), preBlock.Header()) {
+			return false
+		}
+		if !bc.validCoinbase(b, currentIndex) {
+			return false
+		}
+		for _, t := range b.Transactions {
+			if t.SenderBlockchainAddress == MINING_SENDER {
+				continue
+			}
+			spendable := balances[t.SenderBlockchainAddress] -
+				immatureAmount(chain, t.SenderBlockchainAddress, currentIndex, bc.genesis.CoinbaseMaturity)
+			if spendable < t.Value {
+				return false
+			}
+			if t.ChainID != bc.ChainID {
+				return false
+			}
+			if !t.IsFinal(int64(currentIndex), b.Timestamp/1000) {
+				return false
+			}
+			if t.RedeemScript == "" && !script.IsScriptAddress(t.SenderBlockchainAddress) {
+				if !bc.verifyStoredSignature(t) {
+					return false
+				}
+			} else if !bc.VerifyTransactionScript(t, int64(currentIndex), b.Timestamp/1000) {
+				return false
+			}
+			// a transfer goes into the chain once. it is keyed on what
+			// is signed, a replay may carry another form of the signature
+			h := t.SigHash()
+			if seen[h] {
+				return false
+			}
+			seen[h] = true
+			balances[t.SenderBlockchainAddress] -= t.Value
+			balances[t.RecipientBlockchainAddress] += t.Value
+		}
+		// rewards only become spendable in later blocks
+		for _, t := range b.Transactions {
+			if t.SenderBlockchainAddress == MINING_SENDER {
+				balances[t.RecipientBlockchainAddress] += t.Value
+			}
+		}
+		preBlock = b
+		currentIndex += 1
+	}
+	return true
+}
 
+// amt plus the coins b creates, added one by one so the sum comes out
+// the same wherever it is taken up
+func addIssued(amt float32, b *Block) float32 {
+	for _, t := range b.Transactions {
+		if t.SenderBlockchainAddress == MINING_SENDER {
+			amt += t.Value
+		}
+	}
+	return amt
+}
+
+func addSeen(seen map[[32]byte]bool, b *Block) {
+	for _, t := range b.Transactions {
+		if t.SenderBlockchainAddress != MINING_SENDER {
+			seen[t.SigHash()] = true
+		}
+	}
+}
+
+func applyBalances(balances map[string]float32, b *Block) {
+	for _, t := range b.Transactions {
+		if t.SenderBlockchainAddress != MINING_SENDER {
+			balances[t.SenderBlockchainAddress] -= t.Value
+		}
+		balances[t.RecipientBlockchainAddress] += t.Value
+	}
+}
+
+func immatureAmount(chain []*Block, bcAddress string, height int, maturity int) float32 {
+	var amt float32 = 0.0
+	for h := max(1, height-maturity+1); h < height; h++ {
+		for _, t := range chain[h].Transactions {
+			if t.SenderBlockchainAddress == MINING_SENDER && t.RecipientBlockchainAddress == bcAddress {
+				amt += t.Value
+			}
+		}
+	}
+	return amt
+}
+
+// a mined block pays exactly the scheduled reward for its height, once
+func (bc *Blockchain) validCoinbase(b *Block, height int) bool {
+	var coinbase []*Transaction
+	for _, t := range b.Transactions {
+		if t.SenderBlockchainAddress == MINING_SENDER {
+			coinbase = append(coinbase, t)
+		}
+	}
+	reward := bc.genesis.Reward(height)
+	if reward == 0 {
+		return len(coinbase) == 0
+	}
+	return len(coinbase) == 1 && coinbase[0].Value == reward
+}
+
+func (bc *Blockchain) Mining() error {
+	return bc.mine(nil)
+}
+
+// mines a block unless stop closes first. the proof of work is done on
+// a copy of the pool without holding the lock, the block is only added
+// if no other one took our tip meanwhile
+func (bc *Blockchain) mine(stop <-chan struct{}) error {
+	settings := bc.MiningSettings()
+	bc.mux.RLock()
+	if len(bc.TransactionPool) == 0 && !settings.MineEmpty {
+		bc.mux.RUnlock()
+		return ErrNothingToMine
+	}
+	txns := bc.copyPool()
 	//while rewarding the miner there is no transaction
-	bc.AddTransaction(MINING_SENDER, bc.BlockchainAddress, MINING_REWARD, nil, nil)
-	nonce := bc.ProofOfWork()
-	prevHash := bc.LastBlock().Hash()
-	bc.CreateBlock(nonce, prevHash)
+	if reward := bc.genesis.Reward(len(bc.Chain)); reward > 0 {
+		txns = append(txns, NewTransaction(MINING_SENDER, settings.Address, reward))
+	}
+	header := &BlockHeader{
+		PrevHash:   bc.lastBlock().Hash(),
+		MerkleRoot: MerkleRoot(txns),
+		Timestamp:  time.Now().UnixMilli(),
+	}
+	bc.mux.RUnlock()
+
+	start := time.Now()
+	if !bc.proofOfWork(header, stop) {
+		// every nonce below the current one was tried
+		bc.recordWork(int64(header.Nonce), time.Since(start), nil)
+		log.Println("action=Mining, status=aborted")
+		return ErrMiningAborted
+	}
+	hashes, elapsed := int64(header.Nonce)+1, time.Since(start)
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+	if bc.lastBlock().Hash() != header.PrevHash {
+		bc.recordWork(hashes, elapsed, nil)
+		log.Println("action=Mining, status=stale")
+		return ErrStaleBlock
+	}
+	b := NewBlock(header.Nonce, header.PrevHash, header.Timestamp, txns)
+	bc.setChain(append(bc.Chain[:len(bc.Chain):len(bc.Chain)], b))
+	bc.removeFromPool(txns)
+	bc.recordWork(hashes, elapsed, b)
 	log.Println("action=Mining, status=success")
+	bc.notifyBlock(b, len(bc.Chain)-1)
+	return nil
+}
+
+// appends a block received from a peer if it extends our tip and the
+// chain stays valid with it, its transactions leave the pool
+func (bc *Blockchain) AddBlock(b *Block) bool {
+	if err := bc.SubmitBlock(b); err != nil {
+		log.Printf("ERROR: %v", err)
+		return false
+	}
+	return true
+}
+
+func (bc *Blockchain) removeFromPool(txns []*Transaction) {
+	included := make(map[[32]byte]bool)
+	for _, t := range txns {
+		included[t.SigHash()] = true
+	}
+	pool := make([]*Transaction, 0, len(bc.TransactionPool))
+	for _, t := range bc.TransactionPool {
+		if !included[t.SigHash()] {
+			pool = append(pool, t)
+		}
+	}
+	bc.TransactionPool = pool
+}
+
+func (bc *Blockchain) Height() int {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+	return len(bc.Chain) - 1
+}
+
+// the chain as it is now. it is never changed in place, so it can be
+// read after the lock is gone
+func (bc *Blockchain) Blocks() []*Block {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+	return bc.Chain
+}
+
+// the block at height, nil past the tip
+func (bc *Blockchain) BlockAt(height int) *Block {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+	if height < 0 || height >= len(bc.Chain) {
+		return nil
+	}
+	return bc.Chain[height]
+}
+
+func (bc *Blockchain) GetBlock(hash [32]byte) *Block {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+	if h := bc.blockHeight(hash); h >= 0 {
+		return bc.Chain[h]
+	}
+	return nil
+}
+
+// height of the block with hash in our chain, -1 if it's not in it
+func (bc *Blockchain) GetBlockHeight(hash [32]byte) int {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+	return bc.blockHeight(hash)
+}
+
+func (bc *Blockchain) blockHeight(hash [32]byte) int {
+	for i := len(bc.Chain) - 1; i >= 0; i-- {
+		if bc.Chain[i].Hash() == hash {
+			return i
+		}
+	}
+	return -1
+}
+
+// swaps our chain for a longer valid one with the same genesis. blocks
+// past the fork point are announced like freshly added ones
+func (bc *Blockchain) ReplaceChain(chain []*Block) bool {
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+
+	if len(chain) <= len(bc.Chain) || !bc.validChain(chain) {
+		return false
+	}
+	fork := 0
+	for fork < len(bc.Chain) && chain[fork].Hash() == bc.Chain[fork].Hash() {
+		fork++
+	}
+	old := bc.Chain
+	bc.setChain(chain)
+	for _, b := range chain[fork:] {
+		bc.removeFromPool(b.Transactions)
+	}
+	log.Printf("action=ReplaceChain, fork=%d, height=%d", fork-1, len(bc.Chain)-1)
+	if fork < len(old) {
+		bc.notifyReorg(&ReorgEvent{
+			ForkHeight:   fork - 1,
+			OldTip:       fmt.Sprintf("%x", old[len(old)-1].Hash()),
+			NewTip:       fmt.Sprintf("%x", chain[len(chain)-1].Hash()),
+			Disconnected: len(old) - fork,
+		})
+	}
+	for i, b := range chain[fork:] {
+		bc.notifyBlock(b, fork+i)
+	}
 	return true
 }
 
-func (bc *Blockchain) StartMining() {
-	bc.Mining()
-	_ = time.AfterFunc(time.Second*MINING_TIMER_SEC, bc.StartMining)
+// handlers are called after a block joins the chain, with its height,
+// or a transaction joins the pool, with the height of the tip. they run
+// with the chain locked: they must not block and must not call the
+// methods that lock it, bc.Chain they may read
+func (bc *Blockchain) OnBlock(f func(*Block, int)) {
+	bc.blockHandlers = append(bc.blockHandlers, f)
+}
+
+func (bc *Blockchain) OnTransaction(f func(*Transaction, int)) {
+	bc.txHandlers = append(bc.txHandlers, f)
+}
+
+// called when blocks of our chain are replaced by another branch,
+// before the blocks of the new branch are announced
+func (bc *Blockchain) OnReorg(f func(*ReorgEvent)) {
+	bc.reorgHandlers = append(bc.reorgHandlers, f)
+}
+
+func (bc *Blockchain) notifyReorg(r *ReorgEvent) {
+	for _, f := range bc.reorgHandlers {
+		f(r)
+	}
+}
+
+func (bc *Blockchain) notifyBlock(b *Block, height int) {
+	for _, f := range bc.blockHandlers {
+		f(b, height)
+	}
+}
+
+func (bc *Blockchain) notifyTransaction(t *Transaction) {
+	for _, f := range bc.txHandlers {
+		f(t, len(bc.Chain)-1)
+	}
 }
 
 // total transactions for the bcAdress node
 func (bc *Blockchain) CalculateTotalAmount(bcAddress string) float32 {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+	return bc.totalAmount(bcAddress)
+}
+
+func (bc *Blockchain) totalAmount(bcAddress string) float32 {
 	var amt float32 = 0.0
-	for _, b := range bc.Chain {
+	from := 0
+	if bc.base != nil {
+		amt = bc.base.balances[bcAddress]
+		from = bc.base.height + 1
+	}
+	for _, b := range bc.Chain[from:] {
 		for _, t := range b.Transactions {
 			v := t.Value
 			if bcAddress == t.RecipientBlockchainAddress {
@@ -194,56 +743,191 @@ func (bc *Blockchain) CalculateTotalAmount(bcAddress string) float32 {
 	return amt
 }
 
-func NewBlock(nonce int, prevHash [32]byte, txns []*Transaction) *Block {
+// part of the balance that comes from mining rewards without
+// CoinbaseMaturity confirmations, as seen by a block at height
+func (bc *Blockchain) CalculateImmatureAmount(bcAddress string, height int) float32 {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+	return immatureAmount(bc.Chain, bcAddress, min(height, len(bc.Chain)), bc.genesis.CoinbaseMaturity)
+}
+
+// what the address can spend in the next block: the balance without
+// immature rewards and without what is already being spent in the pool
+func (bc *Blockchain) CalculateSpendableAmount(bcAddress string) float32 {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+	return bc.spendableAmount(bcAddress)
+}
+
+func (bc *Blockchain) spendableAmount(bcAddress string) float32 {
+	amt := bc.totalAmount(bcAddress) -
+		immatureAmount(bc.Chain, bcAddress, len(bc.Chain), bc.genesis.CoinbaseMaturity)
+	for _, t := range bc.TransactionPool {
+		if t.SenderBlockchainAddress == bcAddress {
+			amt -= t.Value
+		}
+	}
+	return amt
+}
+
+// up to max headers of the chain starting at height from
+func (bc *Blockchain) Headers(from int, max int) []*BlockHeader {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+	headers := make([]*BlockHeader, 0)
+	for i := from; i >= 0 && i < len(bc.Chain) && len(headers) < max; i++ {
+		headers = append(headers, bc.Chain[i].Header())
+	}
+	return headers
+}
+
+// every confirmed transaction from or to bcAddress, each with a merkle
+// proof against the header of its block
+func (bc *Blockchain) ProveTransactions(bcAddress string) []*ProvenTransaction {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+	proven := make([]*ProvenTransaction, 0)
+	for height, b := range bc.Chain {
+		for i, t := range b.Transactions {
+			if t.SenderBlockchainAddress != bcAddress && t.RecipientBlockchainAddress != bcAddress {
+				continue
+			}
+			proven = append(proven, &ProvenTransaction{
+				Transaction: t,
+				BlockHash:   b.Hash(),
+				Height:      height,
+				Proof:       NewMerkleProof(b.Transactions, i),
+			})
+		}
+	}
+	return proven
+}
+
+// coins created so far by the genesis allocations and mining rewards
+func (bc *Blockchain) CirculatingSupply() float32 {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+	var amt float32 = 0.0
+	from := 0
+	if bc.base != nil {
+		amt = bc.base.supply
+		from = bc.base.height + 1
+	}
+	for _, b := range bc.Chain[from:] {
+		amt = addIssued(amt, b)
+	}
+	return amt
+}
+
+func NewBlock(nonce int, prevHash [32]byte, timestamp int64, txns []*Transaction) *Block {
 	return &Block{
-		Timestamp:    time.Now().UnixMilli(),
+		Timestamp:    timestamp,
 		Nonce:        nonce,
 		PrevHash:     prevHash,
 		Transactions: txns,
 	}
 }
 
-func NewBlockChain(BlockchainAddress string, port uint16) *Blockchain {
-	b := new(Block)
+func NewBlockChain(BlockchainAddress string, port uint16, genesis *Genesis) *Blockchain {
 	bc := new(Blockchain)
 	bc.BlockchainAddress = BlockchainAddress
-	bc.CreateBlock(0, b.Hash())
+	bc.ChainID = genesis.ChainID
+	bc.genesis = genesis
+	bc.Chain = []*Block{genesis.Block()}
 	bc.Port = port
+	bc.miner.intervalSec = MINING_TIMER_SEC
 	return bc
 }
 
 func (bc *Blockchain) LastBlock() *Block {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+	return bc.lastBlock()
+}
+
+func (bc *Blockchain) lastBlock() *Block {
 	return bc.Chain[len(bc.Chain)-1]
 }
 
+// the chain and the pool as they are at one moment
+func (bc *Blockchain) MarshalJSON() ([]byte, error) {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+	return json.Marshal(struct {
+		TransactionPool   []*Transaction `json:"TransactionPool"`
+		Chain             []*Block       `json:"Chain"`
+		BlockchainAddress string         `json:"BlockchainAddress"`
+		ChainID           string         `json:"ChainID"`
+		Port              uint16
+	}{
+		TransactionPool:   bc.TransactionPool,
+		Chain:             bc.Chain,
+		BlockchainAddress: bc.BlockchainAddress,
+		ChainID:           bc.ChainID,
+		Port:              bc.Port,
+	})
+}
+
 func (b *Block) Hash() [32]byte {
-	m, err := json.Marshal(b)
-	// fmt.Println(string(m))
-	if err != nil {
-		return [32]byte{}
-	}
-	return sha256.Sum256([]byte(m))
+	return b.Header().Hash()
 }
 
 func (tr *TransactionRequest) Validate() bool {
-	if tr.SenderBlockchainAddress == nil ||
-		tr.RecipientBlockchainAddress == nil ||
-		tr.SenderPublicKey == nil ||
-		tr.Value == nil ||
-		tr.Signature == nil {
-		return false
+	return len(tr.MissingFields()) == 0
+}
+
+// json names of the required fields that are not set. script spends
+// carry scripts instead of a public key and signature
+func (tr *TransactionRequest) MissingFields() []string {
+	missing := make([]string, 0)
+	if tr.SenderBlockchainAddress == nil {
+		missing = append(missing, "sender_blockchain_address")
 	}
-	return true
+	if tr.RecipientBlockchainAddress == nil {
+		missing = append(missing, "recipient_blockchain_address")
+	}
+	if tr.Value == nil {
+		missing = append(missing, "value")
+	}
+	if tr.RedeemScript != nil {
+		if tr.UnlockScript == nil {
+			missing = append(missing, "unlock_script")
+		}
+		return missing
+	}
+	if tr.SenderPublicKey == nil {
+		missing = append(missing, "sender_public_key")
+	}
+	if tr.Signature == nil {
+		missing = append(missing, "signature")
+	}
+	return missing
 }
 
+// Amount is the full balance, Immature the part of it that comes from
+// mining rewards that can't be spent yet
 type AmountResponse struct {
-	Amount float32 `json:"amount"`
+	Amount    float32 `json:"amount"`
+	Immature  float32 `json:"immature"`
+	Spendable float32 `json:"spendable"`
 }
 
 func (ar *AmountResponse) MarshalJSON() ([]byte, error) {
 	return json.Marshal(struct {
-		Amount float32 `json:"amount"`
+		Amount    float32 `json:"amount"`
+		Immature  float32 `json:"immature"`
+		Spendable float32 `json:"spendable"`
 	}{
-		Amount: ar.Amount,
+		Amount:    ar.Amount,
+		Immature:  ar.Immature,
+		Spendable: ar.Spendable,
 	})
 }
+
+type NetworkResponse struct {
+	ChainID     string `json:"chain_id"`
+	GenesisHash string `json:"genesis_hash"`
+	Height      int    `json:"height"`
+	// lowest height whose block is served, 0 on a node keeping all
+	PruneHeight int `json:"prune_height"`
+}
@@ -0,0 +1,35 @@
+package block
+
+import "errors"
+
+// reasons a transaction is turned away by AddTransaction
+var (
+	ErrMiningSender         = errors.New("transactions from the mining sender are not accepted")
+	ErrWrongChain           = errors.New("transaction signed for another chain")
+	ErrDuplicateTransaction = errors.New("transaction already known")
+	ErrLocked               = errors.New("transaction is locked")
+	ErrInvalidScript        = errors.New("script verification failed")
+	ErrInvalidSignature     = errors.New("invalid signature")
+	ErrInsufficientFunds    = errors.New("not enough balance")
+)
+
+// reasons a submitted block is turned away by SubmitBlock
+var (
+	ErrStaleBlock   = errors.New("block does not extend the tip")
+	ErrInvalidBlock = errors.New("invalid block")
+)
+
+// reasons Mining doesn't add a block, ErrStaleBlock when another one
+// took the tip during the proof of work
+var (
+	ErrNothingToMine = errors.New("no transactions to mine")
+	ErrMiningAborted = errors.New("mining stopped")
+)
+
+// reasons exports and snapshots can't be written or read
+var (
+	ErrBlockPruned     = errors.New("block body is not available")
+	ErrCorruptExport   = errors.New("corrupt export")
+	ErrInvalidSnapshot = errors.New("invalid snapshot")
+	ErrMalformed       = errors.New("null header or block")
+)
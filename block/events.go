@@ -0,0 +1,65 @@
+package block
+
+import "fmt"
+
+// event types streamed to subscribers of a node
+const (
+	EVENT_BLOCK   = "block"
+	EVENT_TX      = "tx"
+	EVENT_REORG   = "reorg"
+	EVENT_ADDRESS = "address"
+)
+
+type BlockEvent struct {
+	Height       int    `json:"height"`
+	Hash         string `json:"hash"`
+	PrevHash     string `json:"prev_hash"`
+	Timestamp    int64  `json:"timestamp"`
+	Transactions int    `json:"transactions"`
+}
+
+// a transaction that joined the pool
+type TxEvent struct {
+	TxID        string       `json:"txid"`
+	Transaction *Transaction `json:"transaction"`
+}
+
+// the chain switched to another branch. blocks above ForkHeight were
+// taken off, the new branch's blocks follow as block events
+type ReorgEvent struct {
+	ForkHeight   int    `json:"fork_height"`
+	OldTip       string `json:"old_tip"`
+	NewTip       string `json:"new_tip"`
+	Disconnected int    `json:"disconnected"`
+}
+
+// a transaction touching Address, pending with Height -1, or confirmed
+type AddressEvent struct {
+	Address     string       `json:"address"`
+	TxID        string       `json:"txid"`
+	Height      int          `json:"height"`
+	Confirmed   bool         `json:"confirmed"`
+	Transaction *Transaction `json:"transaction"`
+}
+
+func NewBlockEvent(b *Block, height int) *BlockEvent {
+	return &BlockEvent{
+		Height:       height,
+		Hash:         fmt.Sprintf("%x", b.Hash()),
+		PrevHash:     fmt.Sprintf("%x", b.PrevHash),
+		Timestamp:    b.Timestamp,
+		Transactions: len(b.Transactions),
+	}
+}
+
+// addresses a transaction moves coins between, the mining sender isn't one
+func (t *Transaction) Addresses() []string {
+	addrs := make([]string, 0, 2)
+	if t.SenderBlockchainAddress != MINING_SENDER {
+		addrs = append(addrs, t.SenderBlockchainAddress)
+	}
+	if t.RecipientBlockchainAddress != t.SenderBlockchainAddress {
+		addrs = append(addrs, t.RecipientBlockchainAddress)
+	}
+	return addrs
+}
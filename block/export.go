@@ -0,0 +1,210 @@
+package block
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+)
+
+// an export is JSON Lines: an ExportHeader, then an ExportEntry per
+// block from the genesis block on
+const (
+	EXPORT_FORMAT  = "blockchain-export"
+	EXPORT_VERSION = 1
+	// longest line, one block, an export is read with
+	MAX_EXPORT_LINE = 64 << 20
+)
+
+type ExportHeader struct {
+	Format      string `json:"format"`
+	Version     int    `json:"version"`
+	ChainID     string `json:"chain_id"`
+	GenesisHash string `json:"genesis_hash"`
+	Height      int    `json:"height"`
+}
+
+// Checksum is the sha256 of Block as written
+type ExportEntry struct {
+	Height   int             `json:"height"`
+	Hash     string          `json:"hash"`
+	Checksum string          `json:"checksum"`
+	Block    json.RawMessage `json:"block"`
+}
+
+// writes the whole chain to w. nothing is written if a block body is
+// missing
+func (bc *Blockchain) Export(w io.Writer) error {
+	chain := bc.Blocks()
+
+	for h, b := range chain {
+		if b.Pruned() {
+			return fmt.Errorf("%w: height %d", ErrBlockPruned, h)
+		}
+	}
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	err := enc.Encode(&ExportHeader{
+		Format:      EXPORT_FORMAT,
+		Version:     EXPORT_VERSION,
+		ChainID:     bc.ChainID,
+		GenesisHash: fmt.Sprintf("%x", bc.genesis.Hash()),
+		Height:      len(chain) - 1,
+	})
+	if err != nil {
+		return err
+	}
+	for h, b := range chain {
+		m, err := json.Marshal(b)
+		if err != nil {
+			return err
+		}
+		err = enc.Encode(&ExportEntry{
+			Height:   h,
+			Hash:     fmt.Sprintf("%x", b.Hash()),
+			Checksum: fmt.Sprintf("%x", sha256.Sum256(m)),
+			Block:    m,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// reads the blocks of an export in order, checking each one's checksum,
+// hash and link to the one before. whether the blocks are valid is left
+// to ValidChain
+type ExportReader struct {
+	Header  *ExportHeader
+	scanner *bufio.Scanner
+	line    int
+	height  int
+	prev    [32]byte
+}
+
+func NewExportReader(r io.Reader) (*ExportReader, error) {
+	er := &ExportReader{scanner: bufio.NewScanner(r)}
+	er.scanner.Buffer(make([]byte, 64*1024), MAX_EXPORT_LINE)
+	er.Header = &ExportHeader{}
+	if err := er.scan(er.Header); err != nil {
+		if err == io.EOF {
+			return nil, fmt.Errorf("%w: empty file", ErrCorruptExport)
+		}
+		return nil, err
+	}
+	if er.Header.Format != EXPORT_FORMAT || er.Header.Version != EXPORT_VERSION {
+		return nil, fmt.Errorf("%w: not a version %d export", ErrCorruptExport, EXPORT_VERSION)
+	}
+	return er, nil
+}
+
+func (er *ExportReader) scan(v interface{}) error {
+	if !er.scanner.Scan() {
+		if err := er.scanner.Err(); err != nil {
+			return fmt.Errorf("%w: line %d: %v", ErrCorruptExport, er.line+1, err)
+		}
+		return io.EOF
+	}
+	er.line++
+	if err := json.Unmarshal(er.scanner.Bytes(), v); err != nil {
+		return fmt.Errorf("%w: line %d: %v", ErrCorruptExport, er.line, err)
+	}
+	return nil
+}
+
+// the next block, io.EOF after the last one the header announced
+func (er *ExportReader) Next() (*Block, error) {
+	var e ExportEntry
+	err := er.scan(&e)
+	if err == io.EOF {
+		if er.height != er.Header.Height+1 {
+			return nil, fmt.Errorf("%w: ends at height %d, the header says %d", ErrCorruptExport, er.height-1, er.Header.Height)
+		}
+		return nil, io.EOF
+	}
+	if err != nil {
+		return nil, err
+	}
+	if e.Height != er.height {
+		return nil, fmt.Errorf("%w: line %d: height %d, %d expected", ErrCorruptExport, er.line, e.Height, er.height)
+	}
+	if fmt.Sprintf("%x", sha256.Sum256(e.Block)) != e.Checksum {
+		return nil, fmt.Errorf("%w: block %d: checksum mismatch", ErrCorruptExport, e.Height)
+	}
+	var b Block
+	if err := json.Unmarshal(e.Block, &b); err != nil {
+		return nil, fmt.Errorf("%w: block %d: %v", ErrCorruptExport, e.Height, err)
+	}
+	hash := b.Hash()
+	if fmt.Sprintf("%x", hash) != e.Hash {
+		return nil, fmt.Errorf("%w: block %d: hash mismatch", ErrCorruptExport, e.Height)
+	}
+	if e.Height > 0 && b.PrevHash != er.prev {
+		return nil, fmt.Errorf("%w: block %d doesn't follow block %d", ErrCorruptExport, e.Height, e.Height-1)
+	}
+	er.height++
+	er.prev = hash
+	return &b, nil
+}
+
+// height of the block Next returned last
+func (er *ExportReader) Height() int {
+	return er.height - 1
+}
+
+// adds the blocks of an export past our tip. blocks we already have
+// must be ours, the new ones are validated together with our chain and
+// announced like mined ones. returns the number of blocks added
+func (bc *Blockchain) Import(er *ExportReader) (int, error) {
+	if er.Header.ChainID != bc.ChainID || er.Header.GenesisHash != fmt.Sprintf("%x", bc.genesis.Hash()) {
+		return 0, fmt.Errorf("the export is of chain %s, not %s", er.Header.ChainID, bc.ChainID)
+	}
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+
+	chain := bc.Chain[:len(bc.Chain):len(bc.Chain)]
+	for {
+		b, err := er.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+		h := er.Height()
+		if h < len(bc.Chain) {
+			if b.Hash() != bc.Chain[h].Hash() {
+				return 0, fmt.Errorf("the export leaves our chain at height %d", h)
+			}
+			continue
+		}
+		chain = append(chain, b)
+	}
+	added := chain[len(bc.Chain):]
+	if len(added) == 0 {
+		return 0, nil
+	}
+	if !bc.validChain(chain) {
+		// a chain stays invalid once a block is, the first bad one is
+		// where the prefixes stop being valid
+		bad := len(bc.Chain) + sort.Search(len(added), func(n int) bool {
+			return !bc.validChain(chain[:len(bc.Chain)+n+1])
+		})
+		return 0, fmt.Errorf("%w: block %d %x", ErrInvalidBlock, bad, chain[bad].Hash())
+	}
+	from := len(bc.Chain)
+	bc.setChain(chain)
+	for _, b := range added {
+		bc.removeFromPool(b.Transactions)
+	}
+	log.Printf("action=Import, blocks=%d, height=%d", len(added), len(bc.Chain)-1)
+	for i, b := range added {
+		bc.notifyBlock(b, from+i)
+	}
+	return len(added), nil
+}
@@ -0,0 +1,216 @@
+package block
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"time"
+)
+
+type Allocation struct {
+	Address string  `json:"address"`
+	Value   float32 `json:"value"`
+}
+
+// everything a node needs to agree on the first block and the consensus
+// parameters of a network. Timestamp is in milliseconds like Block.Timestamp.
+// MiningReward is the reward of the first block, it halves every
+// HalvingInterval blocks (never if zero) until MaxSupply (unlimited if zero)
+// coins exist, counting the allocations. Rewards can only be spent once
+// they have CoinbaseMaturity confirmations
+type Genesis struct {
+	ChainID          string       `json:"chain_id"`
+	Timestamp        int64        `json:"timestamp"`
+	Difficulty       int          `json:"difficulty"`
+	MiningReward     float32      `json:"mining_reward"`
+	HalvingInterval  int          `json:"halving_interval"`
+	MaxSupply        float32      `json:"max_supply"`
+	CoinbaseMaturity int          `json:"coinbase_maturity"`
+	Allocations      []Allocation `json:"allocations"`
+}
+
+// rewards below this are not paid, it ends the emission of a halving schedule
+const MIN_REWARD = 1e-8
+
+var MainnetGenesis = &Genesis{
+	ChainID:          "mainnet",
+	Timestamp:        1719792000000,
+	Difficulty:       MINING_DIFFICULTY,
+	MiningReward:     MINING_REWARD,
+	HalvingInterval:  100000,
+	MaxSupply:        200000,
+	CoinbaseMaturity: 10,
+	Allocations:      []Allocation{},
+}
+
+var TestnetGenesis = &Genesis{
+	ChainID:          "testnet",
+	Timestamp:        1719792000000,
+	Difficulty:       2,
+	MiningReward:     MINING_REWARD,
+	HalvingInterval:  1000,
+	MaxSupply:        2000,
+	CoinbaseMaturity: 3,
+	Allocations:      []Allocation{},
+}
+
+// genesis hashes of the built in networks, a spec file claiming one of
+// these chain ids has to produce the same genesis block
+var genesisHashes = map[string]string{
+	"mainnet": "cc9251254bf19a9b6239f15075d056e2139248f1d5e4a96a1ef46d4a01462425",
+	"testnet": "b9ff6874b39600c2faaece301e9424c9c64026558f4e99df4f2beccb500d9fe8",
+}
+
+func GenesisForNetwork(network string) (*Genesis, bool) {
+	switch network {
+	case "mainnet":
+		return MainnetGenesis, true
+	case "testnet":
+		return TestnetGenesis, true
+	}
+	return nil, false
+}
+
+func LoadGenesis(path string) (*Genesis, error) {
+	f, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var g Genesis
+	if err := json.Unmarshal(f, &g); err != nil {
+		return nil, fmt.Errorf("genesis %s: %w", path, err)
+	}
+	if err := g.Validate(); err != nil {
+		return nil, fmt.Errorf("genesis %s: %w", path, err)
+	}
+	return &g, nil
+}
+
+func (g *Genesis) Validate() error {
+	if g.ChainID == "" {
+		return errors.New("chain_id is required")
+	}
+	if g.Timestamp <= 0 {
+		return errors.New("timestamp must be positive")
+	}
+	if g.Difficulty < 1 || g.Difficulty > 64 {
+		return fmt.Errorf("difficulty %d out of range 1-64", g.Difficulty)
+	}
+	if g.MiningReward < 0 {
+		return errors.New("mining_reward can't be negative")
+	}
+	if g.HalvingInterval < 0 {
+		return errors.New("halving_interval can't be negative")
+	}
+	if g.MaxSupply < 0 {
+		return errors.New("max_supply can't be negative")
+	}
+	if g.CoinbaseMaturity < 0 {
+		return errors.New("coinbase_maturity can't be negative")
+	}
+	for _, a := range g.Allocations {
+		if a.Address == "" || a.Value <= 0 {
+			return fmt.Errorf("invalid allocation %+v", a)
+		}
+	}
+	if h, ok := genesisHashes[g.ChainID]; ok && h != fmt.Sprintf("%x", g.Hash()) {
+		return fmt.Errorf("genesis hash %x does not match network %s", g.Hash(), g.ChainID)
+	}
+	return nil
+}
+
+// the genesis block only depends on the spec, so every node of a
+// network starts from the same block. its PrevHash commits to the whole
+// spec, so networks with different parameters never share a genesis
+func (g *Genesis) Block() *Block {
+	txns := make([]*Transaction, 0, len(g.Allocations))
+	for _, a := range g.Allocations {
+		txns = append(txns, NewTransaction(MINING_SENDER, a.Address, a.Value))
+	}
+	return &Block{
+		Nonce:        0,
+		PrevHash:     g.specHash(),
+		Timestamp:    g.Timestamp,
+		Transactions: txns,
+	}
+}
+
+func (g *Genesis) specHash() [32]byte {
+	m, _ := json.Marshal(g)
+	return sha256.Sum256(m)
+}
+
+func (g *Genesis) Hash() [32]byte {
+	return g.Block().Hash()
+}
+
+// checks a header on its own: it must follow prev and carry enough work.
+// light clients use this without a Blockchain
+func (g *Genesis) ValidHeader(header *BlockHeader, prev *BlockHeader) bool {
+	if header.PrevHash != prev.Hash() {
+		return false
+	}
+	if header.Timestamp > time.Now().Add(MAX_FUTURE_BLOCK_TIME).UnixMilli() {
+		return false
+	}
+	return validProof(header, g.Difficulty)
+}
+
+// reward of the block at height before the supply cap is applied
+func (g *Genesis) baseReward(height int) float64 {
+	if height <= 0 {
+		return 0
+	}
+	r := float64(g.MiningReward)
+	if g.HalvingInterval > 0 {
+		halvings := (height - 1) / g.HalvingInterval
+		if halvings >= 64 {
+			return 0
+		}
+		r = r / math.Pow(2, float64(halvings))
+	}
+	if r < MIN_REWARD {
+		return 0
+	}
+	return r
+}
+
+// coins in existence after the block at height, genesis allocations included
+func (g *Genesis) Supply(height int) float64 {
+	var s float64
+	for _, a := range g.Allocations {
+		s += float64(a.Value)
+	}
+	for h := 1; h <= height; {
+		r := g.baseReward(h)
+		if r == 0 {
+			break
+		}
+		// whole halving era at once
+		end := height
+		if g.HalvingInterval > 0 {
+			end = min(((h-1)/g.HalvingInterval+1)*g.HalvingInterval, height)
+		}
+		s += r * float64(end-h+1)
+		h = end + 1
+	}
+	if g.MaxSupply > 0 && s > float64(g.MaxSupply) {
+		s = float64(g.MaxSupply)
+	}
+	return s
+}
+
+// coinbase value of the block at height
+func (g *Genesis) Reward(height int) float32 {
+	if height <= 0 {
+		return 0
+	}
+	r := g.Supply(height) - g.Supply(height-1)
+	if r < MIN_REWARD {
+		return 0
+	}
+	return float32(r)
+}
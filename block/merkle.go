@@ -0,0 +1,155 @@
+package block
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+)
+
+// what the proof of work and the chain links commit to. the transactions
+// are only committed through MerkleRoot, so headers can be checked
+// without downloading block bodies
+type BlockHeader struct {
+	PrevHash   [32]byte `json:"prev_hash"`
+	MerkleRoot [32]byte `json:"merkle_root"`
+	Timestamp  int64    `json:"timestamp"`
+	Nonce      int      `json:"nonce"`
+}
+
+func (h *BlockHeader) Hash() [32]byte {
+	m, _ := json.Marshal(h)
+	return sha256.Sum256(m)
+}
+
+func (b *Block) Header() *BlockHeader {
+	root := MerkleRoot(b.Transactions)
+	if b.Pruned() {
+		root = *b.Root
+	}
+	return &BlockHeader{
+		PrevHash:   b.PrevHash,
+		MerkleRoot: root,
+		Timestamp:  b.Timestamp,
+		Nonce:      b.Nonce,
+	}
+}
+
+// true for a block kept without its transactions, it still hashes like
+// the full block but its body can't be checked or served
+func (b *Block) Pruned() bool {
+	return b.Root != nil
+}
+
+// true for a block with null blocks in place of transactions, only a
+// broken or hostile sender makes one and nothing may dereference them
+func (b *Block) Malformed() bool {
+	for _, t := range b.Transactions {
+		if t == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// a block without body that hashes like the one of header
+func HeaderBlock(header *BlockHeader) *Block {
+	root := header.MerkleRoot
+	return &Block{
+		Nonce:     header.Nonce,
+		PrevHash:  header.PrevHash,
+		Timestamp: header.Timestamp,
+		Root:      &root,
+	}
+}
+
+func merkleParent(left [32]byte, right [32]byte) [32]byte {
+	first := sha256.Sum256(append(left[:], right[:]...))
+	return sha256.Sum256(first[:])
+}
+
+// bitcoin style merkle tree over the transaction hashes, the last hash
+// of an odd level is paired with itself. no transactions gives a zero root
+func MerkleRoot(txns []*Transaction) [32]byte {
+	if len(txns) == 0 {
+		return [32]byte{}
+	}
+	level := make([][32]byte, len(txns))
+	for i, t := range txns {
+		level[i] = t.Hash()
+	}
+	for len(level) > 1 {
+		if len(level)%2 == 1 {
+			level = append(level, level[len(level)-1])
+		}
+		next := make([][32]byte, len(level)/2)
+		for i := range next {
+			next[i] = merkleParent(level[2*i], level[2*i+1])
+		}
+		level = next
+	}
+	return level[0]
+}
+
+// the hashes needed to get from a transaction up to the merkle root,
+// lowest level first
+type MerkleProof struct {
+	TxHash   [32]byte   `json:"tx_hash"`
+	Index    int        `json:"index"`
+	Siblings [][32]byte `json:"siblings"`
+}
+
+func NewMerkleProof(txns []*Transaction, index int) *MerkleProof {
+	if index < 0 || index >= len(txns) {
+		return nil
+	}
+	level := make([][32]byte, len(txns))
+	for i, t := range txns {
+		level[i] = t.Hash()
+	}
+	p := &MerkleProof{TxHash: level[index], Index: index, Siblings: make([][32]byte, 0)}
+	for i := index; len(level) > 1; i /= 2 {
+		if len(level)%2 == 1 {
+			level = append(level, level[len(level)-1])
+		}
+		p.Siblings = append(p.Siblings, level[i^1])
+		next := make([][32]byte, len(level)/2)
+		for j := range next {
+			next[j] = merkleParent(level[2*j], level[2*j+1])
+		}
+		level = next
+	}
+	return p
+}
+
+// true if the proof leads from TxHash to root
+func (p *MerkleProof) Verify(root [32]byte) bool {
+	if p.Index < 0 || p.Index >= 1<<len(p.Siblings) {
+		return false
+	}
+	h := p.TxHash
+	for i, s := range p.Siblings {
+		if p.Index>>i&1 == 1 {
+			h = merkleParent(s, h)
+		} else {
+			h = merkleParent(h, s)
+		}
+	}
+	return h == root
+}
+
+// a confirmed transaction along with what a light client needs to check
+// it against a header it already has
+type ProvenTransaction struct {
+	Transaction *Transaction `json:"transaction"`
+	BlockHash   [32]byte     `json:"block_hash"`
+	Height      int          `json:"height"`
+	Proof       *MerkleProof `json:"proof"`
+}
+
+// true if the transaction is the one in the proof and the proof leads to
+// the merkle root of header
+func (pt *ProvenTransaction) Verify(header *BlockHeader) bool {
+	if pt.Transaction == nil || pt.Proof == nil || header.Hash() != pt.BlockHash {
+		return false
+	}
+	return pt.Transaction.Hash() == pt.Proof.TxHash && pt.Proof.Verify(header.MerkleRoot)
+}
@@ -0,0 +1,124 @@
+package block_test
+
+import (
+	"blockchain/block"
+	"crypto/sha256"
+	"fmt"
+	"testing"
+)
+
+func transactions(n int) []*block.Transaction {
+	txns := make([]*block.Transaction, n)
+	for i := range txns {
+		txns[i] = block.NewTransaction("sender", fmt.Sprintf("recipient%d", i), float32(i+1))
+	}
+	return txns
+}
+
+func parent(left [32]byte, right [32]byte) [32]byte {
+	first := sha256.Sum256(append(left[:], right[:]...))
+	return sha256.Sum256(first[:])
+}
+
+func TestMerkleRoot(t *testing.T) {
+	txns := transactions(3)
+	a, b, c := txns[0].Hash(), txns[1].Hash(), txns[2].Hash()
+	tests := []struct {
+		name string
+		txns []*block.Transaction
+		root [32]byte
+	}{
+		{"no transactions", nil, [32]byte{}},
+		{"one transaction", txns[:1], a},
+		{"two transactions", txns[:2], parent(a, b)},
+		{"odd level pairs the last hash with itself", txns, parent(parent(a, b), parent(c, c))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if root := block.MerkleRoot(tt.txns); root != tt.root {
+				t.Errorf("root %x, want %x", root, tt.root)
+			}
+		})
+	}
+}
+
+func TestMerkleProof(t *testing.T) {
+	for n := 1; n <= 9; n++ {
+		txns := transactions(n)
+		root := block.MerkleRoot(txns)
+		for i := range txns {
+			p := block.NewMerkleProof(txns, i)
+			if p == nil || p.TxHash != txns[i].Hash() || !p.Verify(root) {
+				t.Errorf("proof of transaction %d of %d doesn't lead to the root", i, n)
+			}
+		}
+		if block.NewMerkleProof(txns, -1) != nil || block.NewMerkleProof(txns, n) != nil {
+			t.Errorf("proof of a transaction outside the %d in the block", n)
+		}
+	}
+}
+
+func TestMerkleProofRejects(t *testing.T) {
+	txns := transactions(5)
+	root := block.MerkleRoot(txns)
+	tests := []struct {
+		name   string
+		tamper func(p *block.MerkleProof)
+	}{
+		{"other transaction", func(p *block.MerkleProof) { p.TxHash = txns[3].Hash() }},
+		{"other index", func(p *block.MerkleProof) { p.Index = 3 }},
+		{"negative index", func(p *block.MerkleProof) { p.Index = -1 }},
+		{"index past the tree", func(p *block.MerkleProof) { p.Index += 1 << len(p.Siblings) }},
+		{"changed sibling", func(p *block.MerkleProof) { p.Siblings[1][0] ^= 1 }},
+		{"missing sibling", func(p *block.MerkleProof) { p.Siblings = p.Siblings[:len(p.Siblings)-1] }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := block.NewMerkleProof(txns, 2)
+			tt.tamper(p)
+			if p.Verify(root) {
+				t.Error("tampered proof verified")
+			}
+		})
+	}
+}
+
+func TestProvenTransactionVerify(t *testing.T) {
+	txns := transactions(4)
+	header := &block.BlockHeader{MerkleRoot: block.MerkleRoot(txns), Timestamp: 1}
+	other := &block.BlockHeader{MerkleRoot: block.MerkleRoot(txns), Timestamp: 2}
+	proven := func() *block.ProvenTransaction {
+		return &block.ProvenTransaction{
+			Transaction: txns[1],
+			BlockHash:   header.Hash(),
+			Height:      1,
+			Proof:       block.NewMerkleProof(txns, 1),
+		}
+	}
+	tests := []struct {
+		name   string
+		header *block.BlockHeader
+		tamper func(pt *block.ProvenTransaction)
+		valid  bool
+	}{
+		{"proven", header, func(pt *block.ProvenTransaction) {}, true},
+		{"other header", other, func(pt *block.ProvenTransaction) {}, false},
+		{"other transaction", header, func(pt *block.ProvenTransaction) { pt.Transaction = txns[2] }, false},
+		{"changed value", header, func(pt *block.ProvenTransaction) {
+			tx := *pt.Transaction
+			tx.Value++
+			pt.Transaction = &tx
+		}, false},
+		{"no transaction", header, func(pt *block.ProvenTransaction) { pt.Transaction = nil }, false},
+		{"no proof", header, func(pt *block.ProvenTransaction) { pt.Proof = nil }, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pt := proven()
+			tt.tamper(pt)
+			if valid := pt.Verify(tt.header); valid != tt.valid {
+				t.Errorf("Verify = %v, want %v", valid, tt.valid)
+			}
+		})
+	}
+}
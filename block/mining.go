@@ -0,0 +1,196 @@
+package block
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// what the mining loop does, changeable while it runs. a new interval
+// applies from the next round
+type MiningSettings struct {
+	IntervalSec int    `json:"interval_sec"`
+	Address     string `json:"address"`
+	// mine blocks with only the coinbase when the pool is empty
+	MineEmpty bool `json:"mine_empty"`
+}
+
+// the block that would be mined next
+type TemplateInfo struct {
+	Height       int     `json:"height"`
+	PrevHash     string  `json:"prev_hash"`
+	Transactions int     `json:"transactions"`
+	Reward       float32 `json:"reward"`
+	Difficulty   int     `json:"difficulty"`
+}
+
+type MiningStatus struct {
+	Running bool  `json:"running"`
+	Since   int64 `json:"since,omitempty"`
+	// hashes per second over all the proof of work done so far
+	HashRate    float64        `json:"hash_rate"`
+	BlocksFound int            `json:"blocks_found"`
+	LastBlock   string         `json:"last_block,omitempty"`
+	Template    *TemplateInfo  `json:"template"`
+	Settings    MiningSettings `json:"settings"`
+}
+
+type miningState struct {
+	intervalSec int
+	mineEmpty   bool
+	running     bool
+	since       int64
+	stop        chan struct{}
+	// closed when the loop has returned
+	done        chan struct{}
+	blocksFound int
+	hashes      int64
+	elapsed     time.Duration
+	lastBlock   [32]byte
+	mux         sync.Mutex
+}
+
+// starts the mining loop, false if it is already running
+func (bc *Blockchain) StartMining() bool {
+	m := &bc.miner
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	if m.running {
+		return false
+	}
+	m.running = true
+	m.since = time.Now().Unix()
+	m.stop = make(chan struct{})
+	m.done = make(chan struct{})
+	go bc.mineLoop(m.stop, m.done)
+	return true
+}
+
+// stops the mining loop, giving up the block it is working on, false if
+// it wasn't running
+func (bc *Blockchain) StopMining() bool {
+	m := &bc.miner
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	if !m.running {
+		return false
+	}
+	m.running = false
+	m.since = 0
+	close(m.stop)
+	return true
+}
+
+// stops the mining loop and waits for it to return, for shutting down
+func (bc *Blockchain) Stop(ctx context.Context) error {
+	m := &bc.miner
+	m.mux.Lock()
+	done := m.done
+	m.mux.Unlock()
+	if done == nil {
+		return nil
+	}
+	bc.StopMining()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (bc *Blockchain) mineLoop(stop chan struct{}, done chan struct{}) {
+	defer close(done)
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		bc.mine(stop)
+		select {
+		case <-stop:
+			return
+		case <-time.After(time.Duration(bc.MiningSettings().IntervalSec) * time.Second):
+		}
+	}
+}
+
+func (bc *Blockchain) MiningSettings() MiningSettings {
+	bc.mux.RLock()
+	address := bc.BlockchainAddress
+	bc.mux.RUnlock()
+	m := &bc.miner
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	return MiningSettings{
+		IntervalSec: m.intervalSec,
+		Address:     address,
+		MineEmpty:   m.mineEmpty,
+	}
+}
+
+func (bc *Blockchain) SetMiningSettings(s MiningSettings) error {
+	if s.IntervalSec < 1 {
+		return errors.New("interval_sec must be at least 1")
+	}
+	if s.Address == "" || s.Address == MINING_SENDER {
+		return fmt.Errorf("invalid reward address %q", s.Address)
+	}
+	bc.mux.Lock()
+	bc.BlockchainAddress = s.Address
+	bc.mux.Unlock()
+	m := &bc.miner
+	m.mux.Lock()
+	m.intervalSec = s.IntervalSec
+	m.mineEmpty = s.MineEmpty
+	m.mux.Unlock()
+	return nil
+}
+
+func (bc *Blockchain) MiningStatus() *MiningStatus {
+	bc.mux.RLock()
+	height := len(bc.Chain)
+	template := &TemplateInfo{
+		Height:       height,
+		PrevHash:     fmt.Sprintf("%x", bc.lastBlock().Hash()),
+		Transactions: len(bc.TransactionPool),
+		Reward:       bc.genesis.Reward(height),
+		Difficulty:   bc.genesis.Difficulty,
+	}
+	bc.mux.RUnlock()
+	settings := bc.MiningSettings()
+	m := &bc.miner
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	status := &MiningStatus{
+		Running:     m.running,
+		Since:       m.since,
+		BlocksFound: m.blocksFound,
+		Template:    template,
+		Settings:    settings,
+	}
+	if m.elapsed > 0 {
+		status.HashRate = float64(m.hashes) / m.elapsed.Seconds()
+	}
+	if m.blocksFound > 0 {
+		status.LastBlock = fmt.Sprintf("%x", m.lastBlock)
+	}
+	return status
+}
+
+// adds hashes tried in elapsed to the hash rate, b is the block they
+// found, nil when the work was aborted or went stale
+func (bc *Blockchain) recordWork(hashes int64, elapsed time.Duration, b *Block) {
+	m := &bc.miner
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.hashes += hashes
+	m.elapsed += elapsed
+	if b != nil {
+		m.blocksFound++
+		m.lastBlock = b.Hash()
+	}
+}
@@ -0,0 +1,43 @@
+package block_test
+
+import (
+	"blockchain/block"
+	"blockchain/wallet"
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMiningErrors(t *testing.T) {
+	address := wallet.NewWallet().BlockchainAddress
+	bc := block.NewBlockChain(address, 0, testGenesis())
+	if err := bc.Mining(); !errors.Is(err, block.ErrNothingToMine) {
+		t.Errorf("mining an empty pool: %v, want %v", err, block.ErrNothingToMine)
+	}
+	bc.SetMiningSettings(block.MiningSettings{IntervalSec: 1, Address: address, MineEmpty: true})
+	if err := bc.Mining(); err != nil {
+		t.Errorf("mining an empty block: %v", err)
+	}
+}
+
+func TestHashRateCountsAbortedWork(t *testing.T) {
+	genesis := testGenesis()
+	// far more work than the test waits for
+	genesis.Difficulty = 12
+	address := wallet.NewWallet().BlockchainAddress
+	bc := block.NewBlockChain(address, 0, genesis)
+	bc.SetMiningSettings(block.MiningSettings{IntervalSec: 1, Address: address, MineEmpty: true})
+	bc.StartMining()
+	time.Sleep(50 * time.Millisecond)
+	if err := bc.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	status := bc.MiningStatus()
+	if status.BlocksFound != 0 {
+		t.Fatalf("found %d blocks at difficulty %d", status.BlocksFound, genesis.Difficulty)
+	}
+	if status.HashRate <= 0 {
+		t.Errorf("hash rate %v after the aborted work, want the hashes tried", status.HashRate)
+	}
+}
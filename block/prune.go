@@ -0,0 +1,90 @@
+package block
+
+import (
+	"fmt"
+	"log"
+)
+
+// fewest blocks a pruned node keeps the bodies of, reorgs deeper than
+// that can't be followed
+const MIN_PRUNE_KEEP = 32
+
+// keeps the bodies of the last keep blocks only, the ones below are
+// cut down to their headers once the chain grows past them. 0 keeps
+// every block
+func (bc *Blockchain) SetPruning(keep int) error {
+	if keep != 0 && keep < MIN_PRUNE_KEEP {
+		return fmt.Errorf("at least %d blocks must be kept", MIN_PRUNE_KEEP)
+	}
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+	bc.pruneKeep = keep
+	bc.prune()
+	return nil
+}
+
+func (bc *Blockchain) Pruning() int {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+	return bc.pruneKeep
+}
+
+// lowest height from which on every block has its body, 0 when no
+// block was pruned
+func (bc *Blockchain) PruneHeight() int {
+	chain := bc.Blocks()
+	for h := len(chain) - 1; h > 0; h-- {
+		if chain[h].Pruned() {
+			return h + 1
+		}
+	}
+	return 0
+}
+
+// every change of bc.Chain goes through here, with mux held. a chain
+// that doesn't go through our base anymore was validated from the
+// genesis block on, so it has all its bodies
+func (bc *Blockchain) setChain(chain []*Block) {
+	bc.Chain = chain
+	if bc.base != nil && !bc.fromBase(chain) {
+		bc.base = nil
+	}
+	bc.prune()
+}
+
+// moves the base up to keep blocks below the tip and drops the bodies
+// the state at it no longer needs. the chain is copied, not changed, as
+// others may be reading it
+func (bc *Blockchain) prune() {
+	height := len(bc.Chain) - 1 - bc.pruneKeep
+	if bc.pruneKeep == 0 || height <= 0 || (bc.base != nil && height <= bc.base.height) {
+		return
+	}
+	balances := make(map[string]float32)
+	var supply float32 = 0.0
+	from := 0
+	if bc.base != nil {
+		balances = bc.base.copyBalances()
+		supply = bc.base.supply
+		from = bc.base.height + 1
+	}
+	for h := from; h <= height; h++ {
+		applyBalances(balances, bc.Chain[h])
+		supply = addIssued(supply, bc.Chain[h])
+	}
+	chain := make([]*Block, len(bc.Chain))
+	copy(chain, bc.Chain)
+	until := immatureFrom(height, bc.genesis.CoinbaseMaturity)
+	pruned := 0
+	for h := 1; h < until; h++ {
+		if !chain[h].Pruned() {
+			chain[h] = HeaderBlock(chain[h].Header())
+			pruned++
+		}
+	}
+	bc.Chain = chain
+	bc.base = &chainBase{height: height, hash: chain[height].Hash(), supply: supply, balances: balances}
+	if pruned > 0 {
+		log.Printf("action=Prune, blocks=%d, prune_height=%d", pruned, until)
+	}
+}
@@ -0,0 +1,230 @@
+package block
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+)
+
+// the balances at a height and what a node needs to start from there
+// instead of from the genesis block: every header up to it and the
+// bodies of the blocks whose rewards are still immature. nodes agree on
+// the state at a height when their commitments match
+type Snapshot struct {
+	ChainID     string             `json:"chain_id"`
+	GenesisHash string             `json:"genesis_hash"`
+	Height      int                `json:"height"`
+	BlockHash   string             `json:"block_hash"`
+	Supply      float32            `json:"supply"`
+	Balances    []*SnapshotBalance `json:"balances"`
+	Commitment  string             `json:"commitment"`
+	Headers     []*BlockHeader     `json:"headers"`
+	Blocks      []*Block           `json:"blocks"`
+}
+
+type SnapshotBalance struct {
+	Address string  `json:"address"`
+	Amount  float32 `json:"amount"`
+}
+
+// state at the height of the snapshot a chain was started from, blocks
+// up to there may come without bodies
+type chainBase struct {
+	height   int
+	hash     [32]byte
+	supply   float32
+	balances map[string]float32
+}
+
+func (cb *chainBase) copyBalances() map[string]float32 {
+	balances := make(map[string]float32, len(cb.balances))
+	for a, v := range cb.balances {
+		balances[a] = v
+	}
+	return balances
+}
+
+// true if chain goes through the block our snapshot was taken at
+func (bc *Blockchain) fromBase(chain []*Block) bool {
+	return bc.base != nil && len(chain) > bc.base.height &&
+		chain[bc.base.height].Hash() == bc.base.hash
+}
+
+// sha256 over everything but the headers and blocks, which are checked
+// against BlockHash on their own
+func (s *Snapshot) commitment() string {
+	m, _ := json.Marshal(&Snapshot{
+		ChainID:     s.ChainID,
+		GenesisHash: s.GenesisHash,
+		Height:      s.Height,
+		BlockHash:   s.BlockHash,
+		Supply:      s.Supply,
+		Balances:    s.Balances,
+	})
+	return fmt.Sprintf("%x", sha256.Sum256(m))
+}
+
+// first height whose block is needed for the immature rewards of the
+// block after height
+func immatureFrom(height int, maturity int) int {
+	return max(1, height-maturity+2)
+}
+
+// snapshot of the state after the block at height
+func (bc *Blockchain) Snapshot(height int) (*Snapshot, error) {
+	bc.mux.RLock()
+	chain, base := bc.Chain, bc.base
+	bc.mux.RUnlock()
+
+	if height < 0 || height >= len(chain) {
+		return nil, fmt.Errorf("no block at height %d", height)
+	}
+	balances := make(map[string]float32)
+	var supply float32 = 0.0
+	from := 0
+	if base != nil && height >= base.height {
+		balances = base.copyBalances()
+		supply = base.supply
+		from = base.height + 1
+	}
+	for h := from; h <= height; h++ {
+		if chain[h].Pruned() {
+			return nil, fmt.Errorf("%w: height %d", ErrBlockPruned, h)
+		}
+		applyBalances(balances, chain[h])
+		supply = addIssued(supply, chain[h])
+	}
+
+	s := &Snapshot{
+		ChainID:     bc.ChainID,
+		GenesisHash: fmt.Sprintf("%x", bc.genesis.Hash()),
+		Height:      height,
+		BlockHash:   fmt.Sprintf("%x", chain[height].Hash()),
+		Supply:      supply,
+		Balances:    make([]*SnapshotBalance, 0, len(balances)),
+		Headers:     make([]*BlockHeader, 0, height+1),
+		Blocks:      make([]*Block, 0),
+	}
+	for a, v := range balances {
+		if v != 0 {
+			s.Balances = append(s.Balances, &SnapshotBalance{Address: a, Amount: v})
+		}
+	}
+	sort.Slice(s.Balances, func(i, j int) bool {
+		return s.Balances[i].Address < s.Balances[j].Address
+	})
+	s.Commitment = s.commitment()
+	for _, b := range chain[:height+1] {
+		s.Headers = append(s.Headers, b.Header())
+	}
+	for h := immatureFrom(height, bc.genesis.CoinbaseMaturity); h <= height; h++ {
+		if chain[h].Pruned() {
+			return nil, fmt.Errorf("%w: height %d", ErrBlockPruned, h)
+		}
+		s.Blocks = append(s.Blocks, chain[h])
+	}
+	return s, nil
+}
+
+func LoadSnapshot(path string) (*Snapshot, error) {
+	f, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var s Snapshot
+	if err := json.Unmarshal(f, &s); err != nil {
+		return nil, fmt.Errorf("snapshot %s: %w", path, err)
+	}
+	return &s, nil
+}
+
+// checks s belongs to genesis' chain and has the state of commitment,
+// that the headers link up with enough work to BlockHash and that the
+// blocks are the last ones of them. the snapshot's own commitment only
+// shows it wasn't damaged, anyone can make one for made up balances, so
+// commitment must come from somewhere trusted, like a node of our own
+func (s *Snapshot) Verify(genesis *Genesis, commitment string) error {
+	if s.ChainID != genesis.ChainID || s.GenesisHash != fmt.Sprintf("%x", genesis.Hash()) {
+		return fmt.Errorf("%w: it is of chain %s", ErrInvalidSnapshot, s.ChainID)
+	}
+	if s.Commitment != s.commitment() {
+		return fmt.Errorf("%w: commitment doesn't match the balances", ErrInvalidSnapshot)
+	}
+	if commitment == "" {
+		return fmt.Errorf("%w: no trusted commitment to check it against", ErrInvalidSnapshot)
+	}
+	if s.Commitment != commitment {
+		return fmt.Errorf("%w: commitment %s isn't the trusted %s", ErrInvalidSnapshot, s.Commitment, commitment)
+	}
+	if s.Height < 0 || len(s.Headers) != s.Height+1 {
+		return fmt.Errorf("%w: %d headers for height %d", ErrInvalidSnapshot, len(s.Headers), s.Height)
+	}
+	for h, header := range s.Headers {
+		if header == nil {
+			return fmt.Errorf("%w: at height %d", ErrMalformed, h)
+		}
+	}
+	for i, b := range s.Blocks {
+		if b == nil || b.Malformed() {
+			return fmt.Errorf("%w: block %d of the snapshot", ErrMalformed, i)
+		}
+	}
+	if s.Headers[0].Hash() != genesis.Hash() {
+		return fmt.Errorf("%w: the headers don't start with the genesis block", ErrInvalidSnapshot)
+	}
+	for h := 1; h <= s.Height; h++ {
+		if !genesis.ValidHeader(s.Headers[h], s.Headers[h-1]) {
+			return fmt.Errorf("%w: bad header at height %d", ErrInvalidSnapshot, h)
+		}
+	}
+	if fmt.Sprintf("%x", s.Headers[s.Height].Hash()) != s.BlockHash {
+		return fmt.Errorf("%w: the headers don't end at block %s", ErrInvalidSnapshot, s.BlockHash)
+	}
+	first := s.Height - len(s.Blocks) + 1
+	if first > immatureFrom(s.Height, genesis.CoinbaseMaturity) {
+		return fmt.Errorf("%w: blocks from height %d on are needed", ErrInvalidSnapshot,
+			immatureFrom(s.Height, genesis.CoinbaseMaturity))
+	}
+	for i, b := range s.Blocks {
+		if first+i < 1 || b.Pruned() || b.Hash() != s.Headers[first+i].Hash() {
+			return fmt.Errorf("%w: block at height %d doesn't match its header", ErrInvalidSnapshot, first+i)
+		}
+	}
+	return nil
+}
+
+// a chain started from a snapshot instead of the genesis block. blocks
+// below the snapshot's are kept as headers, the state at its height is
+// taken from the snapshot's balances once they match commitment, see
+// Verify
+func NewBlockChainFromSnapshot(BlockchainAddress string, port uint16, genesis *Genesis, s *Snapshot, commitment string) (*Blockchain, error) {
+	if err := s.Verify(genesis, commitment); err != nil {
+		return nil, err
+	}
+	bc := NewBlockChain(BlockchainAddress, port, genesis)
+	chain := make([]*Block, s.Height+1)
+	chain[0] = bc.Chain[0]
+	for h := 1; h <= s.Height; h++ {
+		chain[h] = HeaderBlock(s.Headers[h])
+	}
+	first := s.Height - len(s.Blocks) + 1
+	for i, b := range s.Blocks {
+		chain[first+i] = b
+	}
+	base := &chainBase{
+		height:   s.Height,
+		hash:     chain[s.Height].Hash(),
+		supply:   s.Supply,
+		balances: make(map[string]float32, len(s.Balances)),
+	}
+	for _, sb := range s.Balances {
+		base.balances[sb.Address] = sb.Amount
+	}
+	bc.Chain = chain
+	bc.base = base
+	log.Printf("action=LoadSnapshot, height=%d, commitment=%s", s.Height, s.Commitment)
+	return bc, nil
+}
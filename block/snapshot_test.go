@@ -0,0 +1,54 @@
+package block_test
+
+import (
+	"blockchain/block"
+	"blockchain/wallet"
+	"errors"
+	"testing"
+)
+
+func TestSnapshotVerifyNullEntries(t *testing.T) {
+	genesis := testGenesis()
+	// the snapshot carries the blocks whose rewards are still immature
+	genesis.CoinbaseMaturity = 2
+	address := wallet.NewWallet().BlockchainAddress
+	bc := block.NewBlockChain(address, 0, genesis)
+	bc.SetMiningSettings(block.MiningSettings{IntervalSec: 1, Address: address, MineEmpty: true})
+	for i := 0; i < 3; i++ {
+		bc.Mining()
+	}
+	snapshot := func() *block.Snapshot {
+		s, err := bc.Snapshot(bc.Height())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	if s := snapshot(); len(s.Headers) != 4 || len(s.Blocks) == 0 {
+		t.Fatalf("snapshot with %d headers and %d blocks, want 4 and some", len(s.Headers), len(s.Blocks))
+	} else if err := s.Verify(genesis, s.Commitment); err != nil {
+		t.Fatalf("snapshot of our own chain: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		tamper func(s *block.Snapshot)
+	}{
+		{"null header", func(s *block.Snapshot) { s.Headers[2] = nil }},
+		{"null block", func(s *block.Snapshot) { s.Blocks[0] = nil }},
+		{"null transaction", func(s *block.Snapshot) {
+			b := *s.Blocks[0]
+			b.Transactions = append(b.Transactions, nil)
+			s.Blocks[0] = &b
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := snapshot()
+			tt.tamper(s)
+			if err := s.Verify(genesis, s.Commitment); !errors.Is(err, block.ErrMalformed) {
+				t.Errorf("error %v, want %v", err, block.ErrMalformed)
+			}
+		})
+	}
+}
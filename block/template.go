@@ -0,0 +1,98 @@
+package block
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// everything an external miner needs to build the next block: find a
+// Nonce for which the header of PrevHash, MerkleRoot and Timestamp
+// hashes to at most Target, then submit Block(nonce)
+type BlockTemplate struct {
+	Height       int            `json:"height"`
+	PrevHash     [32]byte       `json:"prev_hash"`
+	Timestamp    int64          `json:"timestamp"`
+	Transactions []*Transaction `json:"transactions"`
+	MerkleRoot   [32]byte       `json:"merkle_root"`
+	Difficulty   int            `json:"difficulty"`
+	// hex, the header hash must start with Difficulty zeros
+	Target          string  `json:"target"`
+	CoinbaseValue   float32 `json:"coinbase_value"`
+	CoinbaseAddress string  `json:"coinbase_address"`
+}
+
+// a template on top of our tip with the pool's transactions and a
+// coinbase paying address, the node's own address when empty
+func (bc *Blockchain) NewBlockTemplate(address string) *BlockTemplate {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+	if address == "" {
+		address = bc.BlockchainAddress
+	}
+	height := len(bc.Chain)
+	txns := bc.copyPool()
+	reward := bc.genesis.Reward(height)
+	if reward > 0 {
+		txns = append(txns, NewTransaction(MINING_SENDER, address, reward))
+	}
+	return &BlockTemplate{
+		Height:          height,
+		PrevHash:        bc.lastBlock().Hash(),
+		Timestamp:       time.Now().UnixMilli(),
+		Transactions:    txns,
+		MerkleRoot:      MerkleRoot(txns),
+		Difficulty:      bc.genesis.Difficulty,
+		Target:          strings.Repeat("0", bc.genesis.Difficulty) + strings.Repeat("f", 64-bc.genesis.Difficulty),
+		CoinbaseValue:   reward,
+		CoinbaseAddress: address,
+	}
+}
+
+func (t *BlockTemplate) Header(nonce int) *BlockHeader {
+	return &BlockHeader{
+		PrevHash:   t.PrevHash,
+		MerkleRoot: t.MerkleRoot,
+		Timestamp:  t.Timestamp,
+		Nonce:      nonce,
+	}
+}
+
+// true if nonce solves the template
+func (t *BlockTemplate) Check(nonce int) bool {
+	return t.CheckDifficulty(nonce, t.Difficulty)
+}
+
+// true if nonce meets a difficulty other than the template's, as pool
+// shares do
+func (t *BlockTemplate) CheckDifficulty(nonce int, difficulty int) bool {
+	return validProof(t.Header(nonce), difficulty)
+}
+
+func (t *BlockTemplate) Block(nonce int) *Block {
+	return NewBlock(nonce, t.PrevHash, t.Timestamp, t.Transactions)
+}
+
+// adds a block mined outside the node, the error tells a block that
+// came too late from an invalid one
+func (bc *Blockchain) SubmitBlock(b *Block) error {
+	if b == nil || b.Malformed() {
+		return fmt.Errorf("%w: null transaction", ErrInvalidBlock)
+	}
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+
+	if b.PrevHash != bc.lastBlock().Hash() {
+		return fmt.Errorf("%w: %x", ErrStaleBlock, b.Hash())
+	}
+	chain := append(bc.Chain[:len(bc.Chain):len(bc.Chain)], b)
+	if !bc.validChain(chain) {
+		return fmt.Errorf("%w: %x", ErrInvalidBlock, b.Hash())
+	}
+	bc.setChain(chain)
+	bc.removeFromPool(b.Transactions)
+	log.Printf("action=AddBlock, height=%d", len(bc.Chain)-1)
+	bc.notifyBlock(b, len(bc.Chain)-1)
+	return nil
+}
@@ -0,0 +1,188 @@
+package block_test
+
+import (
+	"blockchain/block"
+	"blockchain/utils"
+	"blockchain/wallet"
+	"crypto/elliptic"
+	"errors"
+	"math/big"
+	"testing"
+)
+
+const testChainID = "test"
+
+func testGenesis(allocations ...block.Allocation) *block.Genesis {
+	return &block.Genesis{
+		ChainID:      testChainID,
+		Timestamp:    1,
+		Difficulty:   1,
+		MiningReward: 1,
+		Allocations:  allocations,
+	}
+}
+
+// a transfer signed by w, as a node stores it
+func signed(w *wallet.Wallet, recipient string, value float32) *block.Transaction {
+	req := w.TransactionRequest(testChainID, recipient, value)
+	return &block.Transaction{
+		SenderBlockchainAddress:    *req.SenderBlockchainAddress,
+		RecipientBlockchainAddress: *req.RecipientBlockchainAddress,
+		Value:                      *req.Value,
+		ChainID:                    *req.ChainID,
+		SenderPublicKey:            *req.SenderPublicKey,
+		Signature:                  *req.Signature,
+	}
+}
+
+// mines txns into a block without the checks AddTransaction does, the
+// way a dishonest miner would
+func mine(bc *block.Blockchain, txns ...*block.Transaction) {
+	bc.TransactionPool = append(bc.TransactionPool, txns...)
+	bc.Mining()
+}
+
+func TestValidChainBalances(t *testing.T) {
+	alice, bob, miner := wallet.NewWallet(), wallet.NewWallet(), wallet.NewWallet()
+	tests := []struct {
+		name  string
+		alloc float32
+		build func(bc *block.Blockchain)
+		valid bool
+	}{
+		{"transfer within balance", 1, func(bc *block.Blockchain) {
+			mine(bc, signed(alice, bob.BlockchainAddress, 1))
+		}, true},
+		{"double spend in one block", 1, func(bc *block.Blockchain) {
+			mine(bc, signed(alice, bob.BlockchainAddress, 1), signed(alice, bob.BlockchainAddress, 1))
+		}, false},
+		{"double spend across blocks", 1, func(bc *block.Blockchain) {
+			mine(bc, signed(alice, bob.BlockchainAddress, 1))
+			mine(bc, signed(alice, bob.BlockchainAddress, 1))
+		}, false},
+		{"spending received funds", 1, func(bc *block.Blockchain) {
+			mine(bc, signed(alice, bob.BlockchainAddress, 1))
+			mine(bc, signed(bob, miner.BlockchainAddress, 1))
+		}, true},
+		{"spending received funds in the same block", 1, func(bc *block.Blockchain) {
+			mine(bc, signed(alice, bob.BlockchainAddress, 1), signed(bob, miner.BlockchainAddress, 1))
+		}, true},
+		{"replayed transaction", 2, func(bc *block.Blockchain) {
+			tx := signed(alice, bob.BlockchainAddress, 1)
+			mine(bc, tx)
+			replay := *tx
+			mine(bc, &replay)
+		}, false},
+		{"replayed transaction in the same block", 2, func(bc *block.Blockchain) {
+			tx := signed(alice, bob.BlockchainAddress, 1)
+			replay := *tx
+			mine(bc, tx, &replay)
+		}, false},
+		{"same payment signed twice", 2, func(bc *block.Blockchain) {
+			mine(bc, signed(alice, bob.BlockchainAddress, 1), signed(alice, bob.BlockchainAddress, 1))
+		}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			genesis := testGenesis(block.Allocation{Address: alice.BlockchainAddress, Value: tt.alloc})
+			bc := block.NewBlockChain(miner.BlockchainAddress, 0, genesis)
+			tt.build(bc)
+			if got := bc.ValidChain(bc.Chain); got != tt.valid {
+				t.Errorf("ValidChain = %v, want %v", got, tt.valid)
+			}
+		})
+	}
+}
+
+// a block with valid proof of work whose transactions are nil
+func nullBlock(bc *block.Blockchain) *block.Block {
+	prev := bc.LastBlock()
+	for nonce := 0; ; nonce++ {
+		b := block.NewBlock(nonce, prev.Hash(), prev.Timestamp+1, []*block.Transaction{nil})
+		if bc.ValidHeader(b.Header(), prev.Header()) {
+			return b
+		}
+	}
+}
+
+func TestNullTransactions(t *testing.T) {
+	bc := block.NewBlockChain(wallet.NewWallet().BlockchainAddress, 0, testGenesis())
+	b := nullBlock(bc)
+	if err := bc.SubmitBlock(b); !errors.Is(err, block.ErrInvalidBlock) {
+		t.Errorf("SubmitBlock = %v, want %v", err, block.ErrInvalidBlock)
+	}
+	if bc.ValidChain(append(bc.Chain, b)) {
+		t.Error("ValidChain accepted a block with a null transaction")
+	}
+	if bc.ValidChain(append(bc.Chain, nil)) {
+		t.Error("ValidChain accepted a null block")
+	}
+	if bc.Height() != 0 {
+		t.Errorf("height %d after rejected blocks", bc.Height())
+	}
+}
+
+// a transfer from sender signed with the key of signer
+func signedBy(signer *wallet.Wallet, sender string, recipient string, value float32) *block.Transaction {
+	t := wallet.NewTransaction(signer.PrivateKey, signer.PublicKey, sender, recipient, value)
+	t.ChainID = testChainID
+	return &block.Transaction{
+		SenderBlockchainAddress:    sender,
+		RecipientBlockchainAddress: recipient,
+		Value:                      value,
+		ChainID:                    testChainID,
+		SenderPublicKey:            signer.PublicKeyStr(),
+		Signature:                  t.GenerateSignature().String(),
+	}
+}
+
+func TestSenderMustOwnKey(t *testing.T) {
+	alice, mallory := wallet.NewWallet(), wallet.NewWallet()
+	genesis := testGenesis(block.Allocation{Address: alice.BlockchainAddress, Value: 5})
+
+	bc := block.NewBlockChain(mallory.BlockchainAddress, 0, genesis)
+	stolen := signedBy(mallory, alice.BlockchainAddress, mallory.BlockchainAddress, 5)
+	if err := bc.AddTransaction(stolen, nil, nil); !errors.Is(err, block.ErrInvalidSignature) {
+		t.Errorf("AddTransaction = %v, want %v", err, block.ErrInvalidSignature)
+	}
+	mine(bc, stolen)
+	if bc.ValidChain(bc.Chain) {
+		t.Error("ValidChain accepted a block spending alice's coins with mallory's key")
+	}
+
+	bc = block.NewBlockChain(mallory.BlockchainAddress, 0, genesis)
+	paid := signedBy(alice, alice.BlockchainAddress, mallory.BlockchainAddress, 5)
+	if err := bc.AddTransaction(paid, nil, nil); err != nil {
+		t.Errorf("AddTransaction of alice's own transfer = %v", err)
+	}
+}
+
+// the same transfer with the other valid signature, (R, N-S)
+func flipS(t *block.Transaction) *block.Transaction {
+	s := utils.SignatureFromString(t.Signature)
+	s.S = new(big.Int).Sub(elliptic.P256().Params().N, s.S)
+	flipped := *t
+	flipped.Signature = s.String()
+	return &flipped
+}
+
+func TestReplayWithFlippedSignature(t *testing.T) {
+	alice, bob := wallet.NewWallet(), wallet.NewWallet()
+	genesis := testGenesis(block.Allocation{Address: alice.BlockchainAddress, Value: 5})
+	bc := block.NewBlockChain(bob.BlockchainAddress, 0, genesis)
+	tx := signed(alice, bob.BlockchainAddress, 1)
+	if err := bc.AddTransaction(tx, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.AddTransaction(flipS(tx), nil, nil); err == nil {
+		t.Error("the pool took the transfer again with a flipped signature")
+	}
+	bc.Mining()
+	if err := bc.AddTransaction(flipS(tx), nil, nil); err == nil {
+		t.Error("the pool took a mined transfer again with a flipped signature")
+	}
+	mine(bc, flipS(tx))
+	if bc.ValidChain(bc.Chain) {
+		t.Error("ValidChain accepted a mined transfer replayed with a flipped signature")
+	}
+}
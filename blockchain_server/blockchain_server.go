@@ -1,12 +1,19 @@
-package main
+package blockchain_server
 
 import (
 	"blockchain/block"
+	"blockchain/lifecycle"
+	"blockchain/p2p"
 	"blockchain/utils"
 	"blockchain/wallet"
+	"crypto/ecdsa"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"log"
+	"net"
 	"net/http"
 	"strconv"
 )
@@ -14,11 +21,37 @@ import (
 var cache map[string]*block.Blockchain = make(map[string]*block.Blockchain)
 
 type BlockchainServer struct {
-	port uint16 `json : "port"`
+	port     uint16
+	host     string
+	p2pPort  uint16
+	addrs    *p2p.AddrManager
+	genesis  *block.Genesis
+	node     *p2p.Server
+	events   *EventHub
+	webhooks *Webhooks
+	// stops what runs in the background when the node shuts down
+	lifecycle *lifecycle.Manager
+	// the api is served over https when set
+	tlsCert string
+	tlsKey  string
 }
 
-func NewBlockchainServer(port uint16) *BlockchainServer {
-	return &BlockchainServer{port}
+func NewBlockchainServer(port uint16, p2pPort uint16, addrs *p2p.AddrManager, genesis *block.Genesis) *BlockchainServer {
+	return &BlockchainServer{port: port, host: "0.0.0.0", p2pPort: p2pPort, addrs: addrs, genesis: genesis, events: NewEventHub(), lifecycle: lifecycle.NewManager()}
+}
+
+func (bcs *BlockchainServer) UseWebhooks(wh *Webhooks) {
+	bcs.webhooks = wh
+}
+
+// the interface the api listens on, all of them by default
+func (bcs *BlockchainServer) ListenOn(host string) {
+	bcs.host = host
+}
+
+func (bcs *BlockchainServer) UseTLS(certFile string, keyFile string) {
+	bcs.tlsCert = certFile
+	bcs.tlsKey = keyFile
 }
 
 func (bcs *BlockchainServer) GetPort() uint16 {
@@ -28,17 +61,37 @@ func (bcs *BlockchainServer) GetPort() uint16 {
 func (bcs *BlockchainServer) GetBlockchain() *block.Blockchain {
 	bc, ok := cache["blockchain"]
 	if !ok {
-		minersWallet := wallet.NewWallet()
-		bc = block.NewBlockChain(minersWallet.GetBlockchainAddress(), bcs.GetPort())
+		bc = block.NewBlockChain(bcs.minerAddress(), bcs.GetPort(), bcs.genesis)
 		cache["blockchain"] = bc
-		log.Printf("private_key: %v", minersWallet.PrivateKeyStr())
-		log.Printf("public_key: %v", minersWallet.PublicKeyStr())
-		log.Printf("blockchain_address: %v", minersWallet.GetBlockchainAddress())
 	}
 	return bc
 }
 
+// starts the chain from a snapshot with the trusted commitment instead
+// of the genesis block, it must come before anything else uses the chain
+func (bcs *BlockchainServer) StartFromSnapshot(s *block.Snapshot, commitment string) error {
+	bc, err := block.NewBlockChainFromSnapshot(bcs.minerAddress(), bcs.GetPort(), bcs.genesis, s, commitment)
+	if err != nil {
+		return err
+	}
+	cache["blockchain"] = bc
+	return nil
+}
+
+func (bcs *BlockchainServer) minerAddress() string {
+	minersWallet := wallet.NewWallet()
+	log.Printf("private_key: %v", minersWallet.PrivateKeyStr())
+	log.Printf("public_key: %v", minersWallet.PublicKeyStr())
+	log.Printf("blockchain_address: %v", minersWallet.GetBlockchainAddress())
+	return minersWallet.GetBlockchainAddress()
+}
+
 func (bcs *BlockchainServer) GetChain(w http.ResponseWriter, req *http.Request) {
+	// every path nothing else is registered for ends up here
+	if req.URL.Path != "/" {
+		utils.JsonError(w, req, http.StatusNotFound, utils.ERR_NOT_FOUND, "no such endpoint "+req.URL.Path, nil)
+		return
+	}
 	switch req.Method {
 	case http.MethodGet:
 		w.Header().Add("Content-Type", "application/json")
@@ -46,20 +99,17 @@ func (bcs *BlockchainServer) GetChain(w http.ResponseWriter, req *http.Request)
 		m, _ := json.Marshal(bc)
 		io.WriteString(w, string(m[:]))
 	default:
-		log.Printf("ERROR : Invalid http method")
+		utils.MethodNotAllowed(w, req, http.MethodGet)
 	}
 }
 
 func (bcs *BlockchainServer) Transactions(w http.ResponseWriter, req *http.Request) {
 	switch req.Method {
 	case http.MethodGet:
-		w.Header().Add("Content-Type", "application-json")
+		w.Header().Add("Content-Type", "application/json")
 		bc := bcs.GetBlockchain()
 		transactions := bc.GetTransactionPool()
-		m, _ := json.Marshal(struct {
-			Transactions []*block.Transaction `json:"transactions"`
-			Length       int                  `json:"length"`
-		}{
+		m, _ := json.Marshal(&PoolResponse{
 			Transactions: transactions,
 			Length:       len(transactions),
 		})
@@ -70,98 +120,613 @@ func (bcs *BlockchainServer) Transactions(w http.ResponseWriter, req *http.Reque
 		var t block.TransactionRequest
 		err := decoder.Decode(&t)
 		if err != nil {
-			log.Printf("ERROR: %v", err)
-			io.WriteString(w, string(utils.JsonStatus("fail")))
+			utils.JsonError(w, req, http.StatusBadRequest, utils.ERR_INVALID_JSON, err.Error(), nil)
 			return
 		}
 
-		if !t.Validate() {
-			log.Println("ERROR: missing fields")
-			io.WriteString(w, string(utils.JsonStatus("fail")))
+		if missing := t.MissingFields(); len(missing) > 0 {
+			utils.JsonError(w, req, http.StatusBadRequest, utils.ERR_MISSING_FIELDS, "missing fields",
+				map[string][]string{"fields": missing})
 			return
 		}
 
-		publickey := utils.PublicKeyFromString(*t.SenderPublicKey)
-		signature := utils.SignatureFromString(*t.Signature)
-		bc := bcs.GetBlockchain()
-		isCreated := bc.CreateTransaction(*t.SenderBlockchainAddress, *t.RecipientBlockchainAddress, *t.Value, publickey, signature)
-		w.Header().Add("Content-Type", "application/json")
-		var m []byte
-		if !isCreated {
-			w.WriteHeader(http.StatusBadRequest)
-			m = utils.JsonStatus("fail")
-		} else {
-			w.WriteHeader(http.StatusCreated)
-			m = utils.JsonStatus("success")
+		tx, err := bcs.submitTransaction(&t)
+		var fe *fieldError
+		if errors.As(err, &fe) {
+			utils.JsonError(w, req, http.StatusBadRequest, utils.ERR_INVALID_FIELD, fe.Error(),
+				map[string]string{"field": fe.field})
+			return
+		}
+		if err != nil {
+			status, code := transactionError(err)
+			utils.JsonError(w, req, status, code, err.Error(), nil)
+			return
 		}
+		w.Header().Add("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		m, _ := json.Marshal(&TransactionCreatedResponse{
+			Message: "success",
+			TxID:    fmt.Sprintf("%x", tx.Hash()),
+		})
 		io.WriteString(w, string(m))
 
 	default:
-		log.Println("ERROR: Invalid http method")
+		utils.MethodNotAllowed(w, req, http.MethodGet, http.MethodPost)
 	}
 }
 
-func (bcs *BlockchainServer) Mine(w http.ResponseWriter, req *http.Request) {
+// a request field with a value that can't be used
+type fieldError struct {
+	field   string
+	message string
+}
+
+func (e *fieldError) Error() string {
+	return e.field + " " + e.message
+}
+
+// builds the transaction of a complete request and hands it to the
+// blockchain, shared by the REST and JSON-RPC interfaces
+func (bcs *BlockchainServer) submitTransaction(t *block.TransactionRequest) (*block.Transaction, error) {
+	tx := block.NewTransaction(*t.SenderBlockchainAddress, *t.RecipientBlockchainAddress, *t.Value)
+	if t.ChainID != nil {
+		tx.ChainID = *t.ChainID
+	}
+	if t.LockTime != nil {
+		tx.LockTime = *t.LockTime
+	}
+	var publickey *ecdsa.PublicKey
+	var signature *utils.Signature
+	if t.RedeemScript != nil {
+		tx.RedeemScript = *t.RedeemScript
+		tx.UnlockScript = *t.UnlockScript
+	} else {
+		if !utils.IsHex(*t.SenderPublicKey, 128) {
+			return nil, &fieldError{"sender_public_key", "must be 128 hex characters"}
+		}
+		if !utils.IsHex(*t.Signature, 128) {
+			return nil, &fieldError{"signature", "must be 128 hex characters"}
+		}
+		publickey = utils.PublicKeyFromString(*t.SenderPublicKey)
+		signature = utils.SignatureFromString(*t.Signature)
+	}
+	if err := bcs.GetBlockchain().CreateTransaction(tx, publickey, signature); err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
+// status and error code for a transaction AddTransaction turned away
+func transactionError(err error) (int, string) {
+	switch {
+	case errors.Is(err, block.ErrDuplicateTransaction):
+		return http.StatusConflict, "duplicate_transaction"
+	case errors.Is(err, block.ErrInvalidSignature):
+		return http.StatusUnprocessableEntity, "invalid_signature"
+	case errors.Is(err, block.ErrInvalidScript):
+		return http.StatusUnprocessableEntity, "invalid_script"
+	case errors.Is(err, block.ErrInsufficientFunds):
+		return http.StatusUnprocessableEntity, "insufficient_funds"
+	case errors.Is(err, block.ErrWrongChain):
+		return http.StatusUnprocessableEntity, "wrong_chain"
+	case errors.Is(err, block.ErrLocked):
+		return http.StatusUnprocessableEntity, "locked"
+	case errors.Is(err, block.ErrMiningSender):
+		return http.StatusUnprocessableEntity, "mining_sender"
+	}
+	return http.StatusInternalServerError, utils.ERR_INTERNAL
+}
+
+// a single block, by height or by hex hash
+func (bcs *BlockchainServer) Block(w http.ResponseWriter, req *http.Request) {
 	switch req.Method {
 	case http.MethodGet:
 		bc := bcs.GetBlockchain()
-		isMined := bc.Mining()
-
-		var m []byte
-		if !isMined {
-			w.WriteHeader(http.StatusBadRequest)
-			m = utils.JsonStatus("fail")
+		var b *block.Block
+		if h := req.URL.Query().Get("hash"); h != "" {
+			hash, err := hex.DecodeString(h)
+			if err != nil || len(hash) != 32 {
+				utils.JsonError(w, req, http.StatusBadRequest, utils.ERR_INVALID_FIELD,
+					"hash must be 64 hex characters", map[string]string{"field": "hash"})
+				return
+			}
+			b = bc.GetBlock([32]byte(hash))
 		} else {
-			m = utils.JsonStatus("success")
+			height, err := strconv.Atoi(req.URL.Query().Get("height"))
+			if err != nil {
+				utils.JsonError(w, req, http.StatusBadRequest, utils.ERR_INVALID_FIELD,
+					"height or hash required", map[string]string{"field": "height"})
+				return
+			}
+			b = bc.BlockAt(height)
+		}
+		if b == nil {
+			utils.JsonError(w, req, http.StatusNotFound, utils.ERR_NOT_FOUND, "block not found", nil)
+			return
+		}
+		if b.Pruned() {
+			utils.JsonError(w, req, http.StatusGone, utils.ERR_GONE, block.ErrBlockPruned.Error(), nil)
+			return
+		}
+		m, _ := json.Marshal(b)
+		w.Header().Add("Content-Type", "application/json")
+		io.WriteString(w, string(m[:]))
+	default:
+		utils.MethodNotAllowed(w, req, http.MethodGet)
+	}
+}
+
+func (bcs *BlockchainServer) Mine(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodPost:
+		bc := bcs.GetBlockchain()
+		if err := bc.Mining(); err != nil {
+			utils.JsonError(w, req, http.StatusConflict, utils.ERR_CONFLICT, err.Error(), nil)
+			return
 		}
+		m := utils.JsonStatus("success")
 		w.Header().Add("Content-Type", "application/json")
 		io.WriteString(w, string(m))
 	default:
-		log.Println("ERROR: Invalid HTTP Method")
-		w.WriteHeader(http.StatusBadRequest)
+		utils.MethodNotAllowed(w, req, http.MethodPost)
 	}
 }
 
+// starts the mining loop, starting it again changes nothing
 func (bcs *BlockchainServer) StartMine(w http.ResponseWriter, req *http.Request) {
 	switch req.Method {
-	case http.MethodGet:
+	case http.MethodPost:
 		bc := bcs.GetBlockchain()
-		bc.StartMining()
+		if bc.StartMining() {
+			log.Println("mining started")
+		}
+		writeMiningStatus(w, bc)
+	default:
+		utils.MethodNotAllowed(w, req, http.MethodPost)
+	}
+}
 
-		m := utils.JsonStatus("success")
+func (bcs *BlockchainServer) StopMine(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodPost:
+		bc := bcs.GetBlockchain()
+		if bc.StopMining() {
+			log.Println("mining stopped")
+		}
+		writeMiningStatus(w, bc)
+	default:
+		utils.MethodNotAllowed(w, req, http.MethodPost)
+	}
+}
+
+func (bcs *BlockchainServer) MineStatus(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		writeMiningStatus(w, bcs.GetBlockchain())
+	default:
+		utils.MethodNotAllowed(w, req, http.MethodGet)
+	}
+}
+
+func writeMiningStatus(w http.ResponseWriter, bc *block.Blockchain) {
+	m, _ := json.Marshal(bc.MiningStatus())
+	w.Header().Add("Content-Type", "application/json")
+	io.WriteString(w, string(m))
+}
+
+// fields left out keep their current value
+type MiningSettingsRequest struct {
+	IntervalSec *int    `json:"interval_sec"`
+	Address     *string `json:"address"`
+	MineEmpty   *bool   `json:"mine_empty"`
+}
+
+// GET shows the mining settings, POST changes them while the node runs
+func (bcs *BlockchainServer) MineSettings(w http.ResponseWriter, req *http.Request) {
+	bc := bcs.GetBlockchain()
+	switch req.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var r MiningSettingsRequest
+		if err := json.NewDecoder(req.Body).Decode(&r); err != nil {
+			utils.JsonError(w, req, http.StatusBadRequest, utils.ERR_INVALID_JSON, err.Error(), nil)
+			return
+		}
+		settings := bc.MiningSettings()
+		if r.IntervalSec != nil {
+			settings.IntervalSec = *r.IntervalSec
+		}
+		if r.Address != nil {
+			settings.Address = *r.Address
+		}
+		if r.MineEmpty != nil {
+			settings.MineEmpty = *r.MineEmpty
+		}
+		if err := bc.SetMiningSettings(settings); err != nil {
+			utils.JsonError(w, req, http.StatusBadRequest, utils.ERR_INVALID_FIELD, err.Error(), nil)
+			return
+		}
+		log.Printf("mining settings: interval=%ds address=%s mine_empty=%v",
+			settings.IntervalSec, settings.Address, settings.MineEmpty)
+	default:
+		utils.MethodNotAllowed(w, req, http.MethodGet, http.MethodPost)
+		return
+	}
+	m, _ := json.Marshal(bc.MiningSettings())
+	w.Header().Add("Content-Type", "application/json")
+	io.WriteString(w, string(m))
+}
+
+// work for an external miner, the coinbase pays address or the node
+func (bcs *BlockchainServer) MineTemplate(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		t := bcs.GetBlockchain().NewBlockTemplate(req.URL.Query().Get("address"))
+		m, _ := json.Marshal(t)
+		w.Header().Add("Content-Type", "application/json")
+		io.WriteString(w, string(m))
+	default:
+		utils.MethodNotAllowed(w, req, http.MethodGet)
+	}
+}
+
+type BlockSubmittedResponse struct {
+	Message string `json:"message"`
+	Hash    string `json:"hash"`
+	Height  int    `json:"height"`
+}
+
+// a block mined from a template, added if it still extends the tip
+func (bcs *BlockchainServer) MineSubmit(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodPost:
+		var b block.Block
+		if err := json.NewDecoder(req.Body).Decode(&b); err != nil {
+			utils.JsonError(w, req, http.StatusBadRequest, utils.ERR_INVALID_JSON, err.Error(), nil)
+			return
+		}
+		bc := bcs.GetBlockchain()
+		if err := bc.SubmitBlock(&b); err != nil {
+			status, code := blockError(err)
+			utils.JsonError(w, req, status, code, err.Error(), nil)
+			return
+		}
+		m, _ := json.Marshal(&BlockSubmittedResponse{
+			Message: "success",
+			Hash:    fmt.Sprintf("%x", b.Hash()),
+			Height:  bc.GetBlockHeight(b.Hash()),
+		})
 		w.Header().Add("Content-Type", "application/json")
 		io.WriteString(w, string(m))
 	default:
-		log.Println("ERROR: Invalid HTTP Method")
-		w.WriteHeader(http.StatusBadRequest)
+		utils.MethodNotAllowed(w, req, http.MethodPost)
 	}
 }
 
+func blockError(err error) (int, string) {
+	switch {
+	case errors.Is(err, block.ErrStaleBlock):
+		return http.StatusConflict, "stale_block"
+	case errors.Is(err, block.ErrInvalidBlock):
+		return http.StatusUnprocessableEntity, "invalid_block"
+	}
+	return http.StatusInternalServerError, utils.ERR_INTERNAL
+}
+
 func (bcs *BlockchainServer) Amount(w http.ResponseWriter, req *http.Request) {
 	switch req.Method {
 	case http.MethodGet:
 		blockchainAddress := req.URL.Query().Get("blockchain_address")
-		amount := bcs.GetBlockchain().CalculateTotalAmount(blockchainAddress)
+		if blockchainAddress == "" {
+			utils.JsonError(w, req, http.StatusBadRequest, utils.ERR_MISSING_FIELDS, "blockchain_address required",
+				map[string][]string{"fields": {"blockchain_address"}})
+			return
+		}
+		m, _ := bcs.amount(blockchainAddress).MarshalJSON()
 
-		ar := &block.AmountResponse{amount}
-		m, _ := ar.MarshalJSON()
+		w.Header().Add("Content-Type", "application/json")
+		io.WriteString(w, string(m[:]))
 
+	default:
+		utils.MethodNotAllowed(w, req, http.MethodGet)
+	}
+}
+
+func (bcs *BlockchainServer) amount(blockchainAddress string) *block.AmountResponse {
+	bc := bcs.GetBlockchain()
+	return &block.AmountResponse{
+		Amount:    bc.CalculateTotalAmount(blockchainAddress),
+		Immature:  bc.CalculateImmatureAmount(blockchainAddress, bc.Height()+1),
+		Spendable: bc.CalculateSpendableAmount(blockchainAddress),
+	}
+}
+
+// the whole chain as JSON Lines, see block.Export
+func (bcs *BlockchainServer) ExportChain(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		bc := bcs.GetBlockchain()
+		w.Header().Add("Content-Type", "application/x-ndjson")
+		err := bc.Export(w)
+		if errors.Is(err, block.ErrBlockPruned) {
+			utils.JsonError(w, req, http.StatusGone, utils.ERR_GONE, err.Error(), nil)
+			return
+		}
+		if err != nil {
+			log.Printf("ERROR: export: %v", err)
+		}
+	default:
+		utils.MethodNotAllowed(w, req, http.MethodGet)
+	}
+}
+
+// state at a height, the tip when none is given
+func (bcs *BlockchainServer) Snapshot(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		bc := bcs.GetBlockchain()
+		height := bc.Height()
+		if h := req.URL.Query().Get("height"); h != "" {
+			var err error
+			height, err = strconv.Atoi(h)
+			if err != nil {
+				utils.JsonError(w, req, http.StatusBadRequest, utils.ERR_INVALID_FIELD,
+					"height must be a number", map[string]string{"field": "height"})
+				return
+			}
+		}
+		if height < 0 || height > bc.Height() {
+			utils.JsonError(w, req, http.StatusNotFound, utils.ERR_NOT_FOUND, "block not found", nil)
+			return
+		}
+		s, err := bc.Snapshot(height)
+		if errors.Is(err, block.ErrBlockPruned) {
+			utils.JsonError(w, req, http.StatusGone, utils.ERR_GONE, err.Error(), nil)
+			return
+		}
+		if err != nil {
+			utils.JsonError(w, req, http.StatusInternalServerError, utils.ERR_INTERNAL, err.Error(), nil)
+			return
+		}
+		m, _ := json.Marshal(s)
+		w.Header().Add("Content-Type", "application/json")
+		io.WriteString(w, string(m[:]))
+	default:
+		utils.MethodNotAllowed(w, req, http.MethodGet)
+	}
+}
+
+// headers from height from on, for light clients
+func (bcs *BlockchainServer) Headers(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		from, err := strconv.Atoi(req.URL.Query().Get("from"))
+		if err != nil || from < 0 {
+			utils.JsonError(w, req, http.StatusBadRequest, utils.ERR_INVALID_FIELD,
+				"from must be a height", map[string]string{"field": "from"})
+			return
+		}
+		bc := bcs.GetBlockchain()
+		m, _ := json.Marshal(&HeadersResponse{
+			From:    from,
+			Headers: bc.Headers(from, p2p.MAX_HEADERS),
+		})
+		w.Header().Add("Content-Type", "application/json")
+		io.WriteString(w, string(m[:]))
+	default:
+		utils.MethodNotAllowed(w, req, http.MethodGet)
+	}
+}
+
+// confirmed transactions of an address with merkle proofs, so light
+// clients can check them against their own headers
+func (bcs *BlockchainServer) AddressTransactions(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		blockchainAddress := req.URL.Query().Get("blockchain_address")
+		if blockchainAddress == "" {
+			utils.JsonError(w, req, http.StatusBadRequest, utils.ERR_MISSING_FIELDS, "blockchain_address required",
+				map[string][]string{"fields": {"blockchain_address"}})
+			return
+		}
+		bc := bcs.GetBlockchain()
+		m, _ := json.Marshal(&AddressTransactionsResponse{
+			Transactions: bc.ProveTransactions(blockchainAddress),
+		})
+		w.Header().Add("Content-Type", "application/json")
+		io.WriteString(w, string(m[:]))
+	default:
+		utils.MethodNotAllowed(w, req, http.MethodGet)
+	}
+}
+
+func (bcs *BlockchainServer) Supply(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		bc := bcs.GetBlockchain()
+		height := bc.Height()
+		circulating := bc.CirculatingSupply()
+		maxSupply := bc.Genesis().MaxSupply
+		var remaining *float32
+		if maxSupply > 0 {
+			r := maxSupply - circulating
+			remaining = &r
+		}
+		m, _ := json.Marshal(&SupplyResponse{
+			Height:          height,
+			BlockReward:     bc.Genesis().Reward(height + 1),
+			HalvingInterval: bc.Genesis().HalvingInterval,
+			Circulating:     circulating,
+			MaxSupply:       maxSupply,
+			Remaining:       remaining,
+		})
+		w.Header().Add("Content-Type", "application/json")
+		io.WriteString(w, string(m[:]))
+	default:
+		utils.MethodNotAllowed(w, req, http.MethodGet)
+	}
+}
+
+func (bcs *BlockchainServer) Network(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		bc := bcs.GetBlockchain()
+		m, _ := json.Marshal(&block.NetworkResponse{
+			ChainID:     bc.ChainID,
+			GenesisHash: fmt.Sprintf("%x", bc.Genesis().Hash()),
+			Height:      bc.Height(),
+			PruneHeight: bc.PruneHeight(),
+		})
+		w.Header().Add("Content-Type", "application/json")
+		io.WriteString(w, string(m[:]))
+	default:
+		utils.MethodNotAllowed(w, req, http.MethodGet)
+	}
+}
+
+func (bcs *BlockchainServer) Sync(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		if bcs.node == nil {
+			utils.JsonError(w, req, http.StatusServiceUnavailable, utils.ERR_UNAVAILABLE, "p2p is not running", nil)
+			return
+		}
+		m, _ := json.Marshal(bcs.node.SyncProgress())
 		w.Header().Add("Content-Type", "application/json")
 		io.WriteString(w, string(m[:]))
+	default:
+		utils.MethodNotAllowed(w, req, http.MethodGet)
+	}
+}
+
+type PeersResponse struct {
+	Connected []*p2p.PeerInfo    `json:"connected"`
+	Known     []p2p.KnownAddress `json:"known"`
+}
+
+type AddPeerRequest struct {
+	Addr *string `json:"addr" openapi:"required"`
+}
+
+// Error is why the connection failed, the address is kept and dialed
+// again later either way
+type AddPeerResponse struct {
+	Addr      string `json:"addr"`
+	Connected bool   `json:"connected"`
+	Error     string `json:"error,omitempty"`
+}
 
+// GET lists connected and known peers, POST adds an address and dials it
+func (bcs *BlockchainServer) Peers(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		pr := &PeersResponse{Connected: make([]*p2p.PeerInfo, 0), Known: bcs.addrs.Addresses()}
+		if bcs.node != nil {
+			pr.Connected = bcs.node.PeerInfo()
+		}
+		m, _ := json.Marshal(pr)
+		w.Header().Add("Content-Type", "application/json")
+		io.WriteString(w, string(m))
+	case http.MethodPost:
+		var r AddPeerRequest
+		if err := json.NewDecoder(req.Body).Decode(&r); err != nil {
+			utils.JsonError(w, req, http.StatusBadRequest, utils.ERR_INVALID_JSON, err.Error(), nil)
+			return
+		}
+		if r.Addr == nil {
+			utils.JsonError(w, req, http.StatusBadRequest, utils.ERR_MISSING_FIELDS, "missing fields",
+				map[string][]string{"fields": {"addr"}})
+			return
+		}
+		if _, _, err := net.SplitHostPort(*r.Addr); err != nil {
+			utils.JsonError(w, req, http.StatusBadRequest, utils.ERR_INVALID_FIELD,
+				"addr must be host:port", map[string]string{"field": "addr"})
+			return
+		}
+		bcs.addrs.Add([]string{*r.Addr}, "api")
+		ar := &AddPeerResponse{Addr: *r.Addr}
+		if bcs.node == nil {
+			ar.Error = "p2p is not running"
+		} else if err := bcs.node.Connect(*r.Addr); err != nil {
+			ar.Error = err.Error()
+		} else {
+			ar.Connected = true
+		}
+		if err := bcs.addrs.Save(); err != nil {
+			log.Printf("ERROR: saving peers: %v", err)
+		}
+		m, _ := json.Marshal(ar)
+		w.Header().Add("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		io.WriteString(w, string(m))
 	default:
-		log.Printf("ERROR: Invalid HTTP Method")
-		w.WriteHeader(http.StatusBadRequest)
+		utils.MethodNotAllowed(w, req, http.MethodGet, http.MethodPost)
+	}
+}
+
+// starts the peer to peer protocol next to the http api, it dials
+// the seeds and addresses known from previous runs on its own
+func (bcs *BlockchainServer) StartP2P(maxInbound int, maxOutbound int) {
+	bcs.node = p2p.NewServer(bcs.GetBlockchain(), bcs.p2pPort, bcs.addrs)
+	bcs.node.MaxInbound = maxInbound
+	bcs.node.MaxOutbound = maxOutbound
+	if err := bcs.node.Start(); err != nil {
+		log.Fatal(err)
 	}
 }
 
 func (bcs *BlockchainServer) Run() {
-	http.HandleFunc("/", bcs.GetChain)
-	http.HandleFunc("/transactions", bcs.Transactions)
-	http.HandleFunc("/mine", bcs.Mine)
-	http.HandleFunc("/mine/start", bcs.StartMine)
-
-	addr := "0.0.0.0:" + strconv.Itoa(int(bcs.GetPort()))
-	log.Printf("Server is running on %s\n", addr)
-	log.Fatal(http.ListenAndServe(addr, nil))
+	api := NewAPI()
+	bcs.events.Watch(bcs.GetBlockchain())
+	bcs.webhooks.Watch(bcs.GetBlockchain())
+	bcs.webhooks.Start()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", bcs.GetChain)
+	mux.HandleFunc("/transactions", bcs.Transactions)
+	mux.HandleFunc("/block", bcs.Block)
+	mux.HandleFunc("/mine", bcs.Mine)
+	mux.HandleFunc("/mine/start", bcs.StartMine)
+	mux.HandleFunc("/mine/stop", bcs.StopMine)
+	mux.HandleFunc("/mine/status", bcs.MineStatus)
+	mux.HandleFunc("/mine/settings", bcs.MineSettings)
+	mux.HandleFunc("/mine/template", bcs.MineTemplate)
+	mux.HandleFunc("/mine/submit", bcs.MineSubmit)
+	mux.HandleFunc("/amount", bcs.Amount)
+	mux.HandleFunc("/network", bcs.Network)
+	mux.HandleFunc("/supply", bcs.Supply)
+	mux.HandleFunc("/sync", bcs.Sync)
+	mux.HandleFunc("/peers", bcs.Peers)
+	mux.HandleFunc("/headers", bcs.Headers)
+	mux.HandleFunc("/chain/export", bcs.ExportChain)
+	mux.HandleFunc("/snapshot", bcs.Snapshot)
+	mux.HandleFunc("/address/transactions", bcs.AddressTransactions)
+	mux.HandleFunc("/rpc", bcs.RPC)
+	mux.HandleFunc("/events", bcs.Events)
+	mux.HandleFunc("/webhooks", bcs.webhookAuth(bcs.WebhooksHandler))
+	mux.HandleFunc("/webhooks/test", bcs.webhookAuth(bcs.TestWebhook))
+	mux.Handle("/openapi.json", api)
+
+	addr := net.JoinHostPort(bcs.host, strconv.Itoa(int(bcs.GetPort())))
+	srv := lifecycle.NewHTTPServer(addr, utils.WithRequestID(api.Validate(mux)))
+	srv.RegisterOnShutdown(bcs.events.Close)
+
+	// stopped in reverse: the api first, mining before the peers, so the
+	// last blocks still reach the webhooks
+	bcs.lifecycle.Register("webhooks", bcs.webhooks)
+	if bcs.node != nil {
+		bcs.lifecycle.Register("p2p", bcs.node)
+	}
+	bcs.lifecycle.Register("mining", bcs.GetBlockchain())
+	bcs.lifecycle.Register("http", lifecycle.StopFunc(srv.Shutdown))
+
+	if bcs.tlsCert != "" {
+		log.Printf("Server is running on https://%s\n", addr)
+	} else {
+		log.Printf("Server is running on %s\n", addr)
+	}
+	if err := bcs.lifecycle.Run(lifecycle.Serve(srv, bcs.tlsCert, bcs.tlsKey)); err != nil {
+		log.Fatal(err)
+	}
+	log.Println("Server stopped")
 }
@@ -0,0 +1,239 @@
+package blockchain_server
+
+import (
+	"blockchain/block"
+	"blockchain/utils"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// events kept for subscribers that reconnect with Last-Event-ID
+	EVENT_BACKLOG = 256
+	// a subscriber this many events behind is dropped, it reconnects and
+	// catches up from the backlog
+	EVENT_BUFFER    = 64
+	EVENT_KEEPALIVE = 15 * time.Second
+	EVENT_RETRY_MS  = 3000
+)
+
+type Event struct {
+	ID   uint64
+	Type string
+	// addresses of tx and address events, for filtering
+	addresses []string
+	data      []byte
+}
+
+// what a subscriber wants. no types means all of them, with addresses
+// set tx and address events are limited to those addresses
+type EventFilter struct {
+	Types     map[string]bool
+	Addresses map[string]bool
+}
+
+func (f *EventFilter) match(e *Event) bool {
+	if len(f.Types) > 0 && !f.Types[e.Type] {
+		return false
+	}
+	if len(f.Addresses) == 0 || e.addresses == nil {
+		return true
+	}
+	for _, a := range e.addresses {
+		if f.Addresses[a] {
+			return true
+		}
+	}
+	return false
+}
+
+type Subscription struct {
+	filter *EventFilter
+	events chan *Event
+}
+
+// fans chain events out to the /events streams
+type EventHub struct {
+	subscribers map[*Subscription]bool
+	backlog     []*Event
+	next        uint64
+	closed      bool
+	mux         sync.Mutex
+}
+
+func NewEventHub() *EventHub {
+	return &EventHub{subscribers: make(map[*Subscription]bool), next: 1}
+}
+
+// hooks the hub into bc. the handlers run under the blockchain's lock,
+// publishing never blocks on a subscriber
+func (h *EventHub) Watch(bc *block.Blockchain) {
+	bc.OnBlock(func(b *block.Block, height int) {
+		h.publish(block.EVENT_BLOCK, nil, block.NewBlockEvent(b, height))
+		for _, t := range b.Transactions {
+			h.publishAddresses(t, height)
+		}
+	})
+	bc.OnTransaction(func(t *block.Transaction, tip int) {
+		txid := fmt.Sprintf("%x", t.Hash())
+		h.publish(block.EVENT_TX, t.Addresses(), &block.TxEvent{TxID: txid, Transaction: t})
+		h.publishAddresses(t, -1)
+	})
+	bc.OnReorg(func(r *block.ReorgEvent) {
+		h.publish(block.EVENT_REORG, nil, r)
+	})
+}
+
+func (h *EventHub) publishAddresses(t *block.Transaction, height int) {
+	txid := fmt.Sprintf("%x", t.Hash())
+	for _, a := range t.Addresses() {
+		h.publish(block.EVENT_ADDRESS, []string{a}, &block.AddressEvent{
+			Address:     a,
+			TxID:        txid,
+			Height:      height,
+			Confirmed:   height >= 0,
+			Transaction: t,
+		})
+	}
+}
+
+func (h *EventHub) publish(typ string, addresses []string, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("ERROR: encoding %s event: %v", typ, err)
+		return
+	}
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	e := &Event{ID: h.next, Type: typ, addresses: addresses, data: data}
+	h.next++
+	h.backlog = append(h.backlog, e)
+	if len(h.backlog) > EVENT_BACKLOG {
+		h.backlog = h.backlog[len(h.backlog)-EVENT_BACKLOG:]
+	}
+	for s := range h.subscribers {
+		if !s.filter.match(e) {
+			continue
+		}
+		select {
+		case s.events <- e:
+		default:
+			log.Printf("events: dropping a subscriber %d events behind", EVENT_BUFFER)
+			delete(h.subscribers, s)
+			close(s.events)
+		}
+	}
+}
+
+// subscribes with filter, first replaying the backlog after lastID
+func (h *EventHub) Subscribe(filter *EventFilter, lastID uint64) *Subscription {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	missed := make([]*Event, 0)
+	if lastID > 0 {
+		for _, e := range h.backlog {
+			if e.ID > lastID && filter.match(e) {
+				missed = append(missed, e)
+			}
+		}
+	}
+	s := &Subscription{filter: filter, events: make(chan *Event, EVENT_BUFFER+len(missed))}
+	for _, e := range missed {
+		s.events <- e
+	}
+	if h.closed {
+		close(s.events)
+		return s
+	}
+	h.subscribers[s] = true
+	return s
+}
+
+// ends every stream, subscribing afterwards gets only the backlog. the
+// server closes it when shutting down, streams don't end on their own
+func (h *EventHub) Close() {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	h.closed = true
+	for s := range h.subscribers {
+		delete(h.subscribers, s)
+		close(s.events)
+	}
+}
+
+func (h *EventHub) Unsubscribe(s *Subscription) {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	if h.subscribers[s] {
+		delete(h.subscribers, s)
+		close(s.events)
+	}
+}
+
+// comma separated query values as a set
+func querySet(req *http.Request, key string) map[string]bool {
+	set := make(map[string]bool)
+	for _, v := range strings.Split(req.URL.Query().Get(key), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			set[v] = true
+		}
+	}
+	return set
+}
+
+// server sent events stream: /events?types=block,tx,reorg,address&address=a,b
+func (bcs *BlockchainServer) Events(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			utils.JsonError(w, req, http.StatusInternalServerError, utils.ERR_INTERNAL, "streaming not supported", nil)
+			return
+		}
+		filter := &EventFilter{Types: querySet(req, "types"), Addresses: querySet(req, "address")}
+		for t := range filter.Types {
+			switch t {
+			case block.EVENT_BLOCK, block.EVENT_TX, block.EVENT_REORG, block.EVENT_ADDRESS:
+			default:
+				utils.JsonError(w, req, http.StatusBadRequest, utils.ERR_INVALID_FIELD,
+					"unknown event type "+t, map[string]string{"field": "types"})
+				return
+			}
+		}
+		lastID, _ := strconv.ParseUint(req.Header.Get("Last-Event-ID"), 10, 64)
+
+		sub := bcs.events.Subscribe(filter, lastID)
+		defer bcs.events.Unsubscribe(sub)
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		fmt.Fprintf(w, "retry: %d\n\n", EVENT_RETRY_MS)
+		flusher.Flush()
+
+		keepalive := time.NewTicker(EVENT_KEEPALIVE)
+		defer keepalive.Stop()
+		for {
+			select {
+			case <-req.Context().Done():
+				return
+			case <-keepalive.C:
+				fmt.Fprint(w, ": keepalive\n\n")
+			case e, ok := <-sub.events:
+				if !ok {
+					return
+				}
+				fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, e.data)
+			}
+			flusher.Flush()
+		}
+	default:
+		utils.MethodNotAllowed(w, req, http.MethodGet)
+	}
+}
@@ -1,19 +1,161 @@
-package main
+package blockchain_server
 
 import (
+	"blockchain/block"
+	"blockchain/config"
+	"blockchain/p2p"
 	"flag"
+	"fmt"
 	"log"
+	"os"
+	"path/filepath"
 )
 
-// special predefined function
-func init() {
+// runs a node with the settings of the config file, environment and the
+// command line flags in args, relative file names are taken to be in
+// datadir
+func Main(name string, args []string, datadir string) {
 	//setting prefix for all logs
 	log.SetPrefix("Blockchain: ")
-}
+	log.SetFlags(log.LstdFlags)
+
+	cfg := config.DefaultNode()
+	configFile := configPath(args, datadir)
+	if err := config.Load(cfg, configFile, config.NODE_ENV_PREFIX); err != nil {
+		log.Fatalf("config: %v", err)
+	}
+
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.String("config", configFile, "Config file, default $"+config.NODE_ENV_PREFIX+"CONFIG or blockchain.toml in the data directory")
+	fs.StringVar(&cfg.API.Host, "host", cfg.API.Host, "Interface the http api listens on")
+	fs.UintVar(&cfg.API.Port, "port", cfg.API.Port, "TCP Port Number for Blockchain Server")
+	fs.StringVar(&cfg.API.TLSCert, "tls_cert", cfg.API.TLSCert, "Certificate file, the api is served over https when set")
+	fs.StringVar(&cfg.API.TLSKey, "tls_key", cfg.API.TLSKey, "Private key file of -tls_cert")
+	fs.UintVar(&cfg.Peers.Port, "p2p_port", cfg.Peers.Port, "TCP Port Number for the peer to peer protocol")
+	fs.Var(&cfg.Peers.Seeds, "seeds", "Comma separated host:port list of seed peers")
+	fs.StringVar(&cfg.Peers.File, "peers_file", cfg.Peers.File, "File the known peer addresses are kept in")
+	fs.IntVar(&cfg.Peers.MaxInbound, "max_inbound", cfg.Peers.MaxInbound, "Maximum number of inbound peer connections")
+	fs.IntVar(&cfg.Peers.MaxOutbound, "max_outbound", cfg.Peers.MaxOutbound, "Maximum number of outbound peer connections")
+	fs.StringVar(&cfg.Network.Name, "network", cfg.Network.Name, "Built in network to join (mainnet, testnet)")
+	fs.StringVar(&cfg.Network.Genesis, "genesis", cfg.Network.Genesis, "Genesis spec file, overrides -network")
+	fs.StringVar(&cfg.Storage.WebhooksFile, "webhooks_file", cfg.Storage.WebhooksFile, "File webhook registrations and pending deliveries are kept in")
+	fs.BoolVar(&cfg.Webhooks.AllowPrivate, "webhooks_allow_private", cfg.Webhooks.AllowPrivate, "Let webhooks post to loopback, link-local and private addresses")
+	fs.StringVar(&cfg.Storage.Snapshot, "snapshot", cfg.Storage.Snapshot, "Snapshot file to start the chain from instead of the genesis block")
+	fs.StringVar(&cfg.Storage.SnapshotCommitment, "snapshot_commitment", cfg.Storage.SnapshotCommitment, "Commitment the -snapshot must have, from a node you trust")
+	fs.StringVar(&cfg.Storage.Import, "import", cfg.Storage.Import, "Chain export to add blocks from before joining the network")
+	fs.IntVar(&cfg.Storage.Prune, "prune", cfg.Storage.Prune, fmt.Sprintf("Keep the bodies of only this many last blocks, at least %d, 0 keeps all", block.MIN_PRUNE_KEEP))
+	fs.BoolVar(&cfg.Mining.Start, "mine", cfg.Mining.Start, "Start mining with the node")
+	fs.Parse(args)
+
+	if err := cfg.Validate(); err != nil {
+		log.Fatalf("invalid settings:\n%v", err)
+	}
+	if configFile != "" {
+		log.Printf("config: %s", configFile)
+	}
+
+	if err := os.MkdirAll(datadir, 0700); err != nil {
+		log.Fatal(err)
+	}
+	peersFile := InDatadir(datadir, cfg.Peers.File)
+	webhooksFile := InDatadir(datadir, cfg.Storage.WebhooksFile)
+
+	genesis, err := cfg.Genesis()
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("chain_id: %s genesis: %x", genesis.ChainID, genesis.Hash())
+
+	addrs := p2p.NewAddrManager(peersFile, cfg.Peers.Seeds)
+	if err := addrs.Load(); err != nil {
+		log.Fatalf("peers file %s: %v", peersFile, err)
+	}
 
-func main() {
-	port := flag.Uint("port", 5000, "TCP Port Number for Blockchain Server")
-	flag.Parse()
-	app := NewBlockchainServer(uint16(*port))
+	webhooks := NewWebhooks(webhooksFile)
+	webhooks.AllowPrivate = cfg.Webhooks.AllowPrivate
+	webhooks.Token = cfg.Webhooks.Token
+	if err := webhooks.Load(); err != nil {
+		log.Fatalf("webhooks file %s: %v", webhooksFile, err)
+	}
+
+	app := NewBlockchainServer(uint16(cfg.API.Port), uint16(cfg.Peers.Port), addrs, genesis)
+	app.ListenOn(cfg.API.Host)
+	if cfg.API.TLS() {
+		app.UseTLS(cfg.API.TLSCert, cfg.API.TLSKey)
+	}
+	app.UseWebhooks(webhooks)
+	if cfg.Storage.Snapshot != "" {
+		s, err := block.LoadSnapshot(cfg.Storage.Snapshot)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := app.StartFromSnapshot(s, cfg.Storage.SnapshotCommitment); err != nil {
+			log.Fatalf("snapshot %s: %v", cfg.Storage.Snapshot, err)
+		}
+	}
+	bc := app.GetBlockchain()
+	if err := bc.SetPruning(cfg.Storage.Prune); err != nil {
+		log.Fatalf("prune: %v", err)
+	}
+	settings := bc.MiningSettings()
+	settings.IntervalSec = cfg.Mining.IntervalSec
+	settings.MineEmpty = cfg.Mining.MineEmpty
+	if cfg.Mining.Address != "" {
+		settings.Address = cfg.Mining.Address
+	}
+	if err := bc.SetMiningSettings(settings); err != nil {
+		log.Fatalf("mining: %v", err)
+	}
+	if cfg.Storage.Import != "" {
+		if err := importChain(bc, cfg.Storage.Import); err != nil {
+			log.Fatalf("import %s: %v", cfg.Storage.Import, err)
+		}
+	}
+	app.StartP2P(cfg.Peers.MaxInbound, cfg.Peers.MaxOutbound)
+	if cfg.Mining.Start && bc.StartMining() {
+		log.Printf("mining started, reward address %s", settings.Address)
+	}
 	app.Run()
 }
+
+// the -config flag, then the environment, then blockchain.toml in datadir
+// if there is one
+func configPath(args []string, datadir string) string {
+	if path := config.FindFlag(args, "config"); path != "" {
+		return path
+	}
+	if path := os.Getenv(config.NODE_ENV_PREFIX + "CONFIG"); path != "" {
+		return path
+	}
+	path := filepath.Join(datadir, "blockchain.toml")
+	if _, err := os.Stat(path); err != nil {
+		return ""
+	}
+	return path
+}
+
+func importChain(bc *block.Blockchain, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	er, err := block.NewExportReader(f)
+	if err != nil {
+		return err
+	}
+	n, err := bc.Import(er)
+	if err != nil {
+		return err
+	}
+	log.Printf("imported %d blocks, height %d", n, bc.Height())
+	return nil
+}
+
+// path inside datadir unless it is absolute, empty stays empty
+func InDatadir(datadir string, path string) string {
+	if path == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(datadir, path)
+}
@@ -0,0 +1,200 @@
+package blockchain_server
+
+import (
+	"blockchain/block"
+	"blockchain/p2p"
+	"blockchain/utils"
+	"net/http"
+)
+
+const API_VERSION = "1.0.0"
+
+type PoolResponse struct {
+	Transactions []*block.Transaction `json:"transactions"`
+	Length       int                  `json:"length"`
+}
+
+type TransactionCreatedResponse struct {
+	Message string `json:"message"`
+	TxID    string `json:"txid"`
+}
+
+type HeadersResponse struct {
+	From    int                  `json:"from"`
+	Headers []*block.BlockHeader `json:"headers"`
+}
+
+type AddressTransactionsResponse struct {
+	Transactions []*block.ProvenTransaction `json:"transactions"`
+}
+
+type SupplyResponse struct {
+	Height          int     `json:"height"`
+	BlockReward     float32 `json:"block_reward"`
+	HalvingInterval int     `json:"halving_interval"`
+	Circulating     float32 `json:"circulating"`
+	MaxSupply       float32 `json:"max_supply"`
+	// unset without a supply cap
+	Remaining *float32 `json:"remaining"`
+}
+
+var addressParam = utils.Param{Name: "blockchain_address", Required: true, Type: "string"}
+
+// the node's http api, served on /openapi.json and checked by Validate
+func NewAPI() *utils.OpenAPI {
+	api := utils.NewOpenAPI("blockchain node", API_VERSION)
+	api.Add(http.MethodGet, "/", utils.Route{
+		Summary:  "the whole chain and transaction pool",
+		Response: block.Blockchain{},
+	})
+	api.Add(http.MethodGet, "/transactions", utils.Route{
+		Summary:  "transactions waiting in the pool",
+		Response: PoolResponse{},
+	})
+	api.Add(http.MethodPost, "/transactions", utils.Route{
+		Summary:  "submit a signed transaction",
+		Body:     block.TransactionRequest{},
+		Status:   http.StatusCreated,
+		Response: TransactionCreatedResponse{},
+		Errors:   []int{http.StatusConflict, http.StatusUnprocessableEntity},
+	})
+	api.Add(http.MethodGet, "/block", utils.Route{
+		Summary: "a block by height or by hash",
+		Query: []utils.Param{
+			{Name: "height", Type: "integer"},
+			{Name: "hash", Description: "64 hex characters, wins over height", Type: "string"},
+		},
+		Response: block.Block{},
+		Errors:   []int{http.StatusNotFound, http.StatusGone},
+	})
+	api.Add(http.MethodPost, "/mine", utils.Route{
+		Summary:  "mine one block from the pool",
+		Response: utils.StatusResponse{},
+		Errors:   []int{http.StatusConflict},
+	})
+	api.Add(http.MethodPost, "/mine/start", utils.Route{
+		Summary:  "start the mining loop, does nothing when it runs",
+		Response: block.MiningStatus{},
+	})
+	api.Add(http.MethodPost, "/mine/stop", utils.Route{
+		Summary:  "stop the mining loop, giving up the block being mined",
+		Response: block.MiningStatus{},
+	})
+	api.Add(http.MethodGet, "/mine/status", utils.Route{
+		Summary:  "whether the loop runs, hash rate, blocks found and the next block",
+		Response: block.MiningStatus{},
+	})
+	api.Add(http.MethodGet, "/mine/settings", utils.Route{
+		Summary:  "mining loop settings",
+		Response: block.MiningSettings{},
+	})
+	api.Add(http.MethodPost, "/mine/settings", utils.Route{
+		Summary:  "change mining loop settings, left out fields stay",
+		Body:     MiningSettingsRequest{},
+		Response: block.MiningSettings{},
+	})
+	api.Add(http.MethodGet, "/mine/template", utils.Route{
+		Summary:  "a block for an external miner to find the nonce of",
+		Query:    []utils.Param{{Name: "address", Description: "coinbase address, the node's when empty", Type: "string"}},
+		Response: block.BlockTemplate{},
+	})
+	api.Add(http.MethodPost, "/mine/submit", utils.Route{
+		Summary:  "submit a block mined from a template",
+		Body:     block.Block{},
+		Response: BlockSubmittedResponse{},
+		Errors:   []int{http.StatusConflict, http.StatusUnprocessableEntity},
+	})
+	api.Add(http.MethodGet, "/amount", utils.Route{
+		Summary:  "balance of an address",
+		Query:    []utils.Param{addressParam},
+		Response: block.AmountResponse{},
+	})
+	api.Add(http.MethodGet, "/network", utils.Route{
+		Summary:  "chain id, genesis hash and height",
+		Response: block.NetworkResponse{},
+	})
+	api.Add(http.MethodGet, "/supply", utils.Route{
+		Summary:  "coins issued so far and the reward schedule",
+		Response: SupplyResponse{},
+	})
+	api.Add(http.MethodGet, "/sync", utils.Route{
+		Summary:  "progress of the initial block download",
+		Response: p2p.SyncProgress{},
+		Errors:   []int{http.StatusServiceUnavailable},
+	})
+	api.Add(http.MethodGet, "/peers", utils.Route{
+		Summary:  "connected peers and every address known",
+		Response: PeersResponse{},
+	})
+	api.Add(http.MethodPost, "/peers", utils.Route{
+		Summary:  "add a peer address and dial it",
+		Body:     AddPeerRequest{},
+		Status:   http.StatusCreated,
+		Response: AddPeerResponse{},
+	})
+	api.Add(http.MethodGet, "/headers", utils.Route{
+		Summary:  "a page of block headers",
+		Query:    []utils.Param{{Name: "from", Description: "height of the first header", Required: true, Type: "integer"}},
+		Response: HeadersResponse{},
+	})
+	api.Add(http.MethodGet, "/chain/export", utils.Route{
+		Summary:     "the whole chain as JSON Lines with a checksum per block",
+		ContentType: "application/x-ndjson",
+		Errors:      []int{http.StatusGone},
+	})
+	api.Add(http.MethodGet, "/snapshot", utils.Route{
+		Summary:  "balances at a height with their commitment, to start other nodes from",
+		Query:    []utils.Param{{Name: "height", Description: "the tip when not given", Type: "integer"}},
+		Response: block.Snapshot{},
+		Errors:   []int{http.StatusNotFound, http.StatusGone},
+	})
+	api.Add(http.MethodGet, "/address/transactions", utils.Route{
+		Summary:  "confirmed transactions of an address with merkle proofs",
+		Query:    []utils.Param{addressParam},
+		Response: AddressTransactionsResponse{},
+	})
+	api.Add(http.MethodGet, "/events", utils.Route{
+		Summary: "server sent events: block, tx, reorg and address",
+		Query: []utils.Param{
+			{Name: "types", Description: "comma separated event types, all when empty", Type: "string"},
+			{Name: "address", Description: "comma separated addresses tx and address events are limited to", Type: "string"},
+		},
+		ContentType: "text/event-stream",
+	})
+	// every webhook endpoint wants the webhooks.token as a bearer token
+	webhookErrors := []int{http.StatusUnauthorized, http.StatusForbidden}
+	api.Add(http.MethodGet, "/webhooks", utils.Route{
+		Summary:  "registered webhooks",
+		Response: []WebhookResponse{},
+		Errors:   webhookErrors,
+	})
+	api.Add(http.MethodPost, "/webhooks", utils.Route{
+		Summary:  "register a webhook for transactions received by addresses",
+		Body:     WebhookRequest{},
+		Status:   http.StatusCreated,
+		Response: WebhookResponse{},
+		Errors:   append(webhookErrors, http.StatusConflict),
+	})
+	api.Add(http.MethodDelete, "/webhooks", utils.Route{
+		Summary:  "remove a webhook and its pending deliveries",
+		Query:    []utils.Param{{Name: "id", Required: true, Type: "string"}},
+		Response: utils.StatusResponse{},
+		Errors:   append(webhookErrors, http.StatusNotFound),
+	})
+	api.Add(http.MethodPost, "/webhooks/test", utils.Route{
+		Summary:  "send a test delivery to a webhook",
+		Query:    []utils.Param{{Name: "id", Required: true, Type: "string"}},
+		Status:   http.StatusAccepted,
+		Response: WebhookTestResponse{},
+		Errors:   append(webhookErrors, http.StatusNotFound),
+	})
+	// the body isn't described, batches are arrays of RPCRequest
+	api.Add(http.MethodPost, "/rpc", utils.Route{
+		Summary:  "JSON-RPC 2.0, single calls or batches",
+		Response: RPCResponse{},
+	})
+	api.Add(http.MethodGet, "/openapi.json", utils.Route{
+		Summary: "this document",
+	})
+	return api
+}
@@ -0,0 +1,455 @@
+package blockchain_server
+
+import (
+	"blockchain/block"
+	"blockchain/utils"
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+const JSONRPC_VERSION = "2.0"
+
+// JSON-RPC 2.0 error codes, the application ones follow bitcoind.
+// RPC_INVALID_PARAMS is for params that are missing or of the wrong
+// type, a well formed param with a bad value is RPC_INVALID_PARAMETER
+const (
+	RPC_PARSE_ERROR      = -32700
+	RPC_INVALID_REQUEST  = -32600
+	RPC_METHOD_NOT_FOUND = -32601
+	RPC_INVALID_PARAMS   = -32602
+	RPC_INTERNAL_ERROR   = -32603
+
+	RPC_MISC_ERROR              = -1
+	RPC_INVALID_ADDRESS_OR_KEY  = -5
+	RPC_INVALID_PARAMETER       = -8
+	RPC_VERIFY_REJECTED         = -26
+	RPC_VERIFY_ALREADY_IN_CHAIN = -27
+)
+
+type RPCRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	// absent for notifications, which get no response
+	ID json.RawMessage `json:"id,omitempty"`
+}
+
+type RPCResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *RPCError       `json:"error,omitempty"`
+	ID      json.RawMessage `json:"id"`
+}
+
+type RPCError struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+func (e *RPCError) Error() string {
+	return fmt.Sprintf("rpc error %d: %s", e.Code, e.Message)
+}
+
+type MempoolInfo struct {
+	Size  int     `json:"size"`
+	Bytes int     `json:"bytes"`
+	Total float32 `json:"total"`
+}
+
+type MiningInfo struct {
+	Blocks     int     `json:"blocks"`
+	Difficulty int     `json:"difficulty"`
+	PooledTx   int     `json:"pooledtx"`
+	Chain      string  `json:"chain"`
+	Reward     float32 `json:"reward"`
+	Address    string  `json:"address"`
+	Generate   bool    `json:"generate"`
+	HashRate   float64 `json:"hashespersec"`
+}
+
+type BlockchainInfo struct {
+	Chain                string `json:"chain"`
+	Blocks               int    `json:"blocks"`
+	Headers              int    `json:"headers"`
+	BestBlockHash        string `json:"bestblockhash"`
+	GenesisHash          string `json:"genesishash"`
+	InitialBlockDownload bool   `json:"initialblockdownload"`
+	Pruned               bool   `json:"pruned"`
+	// only set on a pruned node
+	PruneHeight *int `json:"pruneheight,omitempty"`
+}
+
+// params are positional, or named after the entries of params. the
+// first required of them must be given
+type rpcMethod struct {
+	params   []string
+	required int
+	handler  func(bcs *BlockchainServer, args []json.RawMessage) (interface{}, error)
+}
+
+var rpcMethods = map[string]rpcMethod{
+	"getblockcount":     {handler: (*BlockchainServer).rpcGetBlockCount},
+	"getbestblockhash":  {handler: (*BlockchainServer).rpcGetBestBlockHash},
+	"getblockhash":      {params: []string{"height"}, required: 1, handler: (*BlockchainServer).rpcGetBlockHash},
+	"getblock":          {params: []string{"blockhash"}, required: 1, handler: (*BlockchainServer).rpcGetBlock},
+	"getblockchaininfo": {handler: (*BlockchainServer).rpcGetBlockchainInfo},
+	"getbalance":        {params: []string{"address"}, required: 1, handler: (*BlockchainServer).rpcGetBalance},
+	"sendrawtransaction": {params: []string{"transaction"}, required: 1,
+		handler: (*BlockchainServer).rpcSendRawTransaction},
+	"getrawmempool":      {handler: (*BlockchainServer).rpcGetRawMempool},
+	"getmempoolinfo":     {handler: (*BlockchainServer).rpcGetMempoolInfo},
+	"getmininginfo":      {handler: (*BlockchainServer).rpcGetMiningInfo},
+	"getconnectioncount": {handler: (*BlockchainServer).rpcGetConnectionCount},
+	"getblocktemplate":   {params: []string{"address"}, handler: (*BlockchainServer).rpcGetBlockTemplate},
+	"submitblock":        {params: []string{"block"}, required: 1, handler: (*BlockchainServer).rpcSubmitBlock},
+}
+
+// JSON-RPC 2.0 over http POST, single calls and batches
+func (bcs *BlockchainServer) RPC(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodPost:
+		body, err := io.ReadAll(http.MaxBytesReader(w, req.Body, utils.MAX_BODY_SIZE))
+		if err != nil {
+			writeRPC(w, rpcFailure(nil, &RPCError{Code: RPC_PARSE_ERROR, Message: err.Error()}))
+			return
+		}
+		body = bytes.TrimSpace(body)
+		if len(body) > 0 && body[0] == '[' {
+			var batch []json.RawMessage
+			if err := json.Unmarshal(body, &batch); err != nil {
+				writeRPC(w, rpcFailure(nil, &RPCError{Code: RPC_PARSE_ERROR, Message: err.Error()}))
+				return
+			}
+			if len(batch) == 0 {
+				writeRPC(w, rpcFailure(nil, &RPCError{Code: RPC_INVALID_REQUEST, Message: "empty batch"}))
+				return
+			}
+			responses := make([]*RPCResponse, 0, len(batch))
+			for _, raw := range batch {
+				if resp := bcs.rpcCall(raw); resp != nil {
+					responses = append(responses, resp)
+				}
+			}
+			if len(responses) == 0 {
+				// only notifications
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			writeRPC(w, responses)
+			return
+		}
+		resp := bcs.rpcCall(body)
+		if resp == nil {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		writeRPC(w, resp)
+	default:
+		utils.MethodNotAllowed(w, req, http.MethodPost)
+	}
+}
+
+func writeRPC(w http.ResponseWriter, v interface{}) {
+	m, _ := json.Marshal(v)
+	w.Header().Add("Content-Type", "application/json")
+	io.WriteString(w, string(m))
+}
+
+func rpcFailure(id json.RawMessage, e *RPCError) *RPCResponse {
+	if len(id) == 0 {
+		id = json.RawMessage("null")
+	}
+	return &RPCResponse{JSONRPC: JSONRPC_VERSION, Error: e, ID: id}
+}
+
+// runs one call, nil for notifications
+func (bcs *BlockchainServer) rpcCall(raw json.RawMessage) *RPCResponse {
+	var r RPCRequest
+	if err := json.Unmarshal(raw, &r); err != nil {
+		var syntax *json.SyntaxError
+		if errors.As(err, &syntax) {
+			return rpcFailure(nil, &RPCError{Code: RPC_PARSE_ERROR, Message: err.Error()})
+		}
+		return rpcFailure(nil, &RPCError{Code: RPC_INVALID_REQUEST, Message: err.Error()})
+	}
+	if r.JSONRPC != JSONRPC_VERSION || r.Method == "" {
+		return rpcFailure(r.ID, &RPCError{Code: RPC_INVALID_REQUEST, Message: "not a JSON-RPC 2.0 request"})
+	}
+	result, rpcErr := bcs.rpcDispatch(&r)
+	if len(r.ID) == 0 {
+		return nil
+	}
+	if rpcErr != nil {
+		return rpcFailure(r.ID, rpcErr)
+	}
+	m, err := json.Marshal(result)
+	if err != nil {
+		return rpcFailure(r.ID, &RPCError{Code: RPC_INTERNAL_ERROR, Message: err.Error()})
+	}
+	return &RPCResponse{JSONRPC: JSONRPC_VERSION, Result: m, ID: r.ID}
+}
+
+func (bcs *BlockchainServer) rpcDispatch(r *RPCRequest) (interface{}, *RPCError) {
+	method, ok := rpcMethods[r.Method]
+	if !ok {
+		return nil, &RPCError{Code: RPC_METHOD_NOT_FOUND, Message: "method not found: " + r.Method}
+	}
+	args, err := method.args(r.Params)
+	if err != nil {
+		return nil, &RPCError{Code: RPC_INVALID_PARAMS, Message: err.Error()}
+	}
+	result, err := method.handler(bcs, args)
+	if err != nil {
+		var rpcErr *RPCError
+		if errors.As(err, &rpcErr) {
+			return nil, rpcErr
+		}
+		return nil, &RPCError{Code: RPC_INTERNAL_ERROR, Message: err.Error()}
+	}
+	return result, nil
+}
+
+// params in the order of m.params, missing optional ones are nil
+func (m *rpcMethod) args(params json.RawMessage) ([]json.RawMessage, error) {
+	args := make([]json.RawMessage, len(m.params))
+	params = bytes.TrimSpace(params)
+	switch {
+	case len(params) == 0 || string(params) == "null":
+	case params[0] == '[':
+		var list []json.RawMessage
+		if err := json.Unmarshal(params, &list); err != nil {
+			return nil, err
+		}
+		if len(list) > len(m.params) {
+			return nil, fmt.Errorf("takes at most %d params, got %d", len(m.params), len(list))
+		}
+		copy(args, list)
+	case params[0] == '{':
+		var named map[string]json.RawMessage
+		if err := json.Unmarshal(params, &named); err != nil {
+			return nil, err
+		}
+		for i, name := range m.params {
+			args[i] = named[name]
+			delete(named, name)
+		}
+		for name := range named {
+			return nil, fmt.Errorf("unknown param %s", name)
+		}
+	default:
+		return nil, errors.New("params must be an array or an object")
+	}
+	for i := 0; i < m.required; i++ {
+		if args[i] == nil {
+			return nil, fmt.Errorf("missing param %s", m.params[i])
+		}
+	}
+	return args, nil
+}
+
+func invalidParams(format string, a ...interface{}) *RPCError {
+	return &RPCError{Code: RPC_INVALID_PARAMS, Message: fmt.Sprintf(format, a...)}
+}
+
+func invalidParameter(format string, a ...interface{}) *RPCError {
+	return &RPCError{Code: RPC_INVALID_PARAMETER, Message: fmt.Sprintf(format, a...)}
+}
+
+func (bcs *BlockchainServer) rpcGetBlockCount(args []json.RawMessage) (interface{}, error) {
+	return bcs.GetBlockchain().Height(), nil
+}
+
+func (bcs *BlockchainServer) rpcGetBestBlockHash(args []json.RawMessage) (interface{}, error) {
+	return fmt.Sprintf("%x", bcs.GetBlockchain().LastBlock().Hash()), nil
+}
+
+func (bcs *BlockchainServer) rpcGetBlockHash(args []json.RawMessage) (interface{}, error) {
+	var height int
+	if err := json.Unmarshal(args[0], &height); err != nil {
+		return nil, invalidParams("height must be an integer")
+	}
+	b := bcs.GetBlockchain().BlockAt(height)
+	if b == nil {
+		return nil, invalidParameter("block height out of range")
+	}
+	return fmt.Sprintf("%x", b.Hash()), nil
+}
+
+// by hex hash like bitcoind, or by height
+func (bcs *BlockchainServer) rpcGetBlock(args []json.RawMessage) (interface{}, error) {
+	bc := bcs.GetBlockchain()
+	var b *block.Block
+	var h string
+	var height int
+	if err := json.Unmarshal(args[0], &h); err == nil {
+		hash, err := hex.DecodeString(h)
+		if err != nil || len(hash) != 32 {
+			return nil, invalidParameter("blockhash must be 64 hex characters")
+		}
+		b = bc.GetBlock([32]byte(hash))
+	} else if err := json.Unmarshal(args[0], &height); err == nil {
+		b = bc.BlockAt(height)
+	} else {
+		return nil, invalidParams("blockhash must be a hex hash or a height")
+	}
+	if b == nil {
+		return nil, &RPCError{Code: RPC_INVALID_ADDRESS_OR_KEY, Message: "block not found"}
+	}
+	if b.Pruned() {
+		return nil, &RPCError{Code: RPC_MISC_ERROR, Message: "block not available (pruned data)"}
+	}
+	return b, nil
+}
+
+func (bcs *BlockchainServer) rpcGetBlockchainInfo(args []json.RawMessage) (interface{}, error) {
+	bc := bcs.GetBlockchain()
+	info := &BlockchainInfo{
+		Chain:         bc.ChainID,
+		Blocks:        bc.Height(),
+		Headers:       bc.Height(),
+		BestBlockHash: fmt.Sprintf("%x", bc.LastBlock().Hash()),
+		GenesisHash:   fmt.Sprintf("%x", bc.Genesis().Hash()),
+		Pruned:        bc.Pruning() > 0,
+	}
+	if info.Pruned {
+		h := bc.PruneHeight()
+		info.PruneHeight = &h
+	}
+	if bcs.node != nil {
+		progress := bcs.node.SyncProgress()
+		info.Headers = max(info.Headers, progress.HeaderHeight)
+		info.InitialBlockDownload = progress.Syncing
+	}
+	return info, nil
+}
+
+func (bcs *BlockchainServer) rpcGetBalance(args []json.RawMessage) (interface{}, error) {
+	var address string
+	if err := json.Unmarshal(args[0], &address); err != nil {
+		return nil, invalidParams("address must be a string")
+	}
+	if address == "" {
+		return nil, invalidParameter("address can't be empty")
+	}
+	return bcs.amount(address), nil
+}
+
+// takes the transaction as a TransactionRequest object, or that object's
+// JSON hex encoded the way raw transactions are passed to bitcoind
+func (bcs *BlockchainServer) rpcSendRawTransaction(args []json.RawMessage) (interface{}, error) {
+	raw := args[0]
+	var h string
+	if err := json.Unmarshal(raw, &h); err == nil {
+		decoded, err := hex.DecodeString(h)
+		if err != nil {
+			return nil, invalidParams("transaction must be an object or hex encoded JSON")
+		}
+		raw = decoded
+	}
+	var t block.TransactionRequest
+	if err := json.Unmarshal(raw, &t); err != nil {
+		return nil, invalidParams("transaction: %v", err)
+	}
+	if missing := t.MissingFields(); len(missing) > 0 {
+		return nil, &RPCError{Code: RPC_INVALID_PARAMS, Message: "missing fields",
+			Data: map[string][]string{"fields": missing}}
+	}
+	tx, err := bcs.submitTransaction(&t)
+	var fe *fieldError
+	if errors.As(err, &fe) {
+		return nil, invalidParameter("%v", fe)
+	}
+	if err != nil {
+		_, code := transactionError(err)
+		rpcCode := RPC_VERIFY_REJECTED
+		if errors.Is(err, block.ErrDuplicateTransaction) {
+			rpcCode = RPC_VERIFY_ALREADY_IN_CHAIN
+		} else if code == utils.ERR_INTERNAL {
+			rpcCode = RPC_MISC_ERROR
+		}
+		return nil, &RPCError{Code: rpcCode, Message: err.Error(), Data: code}
+	}
+	return fmt.Sprintf("%x", tx.Hash()), nil
+}
+
+func (bcs *BlockchainServer) rpcGetRawMempool(args []json.RawMessage) (interface{}, error) {
+	pool := bcs.GetBlockchain().CopyTransactionPool()
+	txids := make([]string, 0, len(pool))
+	for _, t := range pool {
+		txids = append(txids, fmt.Sprintf("%x", t.Hash()))
+	}
+	return txids, nil
+}
+
+func (bcs *BlockchainServer) rpcGetMempoolInfo(args []json.RawMessage) (interface{}, error) {
+	pool := bcs.GetBlockchain().CopyTransactionPool()
+	info := &MempoolInfo{Size: len(pool)}
+	for _, t := range pool {
+		m, _ := json.Marshal(t)
+		info.Bytes += len(m)
+		info.Total += t.Value
+	}
+	return info, nil
+}
+
+func (bcs *BlockchainServer) rpcGetMiningInfo(args []json.RawMessage) (interface{}, error) {
+	bc := bcs.GetBlockchain()
+	status := bc.MiningStatus()
+	return &MiningInfo{
+		Blocks:     bc.Height(),
+		Difficulty: bc.Genesis().Difficulty,
+		PooledTx:   len(bc.CopyTransactionPool()),
+		Chain:      bc.ChainID,
+		Reward:     bc.Genesis().Reward(bc.Height() + 1),
+		Address:    status.Settings.Address,
+		Generate:   status.Running,
+		HashRate:   status.HashRate,
+	}, nil
+}
+
+// the coinbase pays address, or the node when it is left out
+func (bcs *BlockchainServer) rpcGetBlockTemplate(args []json.RawMessage) (interface{}, error) {
+	var address string
+	if args[0] != nil {
+		if err := json.Unmarshal(args[0], &address); err != nil {
+			return nil, invalidParams("address must be a string")
+		}
+	}
+	return bcs.GetBlockchain().NewBlockTemplate(address), nil
+}
+
+// like bitcoind the result is null when the block is added and the
+// reason otherwise. the block is an object or its JSON hex encoded
+func (bcs *BlockchainServer) rpcSubmitBlock(args []json.RawMessage) (interface{}, error) {
+	raw := args[0]
+	var h string
+	if err := json.Unmarshal(raw, &h); err == nil {
+		decoded, err := hex.DecodeString(h)
+		if err != nil {
+			return nil, invalidParams("block must be an object or hex encoded JSON")
+		}
+		raw = decoded
+	}
+	var b block.Block
+	if err := json.Unmarshal(raw, &b); err != nil {
+		return nil, invalidParams("block: %v", err)
+	}
+	if err := bcs.GetBlockchain().SubmitBlock(&b); err != nil {
+		_, code := blockError(err)
+		return code, nil
+	}
+	return nil, nil
+}
+
+func (bcs *BlockchainServer) rpcGetConnectionCount(args []json.RawMessage) (interface{}, error) {
+	if bcs.node == nil {
+		return 0, nil
+	}
+	return len(bcs.node.Peers()), nil
+}
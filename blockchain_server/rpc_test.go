@@ -0,0 +1,178 @@
+package blockchain_server
+
+import (
+	"blockchain/block"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRPCMethodArgs(t *testing.T) {
+	m := &rpcMethod{params: []string{"address", "verbose"}, required: 1}
+	tests := []struct {
+		name   string
+		params string
+		args   []string
+		err    string
+	}{
+		{"positional", `["a", true]`, []string{`"a"`, `true`}, ""},
+		{"optional left out", ` ["a"] `, []string{`"a"`, ""}, ""},
+		{"named", `{"verbose": false, "address": "a"}`, []string{`"a"`, `false`}, ""},
+		{"named optional left out", `{"address": "a"}`, []string{`"a"`, ""}, ""},
+		{"too many", `["a", true, 1]`, nil, "takes at most 2 params, got 3"},
+		{"unknown name", `{"address": "a", "format": "hex"}`, nil, "unknown param format"},
+		{"missing required", `[]`, nil, "missing param address"},
+		{"missing required by name", `{"verbose": true}`, nil, "missing param address"},
+		{"none", ``, nil, "missing param address"},
+		{"null", `null`, nil, "missing param address"},
+		{"scalar", `"a"`, nil, "params must be an array or an object"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args, err := m.args(json.RawMessage(tt.params))
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Errorf("error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := make([]string, len(args))
+			for i, a := range args {
+				got[i] = string(a)
+			}
+			if !reflect.DeepEqual(got, tt.args) {
+				t.Errorf("args %q, want %q", got, tt.args)
+			}
+		})
+	}
+}
+
+func rpcTestServer() *BlockchainServer {
+	return NewBlockchainServer(0, 0, nil, &block.Genesis{ChainID: "test", Timestamp: 1, Difficulty: 1, MiningReward: 1})
+}
+
+func postRPC(bcs *BlockchainServer, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	bcs.RPC(w, httptest.NewRequest(http.MethodPost, "/rpc", strings.NewReader(body)))
+	return w
+}
+
+func TestRPCCall(t *testing.T) {
+	w := postRPC(rpcTestServer(), `{"jsonrpc": "2.0", "method": "getblockcount", "id": "a"}`)
+	var resp RPCResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Error != nil || string(resp.Result) != "0" || string(resp.ID) != `"a"` {
+		t.Errorf("response %s, want height 0 for id a", w.Body)
+	}
+}
+
+func TestRPCBatch(t *testing.T) {
+	w := postRPC(rpcTestServer(), `[
+		{"jsonrpc": "2.0", "method": "getblockcount", "id": 1},
+		{"jsonrpc": "2.0", "method": "getblockcount"},
+		{"jsonrpc": "2.0", "method": "getblockhash", "params": {"height": 0}, "id": 2},
+		{"jsonrpc": "2.0", "method": "nosuchmethod", "id": 3},
+		{"jsonrpc": "2.0", "method": "getblockhash", "params": [], "id": 4},
+		{"jsonrpc": "1.0", "method": "getblockcount", "id": 5},
+		{"jsonrpc": "2.0", "method": "getblockhash", "params": [0]}
+	]`)
+	var responses []RPCResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &responses); err != nil {
+		t.Fatal(err)
+	}
+	// the two notifications get no response
+	want := map[string]int{"1": 0, "2": 0, "3": RPC_METHOD_NOT_FOUND, "4": RPC_INVALID_PARAMS, "5": RPC_INVALID_REQUEST}
+	if len(responses) != len(want) {
+		t.Fatalf("%d responses, want %d: %s", len(responses), len(want), w.Body)
+	}
+	for _, resp := range responses {
+		code, ok := want[string(resp.ID)]
+		if !ok {
+			t.Errorf("response to unknown id %s", resp.ID)
+			continue
+		}
+		if code == 0 && resp.Error != nil {
+			t.Errorf("call %s failed: %v", resp.ID, resp.Error)
+		}
+		if code != 0 && (resp.Error == nil || resp.Error.Code != code) {
+			t.Errorf("call %s returned %+v, want error %d", resp.ID, resp.Error, code)
+		}
+	}
+}
+
+func TestRPCNotifications(t *testing.T) {
+	bcs := rpcTestServer()
+	for _, body := range []string{
+		`{"jsonrpc": "2.0", "method": "getblockcount"}`,
+		// errors of notifications aren't reported either
+		`{"jsonrpc": "2.0", "method": "nosuchmethod"}`,
+		`[{"jsonrpc": "2.0", "method": "getblockcount"}, {"jsonrpc": "2.0", "method": "getblockhash"}]`,
+	} {
+		w := postRPC(bcs, body)
+		if w.Code != http.StatusNoContent || w.Body.Len() != 0 {
+			t.Errorf("%s: status %d and %q, want no content", body, w.Code, w.Body)
+		}
+	}
+}
+
+func TestRPCRequestErrors(t *testing.T) {
+	bcs := rpcTestServer()
+	for body, code := range map[string]int{
+		`{"jsonrpc": "2.0", "method": `: RPC_PARSE_ERROR,
+		`[{"jsonrpc": "2.0"`:            RPC_PARSE_ERROR,
+		`[]`:                            RPC_INVALID_REQUEST,
+		`{"jsonrpc": "2.0", "id": 1}`:   RPC_INVALID_REQUEST,
+		`{"jsonrpc": "2.0", "method": 1, "id": 1}`:          RPC_INVALID_REQUEST,
+		`{"jsonrpc": "2.0", "method": "getblock", "id": 1}`: RPC_INVALID_PARAMS,
+	} {
+		var resp RPCResponse
+		if err := json.Unmarshal(postRPC(bcs, body).Body.Bytes(), &resp); err != nil {
+			t.Fatalf("%s: %v", body, err)
+		}
+		if resp.Error == nil || resp.Error.Code != code {
+			t.Errorf("%s: error %+v, want %d", body, resp.Error, code)
+		}
+	}
+}
+
+func TestRPCParamErrors(t *testing.T) {
+	bcs := rpcTestServer()
+	tests := []struct {
+		name   string
+		method string
+		params string
+		code   int
+	}{
+		{"height of the wrong type", "getblockhash", `["1"]`, RPC_INVALID_PARAMS},
+		{"height out of range", "getblockhash", `[5]`, RPC_INVALID_PARAMETER},
+		{"negative height", "getblockhash", `[-1]`, RPC_INVALID_PARAMETER},
+		{"blockhash of the wrong type", "getblock", `[true]`, RPC_INVALID_PARAMS},
+		{"short blockhash", "getblock", `["abcd"]`, RPC_INVALID_PARAMETER},
+		{"unknown blockhash", "getblock", `["` + strings.Repeat("0", 64) + `"]`, RPC_INVALID_ADDRESS_OR_KEY},
+		{"address of the wrong type", "getbalance", `[1]`, RPC_INVALID_PARAMS},
+		{"empty address", "getbalance", `[""]`, RPC_INVALID_PARAMETER},
+		{"missing transaction fields", "sendrawtransaction", `[{}]`, RPC_INVALID_PARAMS},
+		{"short public key", "sendrawtransaction", `[{"sender_blockchain_address": "a", "recipient_blockchain_address": "b",
+			"value": 1, "sender_public_key": "abcd", "signature": "abcd"}]`, RPC_INVALID_PARAMETER},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := postRPC(bcs, `{"jsonrpc": "2.0", "method": "`+tt.method+`", "params": `+tt.params+`, "id": 1}`)
+			var resp RPCResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.Error == nil || resp.Error.Code != tt.code {
+				t.Errorf("error %+v, want %d", resp.Error, tt.code)
+			}
+		})
+	}
+}
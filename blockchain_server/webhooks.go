@@ -0,0 +1,688 @@
+package blockchain_server
+
+import (
+	"blockchain/block"
+	"blockchain/utils"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	WEBHOOK_TIMEOUT = 10 * time.Second
+	// a delivery is given up after this many failed attempts, waiting
+	// WEBHOOK_BACKOFF before the second, doubled each time up to
+	// WEBHOOK_MAX_BACKOFF
+	WEBHOOK_MAX_ATTEMPTS = 10
+	WEBHOOK_BACKOFF      = 5 * time.Second
+	WEBHOOK_MAX_BACKOFF  = time.Hour
+	// how far below the tip delivered transactions are remembered, so a
+	// reorg doesn't notify them twice
+	WEBHOOK_SEEN_DEPTH = 1000
+	// deliveries posted at once, to different hooks. a hook gets one at
+	// a time, so a slow one only holds up its own
+	WEBHOOK_WORKERS = 8
+	MAX_WEBHOOKS    = 100
+	// deliveries kept for all hooks together, the oldest are dropped
+	// past it
+	MAX_WEBHOOK_QUEUE = 10000
+
+	WEBHOOK_EVENT_RECEIVED = "address.received"
+	WEBHOOK_EVENT_TEST     = "test"
+
+	WEBHOOK_SIGNATURE_HEADER = "X-Webhook-Signature"
+	WEBHOOK_ID_HEADER        = "X-Webhook-ID"
+	WEBHOOK_DELIVERY_HEADER  = "X-Webhook-Delivery"
+)
+
+var (
+	ErrPrivateTarget   = errors.New("webhook target is a loopback, link-local or private address")
+	ErrTooManyWebhooks = fmt.Errorf("no more than %d webhooks", MAX_WEBHOOKS)
+)
+
+type Webhook struct {
+	ID        string   `json:"id"`
+	URL       string   `json:"url"`
+	Addresses []string `json:"addresses"`
+	// 0 notifies as soon as the transaction is in the pool
+	MinConfirmations int    `json:"min_confirmations"`
+	Secret           string `json:"secret,omitempty"`
+	Created          int64  `json:"created"`
+}
+
+type WebhookRequest struct {
+	URL              *string  `json:"url" openapi:"required"`
+	Addresses        []string `json:"addresses" openapi:"required"`
+	MinConfirmations *int     `json:"min_confirmations"`
+	Secret           *string  `json:"secret"`
+}
+
+// a hook as the api shows it, without the secret
+type WebhookResponse struct {
+	ID               string   `json:"id"`
+	URL              string   `json:"url"`
+	Addresses        []string `json:"addresses"`
+	MinConfirmations int      `json:"min_confirmations"`
+	Signed           bool     `json:"signed"`
+	Created          int64    `json:"created"`
+	Pending          int      `json:"pending"`
+}
+
+type WebhookTestResponse struct {
+	Message  string `json:"message"`
+	Delivery string `json:"delivery"`
+}
+
+// body posted to a hook. it is signed with the hook's secret, the
+// signature is HMAC-SHA256 of the body in X-Webhook-Signature
+type WebhookPayload struct {
+	Delivery      string             `json:"delivery"`
+	Hook          string             `json:"hook"`
+	Event         string             `json:"event"`
+	Address       string             `json:"address,omitempty"`
+	TxID          string             `json:"txid,omitempty"`
+	Value         float32            `json:"value,omitempty"`
+	Height        int                `json:"height"`
+	Confirmations int                `json:"confirmations"`
+	Transaction   *block.Transaction `json:"transaction,omitempty"`
+	Timestamp     int64              `json:"timestamp"`
+}
+
+type Delivery struct {
+	ID          string          `json:"id"`
+	Hook        string          `json:"hook"`
+	Payload     json.RawMessage `json:"payload"`
+	Attempts    int             `json:"attempts"`
+	NextAttempt int64           `json:"next_attempt"`
+	LastError   string          `json:"last_error,omitempty"`
+}
+
+type webhooksFile struct {
+	Hooks []*Webhook     `json:"hooks"`
+	Queue []*Delivery    `json:"queue"`
+	Seen  map[string]int `json:"seen"`
+}
+
+// registered hooks and the deliveries still owed to them, kept in a file
+// so neither is lost when the node restarts
+type Webhooks struct {
+	// deliver to loopback, link-local and private addresses too. anyone
+	// who can register a hook could otherwise reach services next to
+	// the node
+	AllowPrivate bool
+	// bearer token every webhook endpoint requires, they are all refused
+	// while it is empty
+	Token string
+
+	path  string
+	hooks map[string]*Webhook
+	queue []*Delivery
+	// hook id and txid of every notified transaction, with its height
+	seen map[string]int
+	// hooks with a delivery in flight
+	busy   map[string]bool
+	client *http.Client
+	wake   chan struct{}
+	quit   chan struct{}
+	done   chan struct{}
+	mux    sync.Mutex
+}
+
+func NewWebhooks(path string) *Webhooks {
+	wh := &Webhooks{
+		path:  path,
+		hooks: make(map[string]*Webhook),
+		queue: make([]*Delivery, 0),
+		seen:  make(map[string]int),
+		busy:  make(map[string]bool),
+		wake:  make(chan struct{}, 1),
+		quit:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	// the address is checked once the name is resolved, a hook's host
+	// can't be pointed at a private one after it was registered. no
+	// proxy, it would connect for us unchecked
+	dialer := &net.Dialer{Timeout: WEBHOOK_TIMEOUT, Control: wh.checkDial}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	wh.client = &http.Client{Timeout: WEBHOOK_TIMEOUT, Transport: transport}
+	return wh
+}
+
+func privateIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast()
+}
+
+func (wh *Webhooks) checkDial(network string, address string, c syscall.RawConn) error {
+	if wh.AllowPrivate {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || privateIP(ip) {
+		return fmt.Errorf("%w: %s", ErrPrivateTarget, host)
+	}
+	return nil
+}
+
+// refuses hook urls that name a private address, see AllowPrivate. names
+// that resolve to one are refused when a delivery connects
+func (wh *Webhooks) CheckURL(u *url.URL) error {
+	if wh.AllowPrivate {
+		return nil
+	}
+	host := u.Hostname()
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return fmt.Errorf("%w: %s", ErrPrivateTarget, host)
+	}
+	if ip := net.ParseIP(host); ip != nil && privateIP(ip) {
+		return fmt.Errorf("%w: %s", ErrPrivateTarget, host)
+	}
+	return nil
+}
+
+func (wh *Webhooks) Load() error {
+	if wh.path == "" {
+		return nil
+	}
+	f, err := os.ReadFile(wh.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var wf webhooksFile
+	if err := json.Unmarshal(f, &wf); err != nil {
+		return err
+	}
+	wh.mux.Lock()
+	defer wh.mux.Unlock()
+	for _, h := range wf.Hooks {
+		wh.hooks[h.ID] = h
+	}
+	wh.queue = append(wh.queue, wf.Queue...)
+	wh.trimQueue()
+	for k, height := range wf.Seen {
+		wh.seen[k] = height
+	}
+	return nil
+}
+
+func (wh *Webhooks) Save() error {
+	if wh.path == "" {
+		return nil
+	}
+	wh.mux.Lock()
+	wf := webhooksFile{Hooks: make([]*Webhook, 0, len(wh.hooks)), Queue: wh.queue, Seen: wh.seen}
+	for _, h := range wh.hooks {
+		wf.Hooks = append(wf.Hooks, h)
+	}
+	m, err := json.MarshalIndent(wf, "", "  ")
+	wh.mux.Unlock()
+	if err != nil {
+		return err
+	}
+	tmp := wh.path + ".tmp"
+	if err := os.WriteFile(tmp, m, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, wh.path)
+}
+
+func randomID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func (wh *Webhooks) Add(h *Webhook) error {
+	wh.mux.Lock()
+	if len(wh.hooks) >= MAX_WEBHOOKS {
+		wh.mux.Unlock()
+		return ErrTooManyWebhooks
+	}
+	h.ID = randomID()
+	h.Created = time.Now().Unix()
+	wh.hooks[h.ID] = h
+	wh.mux.Unlock()
+	wh.changed()
+	return nil
+}
+
+// removes the hook and whatever was still queued for it
+func (wh *Webhooks) Delete(id string) bool {
+	wh.mux.Lock()
+	_, ok := wh.hooks[id]
+	if ok {
+		delete(wh.hooks, id)
+		queue := make([]*Delivery, 0, len(wh.queue))
+		for _, d := range wh.queue {
+			if d.Hook != id {
+				queue = append(queue, d)
+			}
+		}
+		wh.queue = queue
+	}
+	wh.mux.Unlock()
+	if ok {
+		wh.changed()
+	}
+	return ok
+}
+
+func (wh *Webhooks) List() []*WebhookResponse {
+	wh.mux.Lock()
+	defer wh.mux.Unlock()
+	pending := make(map[string]int)
+	for _, d := range wh.queue {
+		pending[d.Hook]++
+	}
+	list := make([]*WebhookResponse, 0, len(wh.hooks))
+	for _, h := range wh.hooks {
+		list = append(list, &WebhookResponse{
+			ID:               h.ID,
+			URL:              h.URL,
+			Addresses:        h.Addresses,
+			MinConfirmations: h.MinConfirmations,
+			Signed:           h.Secret != "",
+			Created:          h.Created,
+			Pending:          pending[h.ID],
+		})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Created != list[j].Created {
+			return list[i].Created < list[j].Created
+		}
+		return list[i].ID < list[j].ID
+	})
+	return list
+}
+
+// queues a test delivery, false if there is no such hook
+func (wh *Webhooks) Test(id string) (string, bool) {
+	wh.mux.Lock()
+	h, ok := wh.hooks[id]
+	var d *Delivery
+	if ok {
+		d = wh.enqueue(h, &WebhookPayload{Event: WEBHOOK_EVENT_TEST, Height: -1})
+	}
+	wh.mux.Unlock()
+	if !ok {
+		return "", false
+	}
+	wh.changed()
+	return d.ID, true
+}
+
+// callers hold mux
+func (wh *Webhooks) enqueue(h *Webhook, p *WebhookPayload) *Delivery {
+	p.Delivery = randomID()
+	p.Hook = h.ID
+	p.Timestamp = time.Now().Unix()
+	m, _ := json.Marshal(p)
+	d := &Delivery{ID: p.Delivery, Hook: h.ID, Payload: m, NextAttempt: time.Now().Unix()}
+	wh.queue = append(wh.queue, d)
+	wh.trimQueue()
+	return d
+}
+
+// drops the oldest deliveries past MAX_WEBHOOK_QUEUE, with mux held
+func (wh *Webhooks) trimQueue() {
+	if n := len(wh.queue) - MAX_WEBHOOK_QUEUE; n > 0 {
+		log.Printf("ERROR: webhook queue full, dropping the %d oldest deliveries", n)
+		wh.queue = append([]*Delivery{}, wh.queue[n:]...)
+	}
+}
+
+func (wh *Webhooks) changed() {
+	select {
+	case wh.wake <- struct{}{}:
+	default:
+	}
+}
+
+// hooks into bc. the handlers run under the blockchain's lock, so they
+// read bc.Chain directly. they only queue deliveries, sending happens
+// in Start's goroutine
+func (wh *Webhooks) Watch(bc *block.Blockchain) {
+	bc.OnTransaction(func(t *block.Transaction, tip int) {
+		wh.received([]*block.Transaction{t}, -1, tip, 0)
+	})
+	bc.OnBlock(func(b *block.Block, height int) {
+		wh.mux.Lock()
+		depths := make(map[int]bool)
+		for _, h := range wh.hooks {
+			if h.MinConfirmations > 0 {
+				depths[h.MinConfirmations] = true
+			}
+		}
+		wh.mux.Unlock()
+		// the block that just reached each hook's confirmations
+		for confirmations := range depths {
+			at := height - confirmations + 1
+			if at > 0 && at < len(bc.Chain) {
+				wh.received(bc.Chain[at].Transactions, at, height, confirmations)
+			}
+		}
+	})
+}
+
+// queues a delivery for every hook with exactly these confirmations that
+// watches a recipient in txns
+func (wh *Webhooks) received(txns []*block.Transaction, height int, tip int, confirmations int) {
+	wh.mux.Lock()
+	queued := false
+	for _, h := range wh.hooks {
+		if h.MinConfirmations != confirmations {
+			continue
+		}
+		for _, t := range txns {
+			if !contains(h.Addresses, t.RecipientBlockchainAddress) {
+				continue
+			}
+			txid := fmt.Sprintf("%x", t.Hash())
+			key := h.ID + ":" + txid
+			if _, ok := wh.seen[key]; ok {
+				continue
+			}
+			wh.seen[key] = max(height, tip)
+			wh.enqueue(h, &WebhookPayload{
+				Event:         WEBHOOK_EVENT_RECEIVED,
+				Address:       t.RecipientBlockchainAddress,
+				TxID:          txid,
+				Value:         t.Value,
+				Height:        height,
+				Confirmations: confirmations,
+				Transaction:   t,
+			})
+			queued = true
+		}
+	}
+	for key, h := range wh.seen {
+		if h < tip-WEBHOOK_SEEN_DEPTH {
+			delete(wh.seen, key)
+		}
+	}
+	wh.mux.Unlock()
+	if queued {
+		wh.changed()
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// delivers queued notifications in the background, up to WEBHOOK_WORKERS
+// at once, retrying with backoff
+func (wh *Webhooks) Start() {
+	go func() {
+		defer close(wh.done)
+		var wg sync.WaitGroup
+		defer wg.Wait()
+		workers := make(chan struct{}, WEBHOOK_WORKERS)
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			for _, d := range wh.due() {
+				select {
+				case workers <- struct{}{}:
+				case <-wh.quit:
+					return
+				}
+				wg.Add(1)
+				go func(d *Delivery) {
+					defer wg.Done()
+					wh.deliver(d)
+					<-workers
+				}(d)
+			}
+			select {
+			case <-wh.wake:
+				// something changed, the file follows
+				if err := wh.Save(); err != nil {
+					log.Printf("ERROR: saving webhooks to %s: %v", wh.path, err)
+				}
+			case <-ticker.C:
+			case <-wh.quit:
+				return
+			}
+		}
+	}()
+}
+
+// stops delivering after the requests in flight and saves what is still
+// queued, it goes out when the node runs again
+func (wh *Webhooks) Stop(ctx context.Context) error {
+	close(wh.quit)
+	var err error
+	select {
+	case <-wh.done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	return errors.Join(err, wh.Save())
+}
+
+// the first due delivery of every hook that has none in flight, the
+// hooks are busy until deliver is done with them
+func (wh *Webhooks) due() []*Delivery {
+	wh.mux.Lock()
+	defer wh.mux.Unlock()
+	now := time.Now().Unix()
+	due := make([]*Delivery, 0)
+	for _, d := range wh.queue {
+		if d.NextAttempt <= now && !wh.busy[d.Hook] {
+			wh.busy[d.Hook] = true
+			due = append(due, d)
+		}
+	}
+	return due
+}
+
+func (wh *Webhooks) deliver(d *Delivery) {
+	wh.mux.Lock()
+	h, ok := wh.hooks[d.Hook]
+	wh.mux.Unlock()
+	var err error
+	if ok {
+		err = wh.post(h, d)
+	}
+
+	wh.mux.Lock()
+	defer wh.mux.Unlock()
+	delete(wh.busy, d.Hook)
+	// the hook's next delivery may be due already
+	wh.changed()
+	if !ok {
+		return
+	}
+	d.Attempts++
+	if err == nil || d.Attempts >= WEBHOOK_MAX_ATTEMPTS {
+		if err != nil {
+			log.Printf("ERROR: webhook %s: giving up on delivery %s after %d attempts: %v", h.ID, d.ID, d.Attempts, err)
+		}
+		queue := make([]*Delivery, 0, len(wh.queue))
+		for _, q := range wh.queue {
+			if q != d {
+				queue = append(queue, q)
+			}
+		}
+		wh.queue = queue
+		wh.changed()
+		return
+	}
+	backoff := WEBHOOK_BACKOFF << (d.Attempts - 1)
+	if backoff > WEBHOOK_MAX_BACKOFF {
+		backoff = WEBHOOK_MAX_BACKOFF
+	}
+	d.NextAttempt = time.Now().Add(backoff).Unix()
+	d.LastError = err.Error()
+	log.Printf("ERROR: webhook %s: delivery %s failed, retrying in %v: %v", h.ID, d.ID, backoff, err)
+	wh.changed()
+}
+
+func (wh *Webhooks) post(h *Webhook, d *Delivery) error {
+	req, err := http.NewRequest(http.MethodPost, h.URL, bytes.NewReader(d.Payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WEBHOOK_ID_HEADER, h.ID)
+	req.Header.Set(WEBHOOK_DELIVERY_HEADER, d.ID)
+	if h.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(h.Secret))
+		mac.Write(d.Payload)
+		req.Header.Set(WEBHOOK_SIGNATURE_HEADER, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	resp, err := wh.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// every webhook endpoint wants the configured token as a bearer token,
+// a hook makes the node post wherever it points
+func (bcs *BlockchainServer) webhookAuth(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		token := bcs.webhooks.Token
+		if token == "" {
+			utils.JsonError(w, req, http.StatusForbidden, utils.ERR_FORBIDDEN,
+				"webhooks are disabled, set webhooks.token to manage them", nil)
+			return
+		}
+		given, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="webhooks"`)
+			utils.JsonError(w, req, http.StatusUnauthorized, utils.ERR_UNAUTHORIZED, "missing or wrong webhook token", nil)
+			return
+		}
+		h(w, req)
+	}
+}
+
+// GET lists the hooks, POST registers one, DELETE ?id= removes one
+func (bcs *BlockchainServer) WebhooksHandler(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		m, _ := json.Marshal(bcs.webhooks.List())
+		w.Header().Add("Content-Type", "application/json")
+		io.WriteString(w, string(m))
+
+	case http.MethodPost:
+		var r WebhookRequest
+		if err := json.NewDecoder(req.Body).Decode(&r); err != nil {
+			utils.JsonError(w, req, http.StatusBadRequest, utils.ERR_INVALID_JSON, err.Error(), nil)
+			return
+		}
+		if r.URL == nil || len(r.Addresses) == 0 {
+			utils.JsonError(w, req, http.StatusBadRequest, utils.ERR_MISSING_FIELDS, "url and addresses required",
+				map[string][]string{"fields": {"url", "addresses"}})
+			return
+		}
+		u, err := url.Parse(*r.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			utils.JsonError(w, req, http.StatusBadRequest, utils.ERR_INVALID_FIELD,
+				"url must be an http or https url", map[string]string{"field": "url"})
+			return
+		}
+		if err := bcs.webhooks.CheckURL(u); err != nil {
+			utils.JsonError(w, req, http.StatusBadRequest, utils.ERR_INVALID_FIELD,
+				err.Error(), map[string]string{"field": "url"})
+			return
+		}
+		h := &Webhook{URL: *r.URL, Addresses: r.Addresses, MinConfirmations: 1}
+		if r.MinConfirmations != nil {
+			if *r.MinConfirmations < 0 || *r.MinConfirmations > WEBHOOK_SEEN_DEPTH {
+				utils.JsonError(w, req, http.StatusBadRequest, utils.ERR_INVALID_FIELD,
+					fmt.Sprintf("min_confirmations must be between 0 and %d", WEBHOOK_SEEN_DEPTH),
+					map[string]string{"field": "min_confirmations"})
+				return
+			}
+			h.MinConfirmations = *r.MinConfirmations
+		}
+		if r.Secret != nil {
+			h.Secret = *r.Secret
+		}
+		if err := bcs.webhooks.Add(h); err != nil {
+			utils.JsonError(w, req, http.StatusConflict, utils.ERR_CONFLICT, err.Error(), nil)
+			return
+		}
+		m, _ := json.Marshal(&WebhookResponse{
+			ID:               h.ID,
+			URL:              h.URL,
+			Addresses:        h.Addresses,
+			MinConfirmations: h.MinConfirmations,
+			Signed:           h.Secret != "",
+			Created:          h.Created,
+		})
+		w.Header().Add("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		io.WriteString(w, string(m))
+
+	case http.MethodDelete:
+		id := req.URL.Query().Get("id")
+		if !bcs.webhooks.Delete(id) {
+			utils.JsonError(w, req, http.StatusNotFound, utils.ERR_NOT_FOUND, "no webhook "+id, nil)
+			return
+		}
+		w.Header().Add("Content-Type", "application/json")
+		io.WriteString(w, string(utils.JsonStatus("success")))
+
+	default:
+		utils.MethodNotAllowed(w, req, http.MethodGet, http.MethodPost, http.MethodDelete)
+	}
+}
+
+// queues a test delivery to the hook ?id=
+func (bcs *BlockchainServer) TestWebhook(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodPost:
+		id := req.URL.Query().Get("id")
+		delivery, ok := bcs.webhooks.Test(id)
+		if !ok {
+			utils.JsonError(w, req, http.StatusNotFound, utils.ERR_NOT_FOUND, "no webhook "+id, nil)
+			return
+		}
+		m, _ := json.Marshal(&WebhookTestResponse{Message: "queued", Delivery: delivery})
+		w.Header().Add("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		io.WriteString(w, string(m))
+	default:
+		utils.MethodNotAllowed(w, req, http.MethodPost)
+	}
+}
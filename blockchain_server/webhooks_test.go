@@ -0,0 +1,67 @@
+package blockchain_server
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWebhookAuth(t *testing.T) {
+	bcs := rpcTestServer()
+	bcs.UseWebhooks(NewWebhooks(""))
+	handler := bcs.webhookAuth(bcs.WebhooksHandler)
+	register := func(auth string) int {
+		req := httptest.NewRequest(http.MethodPost, "/webhooks",
+			strings.NewReader(`{"url": "https://example.com/hook", "addresses": ["a"]}`))
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w.Code
+	}
+
+	if code := register("Bearer "); code != http.StatusForbidden {
+		t.Errorf("status %d without a configured token, want %d", code, http.StatusForbidden)
+	}
+	bcs.webhooks.Token = "s3cret"
+	for _, auth := range []string{"", "s3cret", "Bearer wrong", "Basic s3cret"} {
+		if code := register(auth); code != http.StatusUnauthorized {
+			t.Errorf("status %d with authorization %q, want %d", code, auth, http.StatusUnauthorized)
+		}
+	}
+	if code := register("Bearer s3cret"); code != http.StatusCreated {
+		t.Errorf("status %d with the token, want %d", code, http.StatusCreated)
+	}
+	if n := len(bcs.webhooks.List()); n != 1 {
+		t.Errorf("%d hooks registered, want 1", n)
+	}
+}
+
+func TestWebhookLimits(t *testing.T) {
+	wh := NewWebhooks("")
+	for i := 0; i < MAX_WEBHOOKS; i++ {
+		if err := wh.Add(&Webhook{URL: "https://example.com/hook", Addresses: []string{"a"}}); err != nil {
+			t.Fatalf("hook %d: %v", i+1, err)
+		}
+	}
+	if err := wh.Add(&Webhook{URL: "https://example.com/hook"}); !errors.Is(err, ErrTooManyWebhooks) {
+		t.Errorf("hook past MAX_WEBHOOKS: %v", err)
+	}
+
+	id := wh.List()[0].ID
+	first, _ := wh.Test(id)
+	for i := 0; i < MAX_WEBHOOK_QUEUE; i++ {
+		wh.Test(id)
+	}
+	wh.mux.Lock()
+	defer wh.mux.Unlock()
+	if len(wh.queue) != MAX_WEBHOOK_QUEUE {
+		t.Errorf("%d deliveries queued, want %d", len(wh.queue), MAX_WEBHOOK_QUEUE)
+	}
+	if wh.queue[0].ID == first {
+		t.Error("the oldest delivery was kept")
+	}
+}
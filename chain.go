@@ -0,0 +1,259 @@
+package main
+
+import (
+	"blockchain/block"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+var chainCommands = map[string]*command{
+	"show":     {args: "[-height n | -hash hash]", short: "the node's tip, or one block", run: chainShow},
+	"validate": {args: "[-file file]", short: "check every block of the node's chain or an export", run: chainValidate},
+	"export":   {args: "-o file", short: "write the node's chain to a file, a line per block", run: chainExport},
+	"import":   {args: "-i file", short: "submit the blocks of an export the node doesn't have", run: chainImport},
+	"snapshot": {args: "[-height n] -o file | -verify file", short: "write the node's state at a height, or check one", run: chainSnapshot},
+}
+
+func chainShow(env *Env, args []string) error {
+	fs := env.flags("chain", "show", "")
+	height := fs.Int("height", -1, "Height of the block to show")
+	hash := fs.String("hash", "", "Hash of the block to show, wins over -height")
+	fs.Parse(args)
+
+	var b *block.Block
+	var err error
+	switch {
+	case *hash != "":
+		h, herr := hex.DecodeString(*hash)
+		if herr != nil || len(h) != 32 {
+			return errors.New("-hash must be 64 hex characters")
+		}
+		b, err = env.Client.GetBlockByHash(env.Ctx, [32]byte(h))
+	case *height >= 0:
+		b, err = env.Client.GetBlock(env.Ctx, *height)
+	default:
+		status, err := env.Client.Status(env.Ctx)
+		if err != nil {
+			return err
+		}
+		tip, err := env.Client.GetBlock(env.Ctx, status.Height)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("chain_id=%s height=%d genesis=%s tip=%x", status.ChainID, status.Height, status.GenesisHash, tip.Hash())
+		if status.PruneHeight > 0 {
+			fmt.Printf(" prune_height=%d", status.PruneHeight)
+		}
+		fmt.Println()
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	printJSON(b)
+	return nil
+}
+
+// blocks of an export when file is given, of the node otherwise
+func loadChain(env *Env, file string) (string, []*block.Block, error) {
+	if file == "" {
+		chain, err := env.Client.GetChain(env.Ctx)
+		if err != nil {
+			return "", nil, err
+		}
+		return chain.ChainID, chain.Blocks, nil
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return "", nil, err
+	}
+	defer f.Close()
+	er, err := block.NewExportReader(f)
+	if err != nil {
+		return "", nil, fmt.Errorf("%s: %w", file, err)
+	}
+	blocks := make([]*block.Block, 0, er.Header.Height+1)
+	for {
+		b, err := er.Next()
+		if err == io.EOF {
+			return er.Header.ChainID, blocks, nil
+		}
+		if err != nil {
+			return "", nil, fmt.Errorf("%s: %w", file, err)
+		}
+		blocks = append(blocks, b)
+	}
+}
+
+func loadGenesis(network string, file string) (*block.Genesis, error) {
+	if file != "" {
+		return block.LoadGenesis(file)
+	}
+	genesis, ok := block.GenesisForNetwork(network)
+	if !ok {
+		return nil, fmt.Errorf("unknown network %q", network)
+	}
+	return genesis, nil
+}
+
+func chainValidate(env *Env, args []string) error {
+	fs := env.flags("chain", "validate", "")
+	file := fs.String("file", "", "Exported chain to check instead of the node's")
+	network := fs.String("network", "mainnet", "Built in network the chain belongs to (mainnet, testnet)")
+	genesisFile := fs.String("genesis", "", "Genesis spec file, overrides -network")
+	fs.Parse(args)
+
+	genesis, err := loadGenesis(*network, *genesisFile)
+	if err != nil {
+		return err
+	}
+	_, blocks, err := loadChain(env, *file)
+	if err != nil {
+		return err
+	}
+	for h, b := range blocks {
+		if b.Pruned() {
+			return fmt.Errorf("block %d has no body, the node was started from a snapshot", h)
+		}
+	}
+	bc := block.NewBlockChain("", 0, genesis)
+	if bc.ValidChain(blocks) {
+		fmt.Printf("valid, %d blocks, tip %x\n", len(blocks), blocks[len(blocks)-1].Hash())
+		return nil
+	}
+	if len(blocks) == 0 || blocks[0].Hash() != genesis.Hash() {
+		return fmt.Errorf("invalid, the chain doesn't start with the %s genesis block", genesis.ChainID)
+	}
+	// a chain stays invalid once a block is, the first bad one is where
+	// the prefixes stop being valid
+	bad := sort.Search(len(blocks), func(n int) bool {
+		return n > 0 && !bc.ValidChain(blocks[:n+1])
+	})
+	return fmt.Errorf("invalid, block %d %x is the first bad one", bad, blocks[bad].Hash())
+}
+
+// the export is checked before it replaces an older file
+func chainExport(env *Env, args []string) error {
+	fs := env.flags("chain", "export", "")
+	out := fs.String("o", "", "File to write the chain to")
+	fs.Parse(args)
+	if *out == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+	tmp := *out + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	err = env.Client.ExportChain(env.Ctx, f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	chainID, blocks, err := loadChain(env, tmp)
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, *out); err != nil {
+		return err
+	}
+	fmt.Printf("exported %d blocks of %s to %s\n", len(blocks), chainID, *out)
+	return nil
+}
+
+// the node takes blocks one at a time on top of its tip, so the export
+// must share the node's chain up to there
+func chainImport(env *Env, args []string) error {
+	fs := env.flags("chain", "import", "")
+	in := fs.String("i", "", "File to read the chain from")
+	fs.Parse(args)
+	if *in == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+	chainID, blocks, err := loadChain(env, *in)
+	if err != nil {
+		return err
+	}
+	status, err := env.Client.Status(env.Ctx)
+	if err != nil {
+		return err
+	}
+	if chainID != status.ChainID {
+		return fmt.Errorf("the file holds %s, the node runs %s", chainID, status.ChainID)
+	}
+	if status.Height < len(blocks) {
+		tip, err := env.Client.GetBlock(env.Ctx, status.Height)
+		if err != nil {
+			return err
+		}
+		if tip.Hash() != blocks[status.Height].Hash() {
+			return fmt.Errorf("the file leaves the node's chain at or below height %d", status.Height)
+		}
+	}
+	imported := 0
+	for height := status.Height + 1; height < len(blocks); height++ {
+		if _, err := env.Client.SubmitBlock(env.Ctx, blocks[height]); err != nil {
+			return fmt.Errorf("block %d: %w (%d imported)", height, err, imported)
+		}
+		imported++
+	}
+	fmt.Printf("imported %d blocks, the node is at height %d\n", imported, max(status.Height, len(blocks)-1))
+	return nil
+}
+
+// the commitment of a snapshot only says it wasn't damaged, -verify
+// checks it against the state of the node, one that is trusted
+func chainSnapshot(env *Env, args []string) error {
+	fs := env.flags("chain", "snapshot", "")
+	height := fs.Int("height", -1, "Height of the snapshot, the node's tip when not given")
+	out := fs.String("o", "", "File to write the snapshot to")
+	verify := fs.String("verify", "", "Snapshot file to compare with the node's state at its height")
+	fs.Parse(args)
+
+	if *verify != "" {
+		s, err := block.LoadSnapshot(*verify)
+		if err != nil {
+			return err
+		}
+		ours, err := env.Client.GetSnapshot(env.Ctx, s.Height)
+		if err != nil {
+			return err
+		}
+		if s.BlockHash != ours.BlockHash {
+			return fmt.Errorf("the snapshot is of block %s, the node has %s at height %d", s.BlockHash, ours.BlockHash, s.Height)
+		}
+		if s.Commitment != ours.Commitment {
+			return fmt.Errorf("the snapshot's commitment %s isn't the node's %s", s.Commitment, ours.Commitment)
+		}
+		fmt.Printf("matches the node at height %d, commitment %s\n", s.Height, s.Commitment)
+		return nil
+	}
+	if *out == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+	s, err := env.Client.GetSnapshot(env.Ctx, *height)
+	if err != nil {
+		return err
+	}
+	m, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(*out, m, 0644); err != nil {
+		return err
+	}
+	fmt.Printf("snapshot of %s at height %d, %d balances, commitment %s\n", s.ChainID, s.Height, len(s.Balances), s.Commitment)
+	return nil
+}
@@ -0,0 +1,300 @@
+package client
+
+import (
+	"blockchain/block"
+	"blockchain/p2p"
+	"blockchain/utils"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const DEFAULT_TIMEOUT = 10 * time.Second
+
+// typed access to the http api of a blockchain_server node
+type Client struct {
+	BaseURL    string
+	HTTPClient *http.Client
+}
+
+// a zero timeout uses DEFAULT_TIMEOUT, per request deadlines come from
+// the context passed to each method
+func NewClient(baseURL string, timeout time.Duration) *Client {
+	if timeout == 0 {
+		timeout = DEFAULT_TIMEOUT
+	}
+	return &Client{
+		BaseURL:    strings.TrimRight(baseURL, "/"),
+		HTTPClient: &http.Client{Timeout: timeout},
+	}
+}
+
+// the whole chain and transaction pool, as served on /
+type Chain struct {
+	TransactionPool   []*block.Transaction `json:"TransactionPool"`
+	Blocks            []*block.Block       `json:"Chain"`
+	BlockchainAddress string               `json:"BlockchainAddress"`
+	ChainID           string               `json:"ChainID"`
+}
+
+func (c *Client) SubmitTransaction(ctx context.Context, t *block.TransactionRequest) error {
+	return c.do(ctx, http.MethodPost, "/transactions", nil, t, http.StatusCreated, nil)
+}
+
+func (c *Client) GetBalance(ctx context.Context, blockchainAddress string) (*block.AmountResponse, error) {
+	var ar block.AmountResponse
+	q := url.Values{"blockchain_address": {blockchainAddress}}
+	if err := c.do(ctx, http.MethodGet, "/amount", q, nil, http.StatusOK, &ar); err != nil {
+		return nil, err
+	}
+	return &ar, nil
+}
+
+func (c *Client) GetBlock(ctx context.Context, height int) (*block.Block, error) {
+	var b block.Block
+	q := url.Values{"height": {strconv.Itoa(height)}}
+	if err := c.do(ctx, http.MethodGet, "/block", q, nil, http.StatusOK, &b); err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+func (c *Client) GetBlockByHash(ctx context.Context, hash [32]byte) (*block.Block, error) {
+	var b block.Block
+	q := url.Values{"hash": {fmt.Sprintf("%x", hash)}}
+	if err := c.do(ctx, http.MethodGet, "/block", q, nil, http.StatusOK, &b); err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+func (c *Client) GetChain(ctx context.Context) (*Chain, error) {
+	var chain Chain
+	if err := c.do(ctx, http.MethodGet, "/", nil, nil, http.StatusOK, &chain); err != nil {
+		return nil, err
+	}
+	return &chain, nil
+}
+
+// mines one block, fails if the pool is empty
+func (c *Client) Mine(ctx context.Context) error {
+	return c.do(ctx, http.MethodPost, "/mine", nil, nil, http.StatusOK, nil)
+}
+
+func (c *Client) StartMining(ctx context.Context) (*block.MiningStatus, error) {
+	var ms block.MiningStatus
+	if err := c.do(ctx, http.MethodPost, "/mine/start", nil, nil, http.StatusOK, &ms); err != nil {
+		return nil, err
+	}
+	return &ms, nil
+}
+
+func (c *Client) StopMining(ctx context.Context) (*block.MiningStatus, error) {
+	var ms block.MiningStatus
+	if err := c.do(ctx, http.MethodPost, "/mine/stop", nil, nil, http.StatusOK, &ms); err != nil {
+		return nil, err
+	}
+	return &ms, nil
+}
+
+func (c *Client) MiningStatus(ctx context.Context) (*block.MiningStatus, error) {
+	var ms block.MiningStatus
+	if err := c.do(ctx, http.MethodGet, "/mine/status", nil, nil, http.StatusOK, &ms); err != nil {
+		return nil, err
+	}
+	return &ms, nil
+}
+
+// changes the mining settings, s is sent as a whole
+func (c *Client) SetMiningSettings(ctx context.Context, s *block.MiningSettings) (*block.MiningSettings, error) {
+	var out block.MiningSettings
+	if err := c.do(ctx, http.MethodPost, "/mine/settings", nil, s, http.StatusOK, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// work for an external miner, an empty address has the coinbase pay the node
+func (c *Client) GetBlockTemplate(ctx context.Context, address string) (*block.BlockTemplate, error) {
+	var t block.BlockTemplate
+	var q url.Values
+	if address != "" {
+		q = url.Values{"address": {address}}
+	}
+	if err := c.do(ctx, http.MethodGet, "/mine/template", q, nil, http.StatusOK, &t); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// submits a block mined from a template, its height on the chain
+func (c *Client) SubmitBlock(ctx context.Context, b *block.Block) (int, error) {
+	var r struct {
+		Height int `json:"height"`
+	}
+	if err := c.do(ctx, http.MethodPost, "/mine/submit", nil, b, http.StatusOK, &r); err != nil {
+		return 0, err
+	}
+	return r.Height, nil
+}
+
+func (c *Client) Status(ctx context.Context) (*block.NetworkResponse, error) {
+	var n block.NetworkResponse
+	if err := c.do(ctx, http.MethodGet, "/network", nil, nil, http.StatusOK, &n); err != nil {
+		return nil, err
+	}
+	return &n, nil
+}
+
+// up to a page of headers starting at height from, empty past the tip
+func (c *Client) GetHeaders(ctx context.Context, from int) ([]*block.BlockHeader, error) {
+	var hr struct {
+		Headers []*block.BlockHeader `json:"headers"`
+	}
+	q := url.Values{"from": {strconv.Itoa(from)}}
+	if err := c.do(ctx, http.MethodGet, "/headers", q, nil, http.StatusOK, &hr); err != nil {
+		return nil, err
+	}
+	return hr.Headers, nil
+}
+
+// confirmed transactions of an address with their merkle proofs
+func (c *Client) GetProvenTransactions(ctx context.Context, blockchainAddress string) ([]*block.ProvenTransaction, error) {
+	var tr struct {
+		Transactions []*block.ProvenTransaction `json:"transactions"`
+	}
+	q := url.Values{"blockchain_address": {blockchainAddress}}
+	if err := c.do(ctx, http.MethodGet, "/address/transactions", q, nil, http.StatusOK, &tr); err != nil {
+		return nil, err
+	}
+	return tr.Transactions, nil
+}
+
+// connected peers and every address the node knows, as served on /peers
+type Peers struct {
+	Connected []*p2p.PeerInfo    `json:"connected"`
+	Known     []p2p.KnownAddress `json:"known"`
+}
+
+func (c *Client) GetPeers(ctx context.Context) (*Peers, error) {
+	var peers Peers
+	if err := c.do(ctx, http.MethodGet, "/peers", nil, nil, http.StatusOK, &peers); err != nil {
+		return nil, err
+	}
+	return &peers, nil
+}
+
+// Error is why the node could not connect, it keeps the address either way
+type PeerAdded struct {
+	Addr      string `json:"addr"`
+	Connected bool   `json:"connected"`
+	Error     string `json:"error"`
+}
+
+func (c *Client) AddPeer(ctx context.Context, addr string) (*PeerAdded, error) {
+	var added PeerAdded
+	body := map[string]string{"addr": addr}
+	if err := c.do(ctx, http.MethodPost, "/peers", nil, body, http.StatusCreated, &added); err != nil {
+		return nil, err
+	}
+	return &added, nil
+}
+
+// the node's snapshot at height, at its tip for a negative height
+func (c *Client) GetSnapshot(ctx context.Context, height int) (*block.Snapshot, error) {
+	var q url.Values
+	if height >= 0 {
+		q = url.Values{"height": {strconv.Itoa(height)}}
+	}
+	var s block.Snapshot
+	if err := c.do(ctx, http.MethodGet, "/snapshot", q, nil, http.StatusOK, &s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// copies the node's chain export to w, block.NewExportReader reads it.
+// there is no timeout but ctx's, a long chain takes a while
+func (c *Client) ExportChain(ctx context.Context, w io.Writer) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.BaseURL+"/chain/export", nil)
+	if err != nil {
+		return err
+	}
+	if id := utils.RequestIDFromContext(ctx); id != "" {
+		req.Header.Set(utils.REQUEST_ID_HEADER, id)
+	}
+	hc := *c.HTTPClient
+	hc.Timeout = 0
+	resp, err := hc.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		m, _ := io.ReadAll(resp.Body)
+		ae := &APIError{Method: http.MethodGet, Path: "/chain/export", StatusCode: resp.StatusCode}
+		json.Unmarshal(m, &ae.ErrorResponse)
+		return ae
+	}
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		return fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+	return nil
+}
+
+// sends body as JSON and decodes the answer into out, anything but the
+// expected status is an APIError
+func (c *Client) do(ctx context.Context, method string, path string, query url.Values,
+	body interface{}, expected int, out interface{}) error {
+	endpoint := c.BaseURL + path
+	if len(query) > 0 {
+		endpoint += "?" + query.Encode()
+	}
+	var r io.Reader
+	if body != nil {
+		m, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(m)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, r)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	// the node logs under the same id as the caller
+	if id := utils.RequestIDFromContext(ctx); id != "" {
+		req.Header.Set(utils.REQUEST_ID_HEADER, id)
+	}
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+	defer resp.Body.Close()
+	m, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+	if resp.StatusCode != expected {
+		ae := &APIError{Method: method, Path: path, StatusCode: resp.StatusCode}
+		json.Unmarshal(m, &ae.ErrorResponse)
+		return ae
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.Unmarshal(m, out); err != nil {
+		return fmt.Errorf("decoding %s: %w", path, err)
+	}
+	return nil
+}
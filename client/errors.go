@@ -0,0 +1,55 @@
+package client
+
+import (
+	"blockchain/utils"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// the node could not be reached or its answer could not be read
+var ErrUnavailable = errors.New("node unavailable")
+
+// the node answered, but with an error status. Code, Message, Details
+// and RequestID come from the node's error body
+type APIError struct {
+	Method     string
+	Path       string
+	StatusCode int
+	utils.ErrorResponse
+}
+
+func (e *APIError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("%s %s: %d %s", e.Method, e.Path, e.StatusCode, http.StatusText(e.StatusCode))
+	}
+	return fmt.Sprintf("%s %s: %d %s: %s", e.Method, e.Path, e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+// true if err is an APIError with the given error code
+func IsCode(err error, code string) bool {
+	var ae *APIError
+	return errors.As(err, &ae) && ae.Code == code
+}
+
+// true if err is an APIError with the given status
+func IsStatus(err error, status int) bool {
+	var ae *APIError
+	return errors.As(err, &ae) && ae.StatusCode == status
+}
+
+func IsNotFound(err error) bool {
+	return IsStatus(err, http.StatusNotFound)
+}
+
+// true if another try, maybe at another node, could succeed: the node
+// couldn't be reached, took too long or failed on its side. the other
+// errors are the request's fault and come back the same every time
+func Retriable(err error) bool {
+	var ae *APIError
+	if errors.As(err, &ae) {
+		return ae.StatusCode >= http.StatusInternalServerError
+	}
+	return errors.Is(err, ErrUnavailable) || errors.Is(err, context.DeadlineExceeded)
+}
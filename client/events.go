@@ -0,0 +1,107 @@
+package client
+
+import (
+	"blockchain/utils"
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// one server sent event from a node's /events stream
+type Event struct {
+	ID   string
+	Type string
+	Data json.RawMessage
+}
+
+type EventStream struct {
+	body    io.ReadCloser
+	scanner *bufio.Scanner
+}
+
+// opens the node's event stream. empty types and addresses mean all of
+// them. the stream has no timeout, it ends with ctx or the connection
+func (c *Client) Events(ctx context.Context, types []string, addresses []string, lastID string) (*EventStream, error) {
+	q := url.Values{}
+	if len(types) > 0 {
+		q.Set("types", strings.Join(types, ","))
+	}
+	if len(addresses) > 0 {
+		q.Set("address", strings.Join(addresses, ","))
+	}
+	endpoint := c.BaseURL + "/events"
+	if len(q) > 0 {
+		endpoint += "?" + q.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	if lastID != "" {
+		req.Header.Set("Last-Event-ID", lastID)
+	}
+	if id := utils.RequestIDFromContext(ctx); id != "" {
+		req.Header.Set(utils.REQUEST_ID_HEADER, id)
+	}
+	// HTTPClient's timeout would cut the stream off
+	hc := *c.HTTPClient
+	hc.Timeout = 0
+	resp, err := hc.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		m, _ := io.ReadAll(resp.Body)
+		ae := &APIError{Method: http.MethodGet, Path: "/events", StatusCode: resp.StatusCode}
+		json.Unmarshal(m, &ae.ErrorResponse)
+		return nil, ae
+	}
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), utils.MAX_BODY_SIZE)
+	return &EventStream{body: resp.Body, scanner: scanner}, nil
+}
+
+// blocks until the next event, io.EOF once the node ends the stream
+func (s *EventStream) Next() (*Event, error) {
+	e := &Event{}
+	var data []string
+	for s.scanner.Scan() {
+		line := s.scanner.Text()
+		if line == "" {
+			if len(data) == 0 {
+				// retry hints and keepalives
+				continue
+			}
+			e.Data = json.RawMessage(strings.Join(data, "\n"))
+			return e, nil
+		}
+		if strings.HasPrefix(line, ":") {
+			continue
+		}
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "id":
+			e.ID = value
+		case "event":
+			e.Type = value
+		case "data":
+			data = append(data, value)
+		}
+	}
+	if err := s.scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+	return nil, io.EOF
+}
+
+func (s *EventStream) Close() error {
+	return s.body.Close()
+}
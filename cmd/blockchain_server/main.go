@@ -0,0 +1,12 @@
+package main
+
+import (
+	"blockchain/blockchain_server"
+	"os"
+)
+
+// a node on its own, as "blockchain node run" runs it, keeping its files
+// in the working directory
+func main() {
+	blockchain_server.Main(os.Args[0], os.Args[1:], ".")
+}
@@ -0,0 +1,135 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"reflect"
+	"strings"
+)
+
+// settings come from, each overriding the one before: the defaults, a
+// config file, environment variables and command line flags. the
+// variable of a key is the prefix, then the section and key in upper
+// case, like BLOCKCHAIN_API_PORT for port in [api]
+
+// reads the file at path, if not empty, and then the environment into
+// the sections of the struct v points to
+func Load(v interface{}, path string, envPrefix string) error {
+	if path != "" {
+		f, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		entries, err := parseTOML(path, string(f))
+		if err != nil {
+			return err
+		}
+		if err := decode(entries, path, v); err != nil {
+			return err
+		}
+	}
+	return applyEnv(v, envPrefix)
+}
+
+func applyEnv(v interface{}, prefix string) error {
+	root := reflect.ValueOf(v).Elem()
+	for i := 0; i < root.NumField(); i++ {
+		section := root.Field(i)
+		sectionName := root.Type().Field(i).Tag.Get("toml")
+		for j := 0; j < section.NumField(); j++ {
+			key := section.Type().Field(j).Tag.Get("toml")
+			name := EnvName(prefix, sectionName, key)
+			s, ok := os.LookupEnv(name)
+			if !ok {
+				continue
+			}
+			if err := setString(section.Field(j), s); err != nil {
+				return fmt.Errorf("%s: %s.%s: %v", name, sectionName, key, err)
+			}
+		}
+	}
+	return nil
+}
+
+func EnvName(prefix string, section string, key string) string {
+	return prefix + strings.ToUpper(strings.ReplaceAll(section+"_"+key, "-", "_"))
+}
+
+// the value of flag name in args, before they are parsed, so the config
+// file can be read first and flags still override it
+func FindFlag(args []string, name string) string {
+	for i, a := range args {
+		if a == "--" {
+			break
+		}
+		a = strings.TrimPrefix(strings.TrimPrefix(a, "-"), "-")
+		if a == name && i+1 < len(args) {
+			return args[i+1]
+		}
+		if strings.HasPrefix(a, name+"=") {
+			return strings.TrimPrefix(a, name+"=")
+		}
+	}
+	return ""
+}
+
+// a comma separated flag filling a list of the config
+type List []string
+
+func (l *List) String() string {
+	if l == nil {
+		return ""
+	}
+	return strings.Join(*l, ",")
+}
+
+func (l *List) Set(s string) error {
+	*l = make(List, 0)
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*l = append(*l, item)
+		}
+	}
+	return nil
+}
+
+// where the http api is served, over https when both TLS files are set
+type API struct {
+	Host    string `toml:"host"`
+	Port    uint   `toml:"port"`
+	TLSCert string `toml:"tls_cert"`
+	TLSKey  string `toml:"tls_key"`
+}
+
+func (a *API) Addr() string {
+	return net.JoinHostPort(a.Host, fmt.Sprint(a.Port))
+}
+
+func (a *API) TLS() bool {
+	return a.TLSCert != ""
+}
+
+func (a *API) validate() error {
+	var errs []error
+	if a.Port < 1 || a.Port > 65535 {
+		errs = append(errs, fmt.Errorf("api.port %d out of range 1-65535", a.Port))
+	}
+	if (a.TLSCert == "") != (a.TLSKey == "") {
+		errs = append(errs, errors.New("api.tls_cert and api.tls_key must be set together"))
+	}
+	errs = append(errs, fileExists("api.tls_cert", a.TLSCert), fileExists("api.tls_key", a.TLSKey))
+	return errors.Join(errs...)
+}
+
+// nil if path is empty or a readable file
+func fileExists(key string, path string) error {
+	if path == "" {
+		return nil
+	}
+	if _, err := os.Stat(path); err != nil {
+		return fmt.Errorf("%s: %v", key, err)
+	}
+	return nil
+}
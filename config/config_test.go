@@ -0,0 +1,77 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestApplyEnv(t *testing.T) {
+	on, off := true, false
+	tests := []struct {
+		name string
+		env  map[string]string
+		want testSection
+		err  string
+	}{
+		{"nothing set", nil, testSection{Host: "default", Port: 1}, ""},
+		{"all kinds", map[string]string{
+			"TEST_SECTION_HOST":  "h",
+			"TEST_SECTION_PORT":  "80",
+			"TEST_SECTION_COUNT": "-1",
+			"TEST_SECTION_RATE":  "0.5",
+			"TEST_SECTION_ON":    "true",
+			"TEST_SECTION_SEEDS": " a:1, ,b:2 ",
+		}, testSection{Host: "h", Port: 80, Count: -1, Rate: 0.5, On: &on, Seeds: []string{"a:1", "b:2"}}, ""},
+		{"empty overrides", map[string]string{"TEST_SECTION_HOST": "", "TEST_SECTION_ON": "false"},
+			testSection{Port: 1, On: &off}, ""},
+		{"bad integer", map[string]string{"TEST_SECTION_COUNT": "many"}, testSection{},
+			"TEST_SECTION_COUNT: section.count: expected an integer"},
+		{"port out of range", map[string]string{"TEST_SECTION_PORT": "70000"}, testSection{}, "expected a positive integer"},
+		{"bad boolean", map[string]string{"TEST_SECTION_ON": "yes please"}, testSection{}, "expected true or false"},
+		{"bad number", map[string]string{"TEST_SECTION_RATE": "fast"}, testSection{}, "expected a number"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			c := testConfig{testSection{Host: "default", Port: 1}}
+			err := applyEnv(&c, "TEST_")
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Errorf("error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(c.Section, tt.want) {
+				t.Errorf("config %+v, want %+v", c.Section, tt.want)
+			}
+		})
+	}
+}
+
+func TestFindFlag(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"missing", []string{"-port", "5000"}, ""},
+		{"separate value", []string{"-port", "5000", "-config", "node.toml"}, "node.toml"},
+		{"double dash", []string{"--config", "node.toml"}, "node.toml"},
+		{"equals", []string{"-config=node.toml"}, "node.toml"},
+		{"no value", []string{"-config"}, ""},
+		{"after terminator", []string{"--", "-config", "node.toml"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if v := FindFlag(tt.args, "config"); v != tt.want {
+				t.Errorf("FindFlag = %q, want %q", v, tt.want)
+			}
+		})
+	}
+}
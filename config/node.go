@@ -0,0 +1,192 @@
+package config
+
+import (
+	"blockchain/block"
+	"blockchain/p2p"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+)
+
+const NODE_ENV_PREFIX = "BLOCKCHAIN_"
+
+// settings of a blockchain node
+type Node struct {
+	Network   Network   `toml:"network"`
+	Consensus Consensus `toml:"consensus"`
+	Mining    Mining    `toml:"mining"`
+	Peers     Peers     `toml:"peers"`
+	Storage   Storage   `toml:"storage"`
+	Webhooks  Webhooks  `toml:"webhooks"`
+	API       API       `toml:"api"`
+}
+
+// Name is a built in network, Genesis a spec file that wins over it
+type Network struct {
+	Name    string `toml:"name"`
+	Genesis string `toml:"genesis"`
+	// a chain with other consensus parameters than its network's needs
+	// its own id
+	ChainID string `toml:"chain_id"`
+}
+
+// overrides of the genesis parameters, unset ones are the network's.
+// changing any of them starts a different chain
+type Consensus struct {
+	Difficulty       *int     `toml:"difficulty"`
+	MiningReward     *float32 `toml:"mining_reward"`
+	HalvingInterval  *int     `toml:"halving_interval"`
+	MaxSupply        *float32 `toml:"max_supply"`
+	CoinbaseMaturity *int     `toml:"coinbase_maturity"`
+}
+
+type Mining struct {
+	// start the mining loop with the node
+	Start bool `toml:"start"`
+	// where rewards go, a new wallet's address when empty
+	Address     string `toml:"address"`
+	IntervalSec int    `toml:"interval_sec"`
+	MineEmpty   bool   `toml:"mine_empty"`
+}
+
+type Peers struct {
+	Port  uint `toml:"port"`
+	Seeds List `toml:"seeds"`
+	// in the data directory when relative
+	File        string `toml:"file"`
+	MaxInbound  int    `toml:"max_inbound"`
+	MaxOutbound int    `toml:"max_outbound"`
+}
+
+type Storage struct {
+	// in the data directory when relative
+	WebhooksFile string `toml:"webhooks_file"`
+	Snapshot     string `toml:"snapshot"`
+	// what the snapshot must commit to, taken from a node that is
+	// trusted. the snapshot's balances are believed because of it
+	SnapshotCommitment string `toml:"snapshot_commitment"`
+	Import             string `toml:"import"`
+	Prune              int    `toml:"prune"`
+}
+
+type Webhooks struct {
+	// deliver to loopback, link-local and private addresses, for hooks
+	// served next to the node. anyone who can reach the api can then
+	// have the node post to them
+	AllowPrivate bool `toml:"allow_private"`
+	// bearer token the webhook endpoints require, they are off without
+	// one. kept out of the flags, set it here or in the environment
+	Token string `toml:"token"`
+}
+
+func DefaultNode() *Node {
+	return &Node{
+		Network: Network{Name: "mainnet"},
+		Mining:  Mining{IntervalSec: block.MINING_TIMER_SEC},
+		Peers: Peers{
+			Port:        6000,
+			Seeds:       List{},
+			File:        "peers.json",
+			MaxInbound:  p2p.MAX_INBOUND,
+			MaxOutbound: p2p.MAX_OUTBOUND,
+		},
+		Storage: Storage{WebhooksFile: "webhooks.json"},
+		API:     API{Host: "0.0.0.0", Port: 5000},
+	}
+}
+
+// the genesis of the network with the consensus overrides applied
+func (n *Node) Genesis() (*block.Genesis, error) {
+	genesis, ok := block.GenesisForNetwork(n.Network.Name)
+	if n.Network.Genesis != "" {
+		g, err := block.LoadGenesis(n.Network.Genesis)
+		if err != nil {
+			return nil, fmt.Errorf("network.genesis: %w", err)
+		}
+		genesis, ok = g, true
+	}
+	if !ok {
+		return nil, fmt.Errorf("network.name: unknown network %q (mainnet, testnet)", n.Network.Name)
+	}
+	g := *genesis
+	g.Allocations = append([]block.Allocation{}, genesis.Allocations...)
+	c := n.Consensus
+	changed := false
+	if c.Difficulty != nil {
+		g.Difficulty, changed = *c.Difficulty, true
+	}
+	if c.MiningReward != nil {
+		g.MiningReward, changed = *c.MiningReward, true
+	}
+	if c.HalvingInterval != nil {
+		g.HalvingInterval, changed = *c.HalvingInterval, true
+	}
+	if c.MaxSupply != nil {
+		g.MaxSupply, changed = *c.MaxSupply, true
+	}
+	if c.CoinbaseMaturity != nil {
+		g.CoinbaseMaturity, changed = *c.CoinbaseMaturity, true
+	}
+	if n.Network.ChainID != "" {
+		g.ChainID = n.Network.ChainID
+	} else if changed && g.ChainID == genesis.ChainID {
+		if _, builtin := block.GenesisForNetwork(g.ChainID); builtin {
+			return nil, fmt.Errorf("consensus: the parameters of %s can't change, set network.chain_id for a new chain", g.ChainID)
+		}
+	}
+	if err := g.Validate(); err != nil {
+		return nil, fmt.Errorf("consensus: %w", err)
+	}
+	return &g, nil
+}
+
+// every problem of the settings at once
+func (n *Node) Validate() error {
+	var errs []error
+	if _, err := n.Genesis(); err != nil {
+		errs = append(errs, err)
+	}
+	if n.Mining.IntervalSec < 1 {
+		errs = append(errs, errors.New("mining.interval_sec must be at least 1"))
+	}
+	if n.Mining.Address == block.MINING_SENDER {
+		errs = append(errs, fmt.Errorf("mining.address can't be %q", block.MINING_SENDER))
+	}
+	if n.Peers.Port < 1 || n.Peers.Port > 65535 {
+		errs = append(errs, fmt.Errorf("peers.port %d out of range 1-65535", n.Peers.Port))
+	}
+	if n.Peers.Port == n.API.Port {
+		errs = append(errs, fmt.Errorf("peers.port and api.port are both %d", n.API.Port))
+	}
+	for _, s := range n.Peers.Seeds {
+		if _, _, err := net.SplitHostPort(s); err != nil {
+			errs = append(errs, fmt.Errorf("peers.seeds: %q is not host:port", s))
+		}
+	}
+	if n.Peers.File == "" {
+		errs = append(errs, errors.New("peers.file is required"))
+	}
+	if n.Peers.MaxInbound < 0 || n.Peers.MaxOutbound < 0 {
+		errs = append(errs, errors.New("peers.max_inbound and peers.max_outbound can't be negative"))
+	}
+	if n.Storage.WebhooksFile == "" {
+		errs = append(errs, errors.New("storage.webhooks_file is required"))
+	}
+	if n.Storage.Snapshot != "" && !isHash(n.Storage.SnapshotCommitment) {
+		errs = append(errs, errors.New("storage.snapshot_commitment must be the 64 hex characters of a trusted node's snapshot at the same height"))
+	}
+	if n.Storage.Prune != 0 && n.Storage.Prune < block.MIN_PRUNE_KEEP {
+		errs = append(errs, fmt.Errorf("storage.prune must be 0 or at least %d", block.MIN_PRUNE_KEEP))
+	}
+	errs = append(errs,
+		fileExists("storage.snapshot", n.Storage.Snapshot),
+		fileExists("storage.import", n.Storage.Import),
+		n.API.validate())
+	return errors.Join(errs...)
+}
+
+func isHash(s string) bool {
+	b, err := hex.DecodeString(s)
+	return err == nil && len(b) == 32
+}
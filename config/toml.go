@@ -0,0 +1,408 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// the part of TOML config files need: [section] tables of key = value
+// pairs, values being strings, integers, floats, booleans or arrays of
+// them. comments start with #
+
+type entry struct {
+	line    int
+	section string
+	key     string
+	value   interface{}
+}
+
+type parser struct {
+	name string
+	data []rune
+	pos  int
+	line int
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("%s:%d: %s", p.name, p.line, fmt.Sprintf(format, args...))
+}
+
+func (p *parser) peek() rune {
+	if p.pos >= len(p.data) {
+		return 0
+	}
+	return p.data[p.pos]
+}
+
+func (p *parser) next() rune {
+	r := p.peek()
+	p.pos++
+	if r == '\n' {
+		p.line++
+	}
+	return r
+}
+
+func (p *parser) skipSpace() {
+	for r := p.peek(); r == ' ' || r == '\t'; r = p.peek() {
+		p.next()
+	}
+}
+
+func (p *parser) skipComment() {
+	if p.peek() == '#' {
+		for p.pos < len(p.data) && p.peek() != '\n' {
+			p.next()
+		}
+	}
+}
+
+// spaces, newlines and comments, inside arrays
+func (p *parser) skipBlank() {
+	for {
+		p.skipSpace()
+		p.skipComment()
+		if r := p.peek(); r != '\n' && r != '\r' {
+			return
+		}
+		p.next()
+	}
+}
+
+// after a value or header only a comment may follow on the line
+func (p *parser) endLine() error {
+	p.skipSpace()
+	p.skipComment()
+	if p.peek() == '\r' {
+		p.next()
+	}
+	if p.pos < len(p.data) && p.next() != '\n' {
+		return p.errorf("unexpected text after value")
+	}
+	return nil
+}
+
+func isKeyRune(r rune) bool {
+	return r == '_' || r == '-' || r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r))
+}
+
+func (p *parser) key() string {
+	start := p.pos
+	for isKeyRune(p.peek()) {
+		p.next()
+	}
+	return string(p.data[start:p.pos])
+}
+
+func parseTOML(name string, data string) ([]entry, error) {
+	p := &parser{name: name, data: []rune(data), line: 1}
+	entries := make([]entry, 0)
+	section := ""
+	for {
+		p.skipBlank()
+		if p.pos >= len(p.data) {
+			return entries, nil
+		}
+		if p.peek() == '[' {
+			p.next()
+			if p.peek() == '[' {
+				return nil, p.errorf("arrays of tables are not supported")
+			}
+			p.skipSpace()
+			section = p.key()
+			p.skipSpace()
+			if section == "" || p.next() != ']' {
+				return nil, p.errorf("bad section header")
+			}
+			if err := p.endLine(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		line := p.line
+		key := p.key()
+		if key == "" {
+			return nil, p.errorf("expected a key")
+		}
+		p.skipSpace()
+		if p.peek() == '.' {
+			return nil, p.errorf("dotted keys are not supported, use a [section]")
+		}
+		if p.next() != '=' {
+			return nil, p.errorf("expected = after %s", key)
+		}
+		p.skipSpace()
+		v, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.endLine(); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry{line, section, key, v})
+	}
+}
+
+func (p *parser) value() (interface{}, error) {
+	switch p.peek() {
+	case '"':
+		return p.basicString()
+	case '\'':
+		p.next()
+		start := p.pos
+		for p.peek() != '\'' {
+			if p.pos >= len(p.data) || p.peek() == '\n' {
+				return nil, p.errorf("unterminated string")
+			}
+			p.next()
+		}
+		s := string(p.data[start:p.pos])
+		p.next()
+		return s, nil
+	case '[':
+		return p.array()
+	}
+	start := p.pos
+	for r := p.peek(); r != 0 && r != ',' && r != ']' && r != '#' && !unicode.IsSpace(r); r = p.peek() {
+		p.next()
+	}
+	return p.scalar(string(p.data[start:p.pos]))
+}
+
+func (p *parser) basicString() (string, error) {
+	p.next()
+	var sb strings.Builder
+	for {
+		if p.pos >= len(p.data) || p.peek() == '\n' {
+			return "", p.errorf("unterminated string")
+		}
+		r := p.next()
+		if r == '"' {
+			return sb.String(), nil
+		}
+		if r != '\\' {
+			sb.WriteRune(r)
+			continue
+		}
+		switch e := p.next(); e {
+		case '"', '\\':
+			sb.WriteRune(e)
+		case 'n':
+			sb.WriteRune('\n')
+		case 't':
+			sb.WriteRune('\t')
+		case 'r':
+			sb.WriteRune('\r')
+		case 'u':
+			if p.pos+4 > len(p.data) {
+				return "", p.errorf("bad \\u escape")
+			}
+			n, err := strconv.ParseUint(string(p.data[p.pos:p.pos+4]), 16, 32)
+			if err != nil {
+				return "", p.errorf("bad \\u escape")
+			}
+			p.pos += 4
+			sb.WriteRune(rune(n))
+		default:
+			return "", p.errorf("unknown escape \\%c", e)
+		}
+	}
+}
+
+func (p *parser) array() ([]interface{}, error) {
+	p.next()
+	values := make([]interface{}, 0)
+	for {
+		p.skipBlank()
+		if p.peek() == ']' {
+			p.next()
+			return values, nil
+		}
+		if p.pos >= len(p.data) {
+			return nil, p.errorf("unterminated array")
+		}
+		v, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+		p.skipBlank()
+		switch p.peek() {
+		case ',':
+			p.next()
+		case ']':
+		default:
+			return nil, p.errorf("expected , or ] in array")
+		}
+	}
+}
+
+func (p *parser) scalar(s string) (interface{}, error) {
+	switch s {
+	case "":
+		return nil, p.errorf("expected a value")
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	}
+	clean := strings.ReplaceAll(s, "_", "")
+	if i, err := strconv.ParseInt(clean, 10, 64); err == nil {
+		return i, nil
+	}
+	if f, err := strconv.ParseFloat(clean, 64); err == nil {
+		return f, nil
+	}
+	return nil, p.errorf("bad value %q, strings must be quoted", s)
+}
+
+// the field of a section struct for a key, by its toml tag
+func field(v reflect.Value, key string) (reflect.Value, bool) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).Tag.Get("toml") == key {
+			return v.Field(i), true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+// stores entries into the sections of the struct v points to
+func decode(entries []entry, name string, v interface{}) error {
+	root := reflect.ValueOf(v).Elem()
+	for _, e := range entries {
+		if e.section == "" {
+			return fmt.Errorf("%s:%d: %s is outside of a [section]", name, e.line, e.key)
+		}
+		section, ok := field(root, e.section)
+		if !ok {
+			return fmt.Errorf("%s:%d: unknown section [%s]", name, e.line, e.section)
+		}
+		f, ok := field(section, e.key)
+		if !ok {
+			return fmt.Errorf("%s:%d: unknown key %s.%s", name, e.line, e.section, e.key)
+		}
+		if err := set(f, e.value); err != nil {
+			return fmt.Errorf("%s:%d: %s.%s: %v", name, e.line, e.section, e.key, err)
+		}
+	}
+	return nil
+}
+
+func set(f reflect.Value, v interface{}) error {
+	switch f.Kind() {
+	case reflect.Ptr:
+		elem := reflect.New(f.Type().Elem())
+		if err := set(elem.Elem(), v); err != nil {
+			return err
+		}
+		f.Set(elem)
+		return nil
+	case reflect.String:
+		if s, ok := v.(string); ok {
+			f.SetString(s)
+			return nil
+		}
+		return fmt.Errorf("expected a string")
+	case reflect.Bool:
+		if b, ok := v.(bool); ok {
+			f.SetBool(b)
+			return nil
+		}
+		return fmt.Errorf("expected true or false")
+	case reflect.Int, reflect.Int64:
+		if i, ok := v.(int64); ok && !f.OverflowInt(i) {
+			f.SetInt(i)
+			return nil
+		}
+		return fmt.Errorf("expected an integer")
+	case reflect.Uint, reflect.Uint16:
+		if i, ok := v.(int64); ok && i >= 0 && !f.OverflowUint(uint64(i)) {
+			f.SetUint(uint64(i))
+			return nil
+		}
+		return fmt.Errorf("expected a positive integer")
+	case reflect.Float32, reflect.Float64:
+		switch n := v.(type) {
+		case float64:
+			f.SetFloat(n)
+			return nil
+		case int64:
+			f.SetFloat(float64(n))
+			return nil
+		}
+		return fmt.Errorf("expected a number")
+	case reflect.Slice:
+		a, ok := v.([]interface{})
+		if !ok {
+			return fmt.Errorf("expected an array")
+		}
+		s := reflect.MakeSlice(f.Type(), len(a), len(a))
+		for i, item := range a {
+			if err := set(s.Index(i), item); err != nil {
+				return fmt.Errorf("item %d: %v", i+1, err)
+			}
+		}
+		f.Set(s)
+		return nil
+	}
+	return fmt.Errorf("unsupported field type %s", f.Type())
+}
+
+// same as set, from the text of an environment variable. arrays are
+// comma separated
+func setString(f reflect.Value, s string) error {
+	switch f.Kind() {
+	case reflect.Ptr:
+		elem := reflect.New(f.Type().Elem())
+		if err := setString(elem.Elem(), s); err != nil {
+			return err
+		}
+		f.Set(elem)
+		return nil
+	case reflect.String:
+		f.SetString(s)
+		return nil
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("expected true or false")
+		}
+		f.SetBool(b)
+		return nil
+	case reflect.Int, reflect.Int64:
+		i, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return fmt.Errorf("expected an integer")
+		}
+		return set(f, i)
+	case reflect.Uint, reflect.Uint16:
+		i, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return fmt.Errorf("expected a positive integer")
+		}
+		return set(f, i)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return fmt.Errorf("expected a number")
+		}
+		f.SetFloat(n)
+		return nil
+	case reflect.Slice:
+		items := make([]interface{}, 0)
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		if f.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("unsupported field type %s", f.Type())
+		}
+		return set(f, items)
+	}
+	return fmt.Errorf("unsupported field type %s", f.Type())
+}
@@ -0,0 +1,134 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseTOML(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		entries []entry
+	}{
+		{"empty", "", []entry{}},
+		{"comments only", "# a comment\n\n  # another\n", []entry{}},
+		{"scalars", "[api]\nhost = \"localhost\"\nport = 5_000\nrate = 1.5\ntls = false\n", []entry{
+			{2, "api", "host", "localhost"},
+			{3, "api", "port", int64(5000)},
+			{4, "api", "rate", 1.5},
+			{5, "api", "tls", false},
+		}},
+		{"literal string", "[a]\npath = 'C:\\data' # windows\n", []entry{{2, "a", "path", `C:\data`}}},
+		{"escapes", "[a]\ns = \"tab\\t\\\"quote\\\" \\u00e9\"\n", []entry{{2, "a", "s", "tab\t\"quote\" é"}}},
+		{"crlf", "[a]\r\nk = 1\r\n", []entry{{2, "a", "k", int64(1)}}},
+		{"arrays", "[p2p]\nseeds = [\n  \"a:1\", # first\n  \"b:2\",\n]\nempty = []\n", []entry{
+			{2, "p2p", "seeds", []interface{}{"a:1", "b:2"}},
+			{6, "p2p", "empty", []interface{}{}},
+		}},
+		{"sections", "top = 1\n[ a ]\nk = -2\n[b]\nk = true\n", []entry{
+			{1, "", "top", int64(1)},
+			{3, "a", "k", int64(-2)},
+			{5, "b", "k", true},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, err := parseTOML("test.toml", tt.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(entries, tt.entries) {
+				t.Errorf("entries %v, want %v", entries, tt.entries)
+			}
+		})
+	}
+}
+
+func TestParseTOMLErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		err  string
+	}{
+		{"unquoted string", "[a]\nk = value\n", "test.toml:2: bad value"},
+		{"unterminated string", "[a]\nk = \"value\n", "unterminated string"},
+		{"unterminated literal", "[a]\nk = 'value\n", "unterminated string"},
+		{"unknown escape", "[a]\nk = \"\\q\"\n", "unknown escape"},
+		{"bad unicode escape", "[a]\nk = \"\\u12\"\n", "bad \\u escape"},
+		{"unterminated array", "[a]\nk = [1,\n", "unterminated array"},
+		{"array without commas", "[a]\nk = [1 2]\n", "expected , or ]"},
+		{"missing value", "[a]\nk =\n", "expected a value"},
+		{"missing equals", "[a]\nk 1\n", "expected = after k"},
+		{"text after value", "[a]\nk = 1 2\n", "unexpected text after value"},
+		{"dotted key", "[a]\nb.c = 1\n", "dotted keys"},
+		{"array of tables", "[[a]]\n", "arrays of tables"},
+		{"bad header", "[a\nk = 1\n", "bad section header"},
+		{"empty header", "[]\n", "bad section header"},
+		{"no key", "[a]\n= 1\n", "expected a key"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseTOML("test.toml", tt.data)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("error %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+type testSection struct {
+	Host  string   `toml:"host"`
+	Port  uint16   `toml:"port"`
+	Count int      `toml:"count"`
+	Rate  float64  `toml:"rate"`
+	On    *bool    `toml:"on"`
+	Seeds []string `toml:"seeds"`
+}
+
+type testConfig struct {
+	Section testSection `toml:"section"`
+}
+
+func TestDecode(t *testing.T) {
+	on := true
+	tests := []struct {
+		name string
+		data string
+		want testSection
+		err  string
+	}{
+		{"all kinds", "[section]\nhost = \"h\"\nport = 80\ncount = -1\nrate = 2\non = true\nseeds = [\"a\"]\n",
+			testSection{Host: "h", Port: 80, Count: -1, Rate: 2, On: &on, Seeds: []string{"a"}}, ""},
+		{"outside of a section", "host = \"h\"\n", testSection{}, "test.toml:1: host is outside of a [section]"},
+		{"unknown section", "[other]\nhost = \"h\"\n", testSection{}, "unknown section [other]"},
+		{"unknown key", "[section]\nuser = \"u\"\n", testSection{}, "unknown key section.user"},
+		{"wrong type", "[section]\nhost = 1\n", testSection{}, "test.toml:2: section.host: expected a string"},
+		{"port out of range", "[section]\nport = 70000\n", testSection{}, "expected a positive integer"},
+		{"negative port", "[section]\nport = -1\n", testSection{}, "expected a positive integer"},
+		{"wrong item type", "[section]\nseeds = [\"a\", 1]\n", testSection{}, "item 2: expected a string"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, err := parseTOML("test.toml", tt.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var c testConfig
+			err = decode(entries, "test.toml", &c)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Errorf("error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(c.Section, tt.want) {
+				t.Errorf("decoded %+v, want %+v", c.Section, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+)
+
+const WALLET_ENV_PREFIX = "WALLET_SERVER_"
+
+// settings of a wallet server
+type Wallet struct {
+	Gateway Gateway `toml:"gateway"`
+	SPV     SPV     `toml:"spv"`
+	API     API     `toml:"api"`
+}
+
+// the nodes the wallet server sends requests to
+type Gateway struct {
+	URLs     List   `toml:"urls"`
+	Strategy string `toml:"strategy"`
+	// gateways that must agree on a balance
+	Quorum int `toml:"quorum"`
+}
+
+// light client mode, balances are verified against block headers
+type SPV struct {
+	Enabled bool `toml:"enabled"`
+	// header sources, the gateways when empty
+	Nodes   List   `toml:"nodes"`
+	Network string `toml:"network"`
+	Genesis string `toml:"genesis"`
+}
+
+func DefaultWallet() *Wallet {
+	return &Wallet{
+		Gateway: Gateway{URLs: List{"http://127.0.0.1:5000"}, Strategy: "priority", Quorum: 1},
+		SPV:     SPV{Nodes: List{}, Network: "mainnet"},
+		API:     API{Host: "0.0.0.0", Port: 8080},
+	}
+}
+
+func (w *Wallet) Validate() error {
+	var errs []error
+	if len(w.Gateway.URLs) == 0 {
+		errs = append(errs, errors.New("gateway.urls needs at least one node url"))
+	}
+	errs = append(errs, validURLs("gateway.urls", w.Gateway.URLs), validURLs("spv.nodes", w.SPV.Nodes))
+	if w.Gateway.Strategy != "priority" && w.Gateway.Strategy != "round_robin" {
+		errs = append(errs, fmt.Errorf("gateway.strategy: unknown strategy %q (priority, round_robin)", w.Gateway.Strategy))
+	}
+	if w.Gateway.Quorum < 1 || w.Gateway.Quorum > len(w.Gateway.URLs) {
+		errs = append(errs, fmt.Errorf("gateway.quorum must be between 1 and %d", len(w.Gateway.URLs)))
+	}
+	errs = append(errs, fileExists("spv.genesis", w.SPV.Genesis), w.API.validate())
+	return errors.Join(errs...)
+}
+
+func validURLs(key string, urls []string) error {
+	var errs []error
+	for _, s := range urls {
+		u, err := url.Parse(s)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("%s: %q is not an http(s) url", key, s))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -3,6 +3,6 @@ module blockchain
 go 1.22.4
 
 require (
-	github.com/btcsuite/btcutil v1.0.2 // indirect
-	golang.org/x/crypto v0.24.0 // indirect
+	github.com/btcsuite/btcutil v1.0.2
+	golang.org/x/crypto v0.24.0
 )
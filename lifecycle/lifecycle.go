@@ -0,0 +1,138 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// what all components together get to stop
+	SHUTDOWN_TIMEOUT    = 15 * time.Second
+	READ_HEADER_TIMEOUT = 10 * time.Second
+	IDLE_TIMEOUT        = 2 * time.Minute
+)
+
+// a part of a server running in the background, Stop ends it and waits
+// for it, giving up when ctx is done
+type Component interface {
+	Stop(ctx context.Context) error
+}
+
+// a function as a Component
+type StopFunc func(ctx context.Context) error
+
+func (f StopFunc) Stop(ctx context.Context) error {
+	return f(ctx)
+}
+
+type registered struct {
+	name      string
+	component Component
+}
+
+// stops the components of a server in the reverse order they registered,
+// so the last one started, usually the api, is the first to go
+type Manager struct {
+	components []registered
+	stopped    bool
+	mux        sync.Mutex
+}
+
+func NewManager() *Manager {
+	return &Manager{components: make([]registered, 0)}
+}
+
+func (m *Manager) Register(name string, c Component) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.components = append(m.components, registered{name, c})
+}
+
+// stops every component once, within timeout for all of them. one that
+// fails or runs out of time doesn't keep the others from stopping
+func (m *Manager) Shutdown(timeout time.Duration) error {
+	m.mux.Lock()
+	if m.stopped {
+		m.mux.Unlock()
+		return nil
+	}
+	m.stopped = true
+	components := m.components
+	m.mux.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	var errs []error
+	for i := len(components) - 1; i >= 0; i-- {
+		r := components[i]
+		start := time.Now()
+		if err := r.component.Stop(ctx); err != nil {
+			log.Printf("ERROR: action=Shutdown, component=%s, status=fail, error=%v", r.name, err)
+			errs = append(errs, fmt.Errorf("%s: %w", r.name, err))
+			continue
+		}
+		log.Printf("action=Shutdown, component=%s, status=stopped, took=%v", r.name, time.Since(start).Round(time.Millisecond))
+	}
+	return errors.Join(errs...)
+}
+
+// blocks until the process gets SIGINT or SIGTERM, or errc gets the
+// error a component failed with, then shuts down. a second signal
+// exits at once
+func (m *Manager) Run(errc <-chan error) error {
+	sigs := make(chan os.Signal, 2)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+
+	var failed error
+	select {
+	case sig := <-sigs:
+		log.Printf("received %v, shutting down", sig)
+	case failed = <-errc:
+		log.Printf("ERROR: %v, shutting down", failed)
+	}
+	go func() {
+		sig := <-sigs
+		log.Printf("received %v again, exiting", sig)
+		os.Exit(1)
+	}()
+	return errors.Join(failed, m.Shutdown(SHUTDOWN_TIMEOUT))
+}
+
+// an http server with the timeouts of a public api. it has no write
+// timeout, event streams and chain exports are long responses
+func NewHTTPServer(addr string, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: READ_HEADER_TIMEOUT,
+		IdleTimeout:       IDLE_TIMEOUT,
+	}
+}
+
+// serves in the background, over https when certFile is set. the
+// channel gets the error serving stops with, nothing once the server
+// is shut down
+func Serve(srv *http.Server, certFile string, keyFile string) <-chan error {
+	errc := make(chan error, 1)
+	go func() {
+		var err error
+		if certFile != "" {
+			err = srv.ListenAndServeTLS(certFile, keyFile)
+		} else {
+			err = srv.ListenAndServe()
+		}
+		if !errors.Is(err, http.ErrServerClosed) {
+			errc <- err
+		}
+	}()
+	return errc
+}
@@ -1,21 +1,117 @@
 package main
 
 import (
-	"blockchain/block"
-	"blockchain/wallet" // Relative import path to the wallet package
+	"blockchain/client"
+	"context"
+	"encoding/json"
+	"flag"
 	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
 )
 
+// blockchain runs a node and works with wallets, the chain, peers and
+// mining, through a node's http api or the files in the data directory
+
+const DEFAULT_NODE = "http://127.0.0.1:5000"
+
+type command struct {
+	args  string
+	short string
+	run   func(env *Env, args []string) error
+}
+
+// what every command gets: the node to talk to and the data directory
+type Env struct {
+	Node    string
+	Datadir string
+	Client  *client.Client
+	Ctx     context.Context
+}
+
+// flags of a subcommand, usage is the arguments after them
+func (env *Env) flags(group string, name string, usage string) *flag.FlagSet {
+	fs := flag.NewFlagSet("blockchain "+group+" "+name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s [flags] %s\n", fs.Name(), usage)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+var commands = map[string]map[string]*command{
+	"node":   nodeCommands,
+	"wallet": walletCommands,
+	"chain":  chainCommands,
+	"peers":  peersCommands,
+	"mine":   mineCommands,
+}
+
+func defaultDatadir() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ".blockchain"
+	}
+	return filepath.Join(home, ".blockchain")
+}
+
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintln(out, "usage: blockchain [flags] <command> <subcommand> [arguments]")
+	fmt.Fprintln(out, "\ncommands:")
+	groups := make([]string, 0, len(commands))
+	for g := range commands {
+		groups = append(groups, g)
+	}
+	sort.Strings(groups)
+	for _, g := range groups {
+		names := make([]string, 0, len(commands[g]))
+		for n := range commands[g] {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		for _, n := range names {
+			c := commands[g][n]
+			fmt.Fprintf(out, "  %-42s %s\n", strings.TrimSpace(g+" "+n+" "+c.args), c.short)
+		}
+	}
+	fmt.Fprintln(out, "\nflags:")
+	flag.PrintDefaults()
+}
+
 func main() {
-	walletM := wallet.NewWallet()
-	walletA := wallet.NewWallet()
-	walletB := wallet.NewWallet()
+	log.SetFlags(0)
+	log.SetPrefix("blockchain: ")
+	node := flag.String("node", DEFAULT_NODE, "Blockchain Server the commands talk to")
+	datadir := flag.String("datadir", defaultDatadir(), "Directory wallets, peers and node files are kept in")
+	flag.Usage = usage
+	flag.Parse()
 
-	t := wallet.NewTransaction(walletA.GetPrivateKey(), walletA.GetPublicKey(), walletA.GetBlockchainAddress(), walletB.GetBlockchainAddress(), 3.0)
+	args := flag.Args()
+	if len(args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	c, ok := commands[args[0]][args[1]]
+	if !ok {
+		log.Printf("unknown command %q", strings.Join(args[:2], " "))
+		usage()
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	env := &Env{Node: *node, Datadir: *datadir, Client: client.NewClient(*node, 0), Ctx: ctx}
+	if err := c.run(env, args[2:]); err != nil {
+		log.Fatal(err)
+	}
+}
 
-	blockchain := block.NewBlockChain(walletM.GetBlockchainAddress())
-	isAdded := blockchain.AddTransaction(walletA.GetBlockchainAddress(), walletB.GetBlockchainAddress(), 3.0, walletA.GetPublicKey(), t.GenerateSignature())
-	fmt.Println("added?", isAdded)
-	blockchain.Mining()
-	blockchain.PrintBlockchain()
+func printJSON(v interface{}) {
+	m, _ := json.MarshalIndent(v, "", "  ")
+	fmt.Println(string(m))
 }
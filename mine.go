@@ -0,0 +1,61 @@
+package main
+
+import (
+	"blockchain/block"
+	"fmt"
+)
+
+var mineCommands = map[string]*command{
+	"once":   {short: "mine one block from the pool", run: mineOnce},
+	"start":  {short: "start the node's mining loop", run: mineStart},
+	"stop":   {short: "stop the node's mining loop", run: mineStop},
+	"status": {short: "whether the node mines and how fast", run: mineStatus},
+}
+
+func printMiningStatus(ms *block.MiningStatus) {
+	fmt.Printf("running=%v blocks_found=%d hash_rate=%.0f/s address=%s interval=%ds\n",
+		ms.Running, ms.BlocksFound, ms.HashRate, ms.Settings.Address, ms.Settings.IntervalSec)
+}
+
+func mineOnce(env *Env, args []string) error {
+	env.flags("mine", "once", "").Parse(args)
+	if err := env.Client.Mine(env.Ctx); err != nil {
+		return err
+	}
+	status, err := env.Client.Status(env.Ctx)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("mined block %d\n", status.Height)
+	return nil
+}
+
+func mineStart(env *Env, args []string) error {
+	env.flags("mine", "start", "").Parse(args)
+	ms, err := env.Client.StartMining(env.Ctx)
+	if err != nil {
+		return err
+	}
+	printMiningStatus(ms)
+	return nil
+}
+
+func mineStop(env *Env, args []string) error {
+	env.flags("mine", "stop", "").Parse(args)
+	ms, err := env.Client.StopMining(env.Ctx)
+	if err != nil {
+		return err
+	}
+	printMiningStatus(ms)
+	return nil
+}
+
+func mineStatus(env *Env, args []string) error {
+	env.flags("mine", "status", "").Parse(args)
+	ms, err := env.Client.MiningStatus(env.Ctx)
+	if err != nil {
+		return err
+	}
+	printMiningStatus(ms)
+	return nil
+}
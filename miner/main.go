@@ -0,0 +1,46 @@
+package main
+
+import (
+	"blockchain/client"
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"runtime"
+	"time"
+)
+
+func init() {
+	log.SetPrefix("Miner: ")
+}
+
+func main() {
+	node := flag.String("node", "http://127.0.0.1:5000", "Blockchain Server to get templates from and submit blocks to")
+	address := flag.String("address", "", "Address the block reward is paid to, the node's own when empty")
+	workers := flag.Int("workers", runtime.NumCPU(), "Number of goroutines searching for a nonce")
+	refresh := flag.Duration("refresh", 5*time.Second, "How often to fetch a new template while no block is found")
+	pool := flag.String("pool", "", "host:port of a stratum pool to mine for instead of -node")
+	worker := flag.String("worker", "", "Worker name at the pool, the payout address optionally followed by .rig, defaults to -address")
+	flag.Parse()
+
+	if *workers < 1 {
+		log.Fatal("-workers must be at least 1")
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	m := NewMiner(client.NewClient(*node, 0), *address, *workers, *refresh)
+	if *pool != "" {
+		if *worker == "" {
+			*worker = *address
+		}
+		if *worker == "" {
+			log.Fatal("-pool needs -worker or -address")
+		}
+		m.RunPool(ctx, *pool, *worker)
+		return
+	}
+	log.Printf("mining on %s with %v", *node, m)
+	m.Run(ctx)
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"blockchain/block"
+	"blockchain/client"
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// wait before reconnecting to the event stream or retrying a template
+const RETRY_INTERVAL = 3 * time.Second
+
+// mines on a node's block templates with a number of worker goroutines
+type Miner struct {
+	client  *client.Client
+	address string
+	workers int
+	refresh time.Duration
+	hashes  atomic.Int64
+	found   int
+}
+
+func NewMiner(c *client.Client, address string, workers int, refresh time.Duration) *Miner {
+	return &Miner{client: c, address: address, workers: workers, refresh: refresh}
+}
+
+// mines until ctx ends. work starts over on a fresh template every
+// refresh, so new pool transactions get in, and as soon as the node
+// reports a new block
+func (m *Miner) Run(ctx context.Context) {
+	tips := make(chan struct{}, 1)
+	go m.watchBlocks(ctx, tips)
+
+	start := time.Now()
+	for ctx.Err() == nil {
+		t, err := m.client.GetBlockTemplate(ctx, m.address)
+		if err != nil {
+			log.Printf("ERROR: block template: %v", err)
+			sleep(ctx, RETRY_INTERVAL)
+			continue
+		}
+		nonce, ok := m.search(ctx, t, tips)
+		if ok {
+			m.submit(ctx, t, nonce)
+		}
+		log.Printf("hash_rate=%.0f/s blocks_found=%d",
+			float64(m.hashes.Load())/time.Since(start).Seconds(), m.found)
+	}
+}
+
+// runs the workers on t until one finds a nonce, the refresh time is up
+// or the tip moves
+func (m *Miner) search(ctx context.Context, t *block.BlockTemplate, tips chan struct{}) (int, bool) {
+	ctx, cancel := context.WithTimeout(ctx, m.refresh)
+	solution := make(chan int, 1)
+	var wg sync.WaitGroup
+	// the workers only stop once ctx is cancelled
+	defer wg.Wait()
+	defer cancel()
+	for i := 0; i < m.workers; i++ {
+		wg.Add(1)
+		go func(first int) {
+			defer wg.Done()
+			m.work(ctx, t, first, func(nonce int) {
+				select {
+				case solution <- nonce:
+				default:
+				}
+			})
+		}(i)
+	}
+	select {
+	case nonce := <-solution:
+		return nonce, true
+	case <-tips:
+		return 0, false
+	case <-ctx.Done():
+		return 0, false
+	}
+}
+
+// tries every workers-th nonce starting at first until ctx ends, calling
+// found with each nonce that meets the template's difficulty
+func (m *Miner) work(ctx context.Context, t *block.BlockTemplate, first int, found func(nonce int)) {
+	var hashes int64
+	defer func() { m.hashes.Add(hashes) }()
+	for nonce := first; ; nonce += m.workers {
+		// checking the context on every hash would slow the search down
+		if hashes%1024 == 0 && ctx.Err() != nil {
+			return
+		}
+		hashes++
+		if t.Check(nonce) {
+			found(nonce)
+		}
+	}
+}
+
+func (m *Miner) submit(ctx context.Context, t *block.BlockTemplate, nonce int) {
+	b := t.Block(nonce)
+	height, err := m.client.SubmitBlock(ctx, b)
+	switch {
+	case client.IsCode(err, "stale_block"):
+		log.Printf("block %x at height %d came too late", b.Hash(), t.Height)
+	case err != nil:
+		log.Printf("ERROR: submit block: %v", err)
+	default:
+		m.found++
+		log.Printf("action=SubmitBlock, height=%d, hash=%x, nonce=%d", height, b.Hash(), nonce)
+	}
+}
+
+// signals tips whenever the node adds a block, reconnecting when the
+// stream breaks
+func (m *Miner) watchBlocks(ctx context.Context, tips chan struct{}) {
+	for ctx.Err() == nil {
+		if err := m.followBlocks(ctx, tips); err != nil && ctx.Err() == nil {
+			log.Printf("ERROR: block events: %v", err)
+		}
+		sleep(ctx, RETRY_INTERVAL)
+	}
+}
+
+func (m *Miner) followBlocks(ctx context.Context, tips chan struct{}) error {
+	stream, err := m.client.Events(ctx, []string{block.EVENT_BLOCK}, nil, "")
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+	for {
+		e, err := stream.Next()
+		if err != nil {
+			return err
+		}
+		if e.Type != block.EVENT_BLOCK {
+			continue
+		}
+		select {
+		case tips <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func sleep(ctx context.Context, d time.Duration) {
+	select {
+	case <-ctx.Done():
+	case <-time.After(d):
+	}
+}
+
+func (m *Miner) String() string {
+	address := m.address
+	if address == "" {
+		address = "the node's address"
+	}
+	return fmt.Sprintf("%d workers paying %s, refresh every %s", m.workers, address, m.refresh)
+}
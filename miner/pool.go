@@ -0,0 +1,101 @@
+package main
+
+import (
+	"blockchain/stratum"
+	"context"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+type share struct {
+	jobID string
+	nonce int
+}
+
+// mines for a stratum pool as worker, reconnecting until ctx ends
+func (m *Miner) RunPool(ctx context.Context, addr string, worker string) {
+	for ctx.Err() == nil {
+		if err := m.minePool(ctx, addr, worker); err != nil && ctx.Err() == nil {
+			log.Printf("ERROR: pool %s: %v", addr, err)
+		}
+		sleep(ctx, RETRY_INTERVAL)
+	}
+}
+
+func (m *Miner) minePool(ctx context.Context, addr string, worker string) error {
+	c, err := stratum.Dial(addr)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	sub, err := c.Subscribe()
+	if err != nil {
+		return err
+	}
+	if err := c.Authorize(worker, ""); err != nil {
+		return err
+	}
+	log.Printf("mining for %s as %s, extranonce %d", addr, worker, sub.Extranonce)
+
+	cancel := func() {}
+	defer func() { cancel() }()
+	shares := make(chan share, 64)
+	start := time.Now()
+	var accepted, rejected atomic.Int64
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case job, ok := <-c.Jobs():
+			if !ok {
+				return c.Err()
+			}
+			cancel()
+			if job.CleanJobs {
+				// shares of the last tip would only come back stale
+				drain(shares)
+			}
+			t, err := job.Template(c.Difficulty())
+			if err != nil {
+				log.Printf("ERROR: job %s: %v", job.JobID, err)
+				continue
+			}
+			jobCtx, jobCancel := context.WithCancel(ctx)
+			cancel = jobCancel
+			jobID := job.JobID
+			for i := 0; i < m.workers; i++ {
+				go m.work(jobCtx, t, stratum.FirstNonce(sub.Extranonce)+i, func(nonce int) {
+					select {
+					case shares <- share{jobID: jobID, nonce: nonce}:
+					default:
+					}
+				})
+			}
+			log.Printf("job %s at height %d, share difficulty %d, hash_rate=%.0f/s accepted=%d rejected=%d",
+				job.JobID, job.Height, t.Difficulty, float64(m.hashes.Load())/time.Since(start).Seconds(), accepted.Load(), rejected.Load())
+		case s := <-shares:
+			// submitted without waiting for the answer to the last one
+			go func() {
+				if err := c.Submit(worker, s.jobID, s.nonce); err != nil {
+					rejected.Add(1)
+					if !stratum.IsCode(err, stratum.ERR_JOB_NOT_FOUND) {
+						log.Printf("share %d of job %s rejected: %v", s.nonce, s.jobID, err)
+					}
+					return
+				}
+				accepted.Add(1)
+			}()
+		}
+	}
+}
+
+func drain(shares chan share) {
+	for {
+		select {
+		case <-shares:
+		default:
+			return
+		}
+	}
+}
@@ -0,0 +1,15 @@
+package main
+
+import (
+	"blockchain/blockchain_server"
+)
+
+var nodeCommands = map[string]*command{
+	"run": {args: "[node flags]", short: "run a node in the foreground, -h lists its flags", run: nodeRun},
+}
+
+// files the node keeps go to the data directory
+func nodeRun(env *Env, args []string) error {
+	blockchain_server.Main("blockchain node run", args, env.Datadir)
+	return nil
+}
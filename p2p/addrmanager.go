@@ -0,0 +1,338 @@
+package p2p
+
+import (
+	"encoding/json"
+	"log"
+	"net"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// peers lose score when they misbehave, and are banned below this
+	BAN_THRESHOLD = -100
+	BAN_DURATION  = 24 * time.Hour
+	// an address is not dialed again sooner than this after a failure
+	RETRY_INTERVAL = time.Minute
+	MAX_ADDRESSES  = 1000
+	// addresses learned from one host, so a single peer can't fill the
+	// table
+	MAX_ADDRESSES_PER_SOURCE = 64
+	// an address not seen for this long makes room for new ones once the
+	// table is full, like one that never connected or misbehaved
+	ADDR_HORIZON = 30 * 24 * time.Hour
+	// a failed dial costs an address this much score, failures alone
+	// take it no lower than -MAX_DIAL_PENALTY
+	DIAL_PENALTY     = 1
+	MAX_DIAL_PENALTY = 10
+	// at most this many addresses are relayed from one addr message
+	MAX_ADDR_RELAY = 10
+)
+
+type KnownAddress struct {
+	Addr        string `json:"addr"`
+	Source      string `json:"source"`
+	Score       int    `json:"score"`
+	LastSeen    int64  `json:"last_seen"`
+	LastAttempt int64  `json:"last_attempt"`
+	LastSuccess int64  `json:"last_success"`
+	// dials that failed since the last success
+	Failures int `json:"failures"`
+}
+
+type peersFile struct {
+	Addresses []*KnownAddress  `json:"addresses"`
+	Banned    map[string]int64 `json:"banned"`
+}
+
+// keeps track of every peer address we've heard of, how well they behaved
+// and which hosts are banned. the list survives restarts in path
+type AddrManager struct {
+	path   string
+	addrs  map[string]*KnownAddress
+	banned map[string]int64 // host -> unix time the ban ends
+	// host -> misbehaviour score of the peers connected from it. kept
+	// apart from the table, the listen address a peer claims is its own
+	// choice and a new one would start it from zero
+	scores map[string]int
+	mux    sync.Mutex
+}
+
+func NewAddrManager(path string, seeds []string) *AddrManager {
+	am := &AddrManager{
+		path:   path,
+		addrs:  make(map[string]*KnownAddress),
+		banned: make(map[string]int64),
+		scores: make(map[string]int),
+	}
+	am.Add(seeds, "seed")
+	return am
+}
+
+func (am *AddrManager) Load() error {
+	if am.path == "" {
+		return nil
+	}
+	f, err := os.ReadFile(am.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var pf peersFile
+	if err := json.Unmarshal(f, &pf); err != nil {
+		return err
+	}
+	am.mux.Lock()
+	defer am.mux.Unlock()
+	for _, ka := range pf.Addresses {
+		am.addrs[ka.Addr] = ka
+	}
+	for host, until := range pf.Banned {
+		am.banned[host] = until
+	}
+	return nil
+}
+
+func (am *AddrManager) Save() error {
+	if am.path == "" {
+		return nil
+	}
+	am.mux.Lock()
+	pf := peersFile{Addresses: make([]*KnownAddress, 0, len(am.addrs)), Banned: am.banned}
+	for _, ka := range am.addrs {
+		pf.Addresses = append(pf.Addresses, ka)
+	}
+	m, err := json.MarshalIndent(pf, "", "  ")
+	am.mux.Unlock()
+	if err != nil {
+		return err
+	}
+	tmp := am.path + ".tmp"
+	if err := os.WriteFile(tmp, m, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, am.path)
+}
+
+// records addresses learned from source, returns the ones that were new.
+// a full table drops its least useful address for each, a peer can add
+// no more than MAX_ADDRESSES_PER_SOURCE
+func (am *AddrManager) Add(addrs []string, source string) []string {
+	am.mux.Lock()
+	defer am.mux.Unlock()
+	host := sourceHost(source)
+	fromSource := 0
+	if host != "" {
+		for _, ka := range am.addrs {
+			if sourceHost(ka.Source) == host {
+				fromSource++
+			}
+		}
+	}
+	added := make([]string, 0)
+	for _, addr := range addrs {
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			continue
+		}
+		if _, ok := am.addrs[addr]; ok {
+			continue
+		}
+		if host != "" && fromSource >= MAX_ADDRESSES_PER_SOURCE {
+			break
+		}
+		if len(am.addrs) >= MAX_ADDRESSES && !am.evict() {
+			break
+		}
+		am.addrs[addr] = &KnownAddress{Addr: addr, Source: source, LastSeen: time.Now().Unix()}
+		fromSource++
+		added = append(added, addr)
+	}
+	return added
+}
+
+// host of a peer an address was learned from, empty for the seeds and
+// the other sources that aren't peers
+func sourceHost(source string) string {
+	host, _, err := net.SplitHostPort(source)
+	if err != nil {
+		return ""
+	}
+	return host
+}
+
+// drops the worst address that never connected, misbehaved or went
+// unseen past ADDR_HORIZON, false if there is none. seeds and addresses
+// added through the api stay. with mux held
+func (am *AddrManager) evict() bool {
+	horizon := time.Now().Add(-ADDR_HORIZON).Unix()
+	var worst *KnownAddress
+	for _, ka := range am.addrs {
+		if ka.Source == "seed" || ka.Source == "api" {
+			continue
+		}
+		if ka.LastSuccess != 0 && ka.Score >= 0 && ka.LastSeen >= horizon {
+			continue
+		}
+		if worst == nil || ka.Score < worst.Score || (ka.Score == worst.Score && ka.LastSeen < worst.LastSeen) {
+			worst = ka
+		}
+	}
+	if worst == nil {
+		return false
+	}
+	delete(am.addrs, worst.Addr)
+	return true
+}
+
+func (am *AddrManager) Attempt(addr string) {
+	am.mux.Lock()
+	defer am.mux.Unlock()
+	if ka, ok := am.addrs[addr]; ok {
+		ka.LastAttempt = time.Now().Unix()
+	}
+}
+
+// a dial of addr that failed, it is tried after the others and isn't
+// handed to peers until it connects again
+func (am *AddrManager) Failed(addr string) {
+	am.mux.Lock()
+	defer am.mux.Unlock()
+	if ka, ok := am.addrs[addr]; ok {
+		ka.Failures++
+		if ka.Score > -MAX_DIAL_PENALTY {
+			ka.Score = max(ka.Score-DIAL_PENALTY, -MAX_DIAL_PENALTY)
+		}
+	}
+}
+
+// a connection to addr succeeded. addresses go into the table through
+// Add only, this updates one already there
+func (am *AddrManager) Good(addr string) {
+	am.mux.Lock()
+	defer am.mux.Unlock()
+	ka, ok := am.addrs[addr]
+	if !ok {
+		return
+	}
+	now := time.Now().Unix()
+	ka.LastSeen = now
+	ka.LastSuccess = now
+	ka.Failures = 0
+	if ka.Score < 0 {
+		ka.Score++
+	}
+}
+
+// lowers the score of the host a peer connected from, remote being the
+// address of the connection, returns true when that got the host banned.
+// the table addresses of the host are tried after the others
+func (am *AddrManager) Misbehaving(remote string, penalty int, reason string) bool {
+	host := sourceHost(remote)
+	if host == "" {
+		host = remote
+	}
+	am.mux.Lock()
+	defer am.mux.Unlock()
+	if _, ok := am.scores[host]; !ok && len(am.scores) >= MAX_ADDRESSES {
+		am.forgetScore()
+	}
+	am.scores[host] -= penalty
+	score := am.scores[host]
+	for _, ka := range am.addrs {
+		if sourceHost(ka.Addr) == host {
+			ka.Score -= penalty
+		}
+	}
+	log.Printf("p2p: %s misbehaving (%s), score %d", remote, reason, score)
+	if score > BAN_THRESHOLD {
+		return false
+	}
+	delete(am.scores, host)
+	am.banned[host] = time.Now().Add(BAN_DURATION).Unix()
+	log.Printf("p2p: banned %s until %s", host, time.Unix(am.banned[host], 0))
+	return true
+}
+
+// drops the score of the host that misbehaved least, with mux held
+func (am *AddrManager) forgetScore() {
+	best := ""
+	for host, score := range am.scores {
+		if best == "" || score > am.scores[best] {
+			best = host
+		}
+	}
+	delete(am.scores, best)
+}
+
+func (am *AddrManager) Ban(host string, d time.Duration) {
+	am.mux.Lock()
+	defer am.mux.Unlock()
+	am.banned[host] = time.Now().Add(d).Unix()
+}
+
+func (am *AddrManager) IsBanned(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	am.mux.Lock()
+	defer am.mux.Unlock()
+	until, ok := am.banned[host]
+	if !ok {
+		return false
+	}
+	if time.Now().Unix() >= until {
+		delete(am.banned, host)
+		return false
+	}
+	return true
+}
+
+// up to n dialable addresses not in exclude, best scored and most
+// recently seen first
+func (am *AddrManager) Candidates(n int, exclude map[string]bool) []string {
+	if n <= 0 {
+		return nil
+	}
+	am.mux.Lock()
+	list := make([]KnownAddress, 0)
+	retry := time.Now().Add(-RETRY_INTERVAL).Unix()
+	for _, ka := range am.addrs {
+		if exclude[ka.Addr] || ka.LastAttempt > retry {
+			continue
+		}
+		list = append(list, *ka)
+	}
+	am.mux.Unlock()
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Score != list[j].Score {
+			return list[i].Score > list[j].Score
+		}
+		return list[i].LastSeen > list[j].LastSeen
+	})
+	addrs := make([]string, 0, n)
+	for _, ka := range list {
+		if len(addrs) == n {
+			break
+		}
+		if !am.IsBanned(ka.Addr) {
+			addrs = append(addrs, ka.Addr)
+		}
+	}
+	return addrs
+}
+
+func (am *AddrManager) Addresses() []KnownAddress {
+	am.mux.Lock()
+	defer am.mux.Unlock()
+	list := make([]KnownAddress, 0, len(am.addrs))
+	for _, ka := range am.addrs {
+		list = append(list, *ka)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Addr < list[j].Addr })
+	return list
+}
@@ -0,0 +1,114 @@
+package p2p
+
+import (
+	"fmt"
+	"testing"
+)
+
+func addresses(from int, n int) []string {
+	addrs := make([]string, n)
+	for i := range addrs {
+		addrs[i] = fmt.Sprintf("10.0.%d.%d:6000", (from+i)/256, (from+i)%256)
+	}
+	return addrs
+}
+
+func TestAddrManagerSourceLimit(t *testing.T) {
+	am := NewAddrManager("", nil)
+	added := am.Add(addresses(0, MAX_ADDRESSES_PER_SOURCE+10), "192.0.2.1:6000")
+	if len(added) != MAX_ADDRESSES_PER_SOURCE {
+		t.Errorf("one peer added %d addresses, want %d", len(added), MAX_ADDRESSES_PER_SOURCE)
+	}
+	// same host, another port
+	if added := am.Add(addresses(1000, 1), "192.0.2.1:6001"); len(added) != 0 {
+		t.Errorf("the peer added %v past its limit", added)
+	}
+	if added := am.Add(addresses(1000, 1), "192.0.2.2:6000"); len(added) != 1 {
+		t.Error("another peer couldn't add an address")
+	}
+}
+
+func TestAddrManagerFullTable(t *testing.T) {
+	am := NewAddrManager("", nil)
+	for i := 0; i < MAX_ADDRESSES; i += MAX_ADDRESSES_PER_SOURCE {
+		n := min(MAX_ADDRESSES_PER_SOURCE, MAX_ADDRESSES-i)
+		am.Add(addresses(i, n), fmt.Sprintf("192.0.2.%d:6000", i/MAX_ADDRESSES_PER_SOURCE))
+	}
+	if n := len(am.Addresses()); n != MAX_ADDRESSES {
+		t.Fatalf("%d addresses, want a full table", n)
+	}
+	failed := addresses(0, 1)[0]
+	am.Failed(failed)
+	added := am.Add(addresses(5000, 1), "198.51.100.1:6000")
+	if len(added) != 1 {
+		t.Fatal("a full table takes no new addresses")
+	}
+	for _, ka := range am.Addresses() {
+		if ka.Addr == failed {
+			t.Error("the address that failed to dial was kept over the others")
+		}
+	}
+
+	// every address connected, none is worth dropping
+	for _, ka := range am.Addresses() {
+		am.Good(ka.Addr)
+	}
+	if added := am.Add(addresses(6000, 1), "198.51.100.2:6000"); len(added) != 0 {
+		t.Errorf("%v replaced a good address", added)
+	}
+}
+
+func TestAddrManagerFailedDials(t *testing.T) {
+	am := NewAddrManager("", []string{"192.0.2.1:6000", "192.0.2.2:6000"})
+	for i := 0; i < 2*MAX_DIAL_PENALTY; i++ {
+		am.Failed("192.0.2.1:6000")
+	}
+	var ka KnownAddress
+	for _, a := range am.Addresses() {
+		if a.Addr == "192.0.2.1:6000" {
+			ka = a
+		}
+	}
+	if ka.Score != -MAX_DIAL_PENALTY || ka.Failures != 2*MAX_DIAL_PENALTY {
+		t.Errorf("score %d after %d failures, want %d", ka.Score, ka.Failures, -MAX_DIAL_PENALTY)
+	}
+	if am.IsBanned(ka.Addr) {
+		t.Error("failed dials got the address banned")
+	}
+	if c := am.Candidates(2, nil); len(c) != 2 || c[0] != "192.0.2.2:6000" {
+		t.Errorf("candidates %v, want the failing address last", c)
+	}
+	am.Good(ka.Addr)
+	for _, a := range am.Addresses() {
+		if a.Addr == ka.Addr && a.Failures != 0 {
+			t.Errorf("%d failures after a connection", a.Failures)
+		}
+	}
+}
+
+func TestAddrManagerMisbehavingHost(t *testing.T) {
+	am := NewAddrManager("", []string{"192.0.2.1:6000"})
+	// the same host on a new port each time it connects
+	banned := false
+	for i := 0; !banned && i <= -BAN_THRESHOLD; i++ {
+		banned = am.Misbehaving(fmt.Sprintf("192.0.2.1:%d", 40000+i), 1, "test")
+		if banned && i != -BAN_THRESHOLD-1 {
+			t.Fatalf("banned after %d penalties, want %d", i+1, -BAN_THRESHOLD)
+		}
+	}
+	if !banned || !am.IsBanned("192.0.2.1:6001") {
+		t.Error("a host changing ports was never banned")
+	}
+	if n := len(am.Addresses()); n != 1 {
+		t.Errorf("%d addresses after the penalties, want only the seed", n)
+	}
+	if ka := am.Addresses()[0]; ka.Score >= 0 {
+		t.Errorf("the seed on the banned host kept score %d", ka.Score)
+	}
+
+	am.Good("198.51.100.1:6000")
+	am.Misbehaving("198.51.100.2:6000", 1, "test")
+	if n := len(am.Addresses()); n != 1 {
+		t.Errorf("%d addresses, Good and Misbehaving added some", n)
+	}
+}
@@ -0,0 +1,244 @@
+package p2p
+
+import (
+	"blockchain/block"
+	"crypto/sha256"
+	"encoding/binary"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+const (
+	// bytes of the salted txid kept as short id
+	SHORT_ID_SIZE = 6
+	// a compact block still missing transactions after this is dropped
+	COMPACT_TIMEOUT = 20 * time.Second
+)
+
+// transaction sent in full inside a compact block, the coinbase always is
+// since no peer can have it in its pool
+type PrefilledTx struct {
+	Index int                `json:"index"`
+	Tx    *block.Transaction `json:"tx"`
+}
+
+// a block announced as its header and short ids of its transactions, the
+// receiver rebuilds it from its own pool
+type CompactBlockMsg struct {
+	Header    *block.BlockHeader `json:"header"`
+	Salt      uint64             `json:"salt"`
+	ShortIDs  []uint64           `json:"short_ids"`
+	Prefilled []PrefilledTx      `json:"prefilled"`
+}
+
+type GetBlockTxnMsg struct {
+	BlockHash [32]byte `json:"block_hash"`
+	Indexes   []int    `json:"indexes"`
+}
+
+type BlockTxnMsg struct {
+	BlockHash    [32]byte             `json:"block_hash"`
+	Transactions []*block.Transaction `json:"transactions"`
+}
+
+// short ids are salted per block so collisions can't be planned ahead
+func shortID(salt uint64, txid [32]byte) uint64 {
+	buf := make([]byte, 8, 8+32)
+	binary.BigEndian.PutUint64(buf, salt)
+	h := sha256.Sum256(append(buf, txid[:]...))
+	id := make([]byte, 8)
+	copy(id[8-SHORT_ID_SIZE:], h[:SHORT_ID_SIZE])
+	return binary.BigEndian.Uint64(id)
+}
+
+func NewCompactBlock(b *block.Block) *CompactBlockMsg {
+	c := &CompactBlockMsg{
+		Header:    b.Header(),
+		Salt:      rand.Uint64(),
+		ShortIDs:  make([]uint64, 0, len(b.Transactions)),
+		Prefilled: make([]PrefilledTx, 0),
+	}
+	for i, t := range b.Transactions {
+		if t.SenderBlockchainAddress == block.MINING_SENDER {
+			c.Prefilled = append(c.Prefilled, PrefilledTx{i, t})
+			continue
+		}
+		c.ShortIDs = append(c.ShortIDs, shortID(c.Salt, t.Hash()))
+	}
+	return c
+}
+
+// a compact block waiting for the transactions we didn't have
+type partialBlock struct {
+	header  *block.BlockHeader
+	txns    []*block.Transaction
+	missing []int
+	peer    *Peer
+	created time.Time
+}
+
+func (pb *partialBlock) block() *block.Block {
+	h := pb.header
+	return block.NewBlock(h.Nonce, h.PrevHash, h.Timestamp, pb.txns)
+}
+
+type compactRelay struct {
+	server  *Server
+	mux     sync.Mutex
+	pending map[[32]byte]*partialBlock
+}
+
+func newCompactRelay(s *Server) *compactRelay {
+	return &compactRelay{server: s, pending: make(map[[32]byte]*partialBlock)}
+}
+
+// places prefilled transactions and matches the short ids against our pool,
+// returns nil when the message doesn't add up
+func (cr *compactRelay) reconstruct(c *CompactBlockMsg) *partialBlock {
+	total := len(c.ShortIDs) + len(c.Prefilled)
+	pb := &partialBlock{header: c.Header, txns: make([]*block.Transaction, total), created: time.Now()}
+	for _, pt := range c.Prefilled {
+		if pt.Index < 0 || pt.Index >= total || pt.Tx == nil || pb.txns[pt.Index] != nil {
+			return nil
+		}
+		pb.txns[pt.Index] = pt.Tx
+	}
+	pool := make(map[uint64]*block.Transaction)
+	for _, t := range cr.server.bc.CopyTransactionPool() {
+		pool[shortID(c.Salt, t.Hash())] = t
+	}
+	next := 0
+	for i := range pb.txns {
+		if pb.txns[i] != nil {
+			continue
+		}
+		if t, ok := pool[c.ShortIDs[next]]; ok {
+			pb.txns[i] = t
+		} else {
+			pb.missing = append(pb.missing, i)
+		}
+		next++
+	}
+	return pb
+}
+
+func (cr *compactRelay) handleCompactBlock(p *Peer, c *CompactBlockMsg) bool {
+	if c.Header == nil {
+		return false
+	}
+	bc := cr.server.bc
+	hash := c.Header.Hash()
+	if bc.GetBlock(hash) != nil {
+		return true
+	}
+	if c.Header.PrevHash != bc.LastBlock().Hash() {
+		if bc.GetBlock(c.Header.PrevHash) == nil {
+			cr.server.syncer.maybeStart(p, bc.Height()+1)
+		} else {
+			// a fork, not worth rebuilding from the pool
+			cr.getBlock(p, hash)
+		}
+		return true
+	}
+	// the proof of work is checked before anything is rebuilt or requested
+	if !bc.ValidHeader(c.Header, bc.LastBlock().Header()) {
+		return false
+	}
+	pb := cr.reconstruct(c)
+	if pb == nil {
+		return false
+	}
+	if len(pb.missing) == 0 {
+		cr.complete(p, hash, pb)
+		return true
+	}
+	pb.peer = p
+	cr.mux.Lock()
+	cr.expire()
+	cr.pending[hash] = pb
+	cr.mux.Unlock()
+	log.Printf("p2p: compact block %x missing %d transactions, asking %s", hash[:8], len(pb.missing), p.Addr())
+	m, _ := NewMessage(CMD_GETBLKTXN, GetBlockTxnMsg{hash, pb.missing})
+	p.Send(m)
+	return true
+}
+
+func (cr *compactRelay) handleBlockTxn(p *Peer, msg *BlockTxnMsg) bool {
+	cr.mux.Lock()
+	pb, ok := cr.pending[msg.BlockHash]
+	if ok && pb.peer == p {
+		delete(cr.pending, msg.BlockHash)
+	}
+	cr.mux.Unlock()
+	if !ok || pb.peer != p {
+		return true
+	}
+	if len(msg.Transactions) != len(pb.missing) {
+		return false
+	}
+	for _, t := range msg.Transactions {
+		if t == nil {
+			return false
+		}
+	}
+	for i, idx := range pb.missing {
+		pb.txns[idx] = msg.Transactions[i]
+	}
+	cr.complete(p, msg.BlockHash, pb)
+	return true
+}
+
+// adds the rebuilt block, falling back to the full block if a short id
+// matched the wrong pool transaction
+func (cr *compactRelay) complete(p *Peer, hash [32]byte, pb *partialBlock) {
+	b := pb.block()
+	if b.Hash() != hash {
+		log.Printf("p2p: compact block %x didn't rebuild, fetching it in full", hash[:8])
+		cr.getBlock(p, hash)
+		return
+	}
+	if !cr.server.bc.AddBlock(b) && b.PrevHash == cr.server.bc.LastBlock().Hash() {
+		cr.server.misbehaving(p, PENALTY_INVALID_BLOCK, "invalid block")
+	}
+}
+
+func (cr *compactRelay) getBlock(p *Peer, hash [32]byte) {
+	m, _ := NewMessage(CMD_GETDATA, InvMsg{[]InvVect{{INV_TYPE_BLOCK, hash}}})
+	p.Send(m)
+}
+
+func (cr *compactRelay) expire() {
+	for hash, pb := range cr.pending {
+		if time.Since(pb.created) > COMPACT_TIMEOUT {
+			delete(cr.pending, hash)
+		}
+	}
+}
+
+func (cr *compactRelay) peerGone(p *Peer) {
+	cr.mux.Lock()
+	defer cr.mux.Unlock()
+	for hash, pb := range cr.pending {
+		if pb.peer == p {
+			delete(cr.pending, hash)
+		}
+	}
+}
+
+func (s *Server) handleGetBlockTxn(p *Peer, msg *GetBlockTxnMsg) {
+	b := s.bc.GetBlock(msg.BlockHash)
+	if b == nil || b.Pruned() {
+		return
+	}
+	txns := make([]*block.Transaction, 0, len(msg.Indexes))
+	for _, i := range msg.Indexes {
+		if i < 0 || i >= len(b.Transactions) {
+			return
+		}
+		txns = append(txns, b.Transactions[i])
+	}
+	m, _ := NewMessage(CMD_BLKTXN, BlockTxnMsg{msg.BlockHash, txns})
+	p.Send(m)
+}
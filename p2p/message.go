@@ -0,0 +1,173 @@
+package p2p
+
+import (
+	"blockchain/block"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// every message on the wire is a fixed 24 byte header followed by the payload:
+//
+//	magic    uint32   network magic, big endian
+//	command  [12]byte ascii, zero padded
+//	length   uint32   payload length, big endian
+//	checksum [4]byte  first 4 bytes of sha256(sha256(payload))
+//
+// payloads are the JSON encoding of the *Msg types below
+const (
+	MAGIC            uint32 = 0xb10c0c4a
+	HEADER_SIZE             = 24
+	COMMAND_SIZE            = 12
+	MAX_PAYLOAD_SIZE        = 32 * 1024 * 1024
+	PROTOCOL_VERSION        = 1
+	// version and verack are tiny, nothing bigger is read from a peer
+	// that hasn't finished the handshake
+	MAX_HANDSHAKE_PAYLOAD = 4 * 1024
+)
+
+const (
+	CMD_VERSION    = "version"
+	CMD_VERACK     = "verack"
+	CMD_INV        = "inv"
+	CMD_GETDATA    = "getdata"
+	CMD_NOTFOUND   = "notfound"
+	CMD_BLOCK      = "block"
+	CMD_TX         = "tx"
+	CMD_PING       = "ping"
+	CMD_PONG       = "pong"
+	CMD_ADDR       = "addr"
+	CMD_GETADDR    = "getaddr"
+	CMD_GETHEADERS = "getheaders"
+	CMD_HEADERS    = "headers"
+	CMD_CMPCTBLOCK = "cmpctblock"
+	CMD_GETBLKTXN  = "getblocktxn"
+	CMD_BLKTXN     = "blocktxn"
+)
+
+const (
+	INV_TYPE_TX    = 1
+	INV_TYPE_BLOCK = 2
+)
+
+var ErrBadChecksum = errors.New("bad checksum")
+
+type Message struct {
+	Command string
+	Payload []byte
+}
+
+type VersionMsg struct {
+	Version     int      `json:"version"`
+	ChainID     string   `json:"chain_id"`
+	GenesisHash [32]byte `json:"genesis_hash"`
+	Height      int      `json:"height"`
+	ListenPort  uint16   `json:"listen_port"`
+	Nonce       uint64   `json:"nonce"`
+	Timestamp   int64    `json:"timestamp"`
+	// a pruned node only serves the blocks from PruneHeight on, it
+	// goes up as the node's chain grows
+	Pruned      bool `json:"pruned,omitempty"`
+	PruneHeight int  `json:"prune_height,omitempty"`
+}
+
+type InvVect struct {
+	Type int      `json:"type"`
+	Hash [32]byte `json:"hash"`
+}
+
+// used by inv, getdata and notfound
+type InvMsg struct {
+	Items []InvVect `json:"items"`
+}
+
+type PingMsg struct {
+	Nonce uint64 `json:"nonce"`
+}
+
+type AddrMsg struct {
+	Addrs []string `json:"addrs"`
+}
+
+func NewMessage(command string, payload interface{}) (*Message, error) {
+	if len(command) > COMMAND_SIZE {
+		return nil, fmt.Errorf("command %q too long", command)
+	}
+	var p []byte
+	if payload != nil {
+		m, err := json.Marshal(payload)
+		if err != nil {
+			return nil, err
+		}
+		p = m
+	}
+	return &Message{command, p}, nil
+}
+
+func (m *Message) Decode(v interface{}) error {
+	return json.Unmarshal(m.Payload, v)
+}
+
+func checksum(payload []byte) [4]byte {
+	first := sha256.Sum256(payload)
+	second := sha256.Sum256(first[:])
+	var c [4]byte
+	copy(c[:], second[:4])
+	return c
+}
+
+func WriteMessage(w io.Writer, m *Message) error {
+	if len(m.Payload) > MAX_PAYLOAD_SIZE {
+		return fmt.Errorf("payload of %d bytes too large", len(m.Payload))
+	}
+	header := make([]byte, HEADER_SIZE)
+	binary.BigEndian.PutUint32(header[0:4], MAGIC)
+	copy(header[4:16], m.Command)
+	binary.BigEndian.PutUint32(header[16:20], uint32(len(m.Payload)))
+	c := checksum(m.Payload)
+	copy(header[20:24], c[:])
+	if _, err := w.Write(append(header, m.Payload...)); err != nil {
+		return err
+	}
+	return nil
+}
+
+func ReadMessage(r io.Reader) (*Message, error) {
+	return readMessage(r, MAX_PAYLOAD_SIZE)
+}
+
+// same as ReadMessage for payloads of up to max bytes
+func readMessage(r io.Reader, max uint32) (*Message, error) {
+	header := make([]byte, HEADER_SIZE)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	if binary.BigEndian.Uint32(header[0:4]) != MAGIC {
+		return nil, errors.New("bad magic")
+	}
+	command := string(bytes.TrimRight(header[4:16], "\x00"))
+	length := binary.BigEndian.Uint32(header[16:20])
+	if length > max {
+		return nil, fmt.Errorf("payload of %d bytes too large", length)
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+	if c := checksum(payload); !bytes.Equal(c[:], header[20:24]) {
+		return nil, ErrBadChecksum
+	}
+	return &Message{command, payload}, nil
+}
+
+func blockInv(b *block.Block) InvVect {
+	return InvVect{INV_TYPE_BLOCK, b.Hash()}
+}
+
+func txInv(t *block.Transaction) InvVect {
+	return InvVect{INV_TYPE_TX, t.Hash()}
+}
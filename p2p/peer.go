@@ -0,0 +1,215 @@
+package p2p
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	HANDSHAKE_TIMEOUT = 10 * time.Second
+	WRITE_TIMEOUT     = 10 * time.Second
+	// a peer that sends nothing, not even a pong, for this long is dropped
+	IDLE_TIMEOUT  = 2 * time.Minute
+	PING_INTERVAL = 30 * time.Second
+	SEND_QUEUE    = 256
+)
+
+type Peer struct {
+	conn    net.Conn
+	inbound bool
+	// address we dialed, empty for inbound peers
+	dialAddr string
+	server   *Server
+	version  *VersionMsg
+	// lowest height the peer serves blocks from, raised by the Syncer
+	// when the peer answers notfound
+	pruneHeight int
+	send        chan *Message
+	quit        chan struct{}
+	once        sync.Once
+
+	mux       sync.Mutex
+	pingNonce uint64
+	pingSent  time.Time
+	latency   time.Duration
+}
+
+func newPeer(s *Server, conn net.Conn, inbound bool) *Peer {
+	return &Peer{
+		conn:    conn,
+		inbound: inbound,
+		server:  s,
+		send:    make(chan *Message, SEND_QUEUE),
+		quit:    make(chan struct{}),
+	}
+}
+
+func (p *Peer) Addr() string {
+	return p.conn.RemoteAddr().String()
+}
+
+// address other nodes can dial this peer on
+func (p *Peer) ListenAddr() string {
+	host, _, _ := net.SplitHostPort(p.Addr())
+	if p.version == nil {
+		return p.Addr()
+	}
+	return net.JoinHostPort(host, fmt.Sprint(p.version.ListenPort))
+}
+
+func (p *Peer) Inbound() bool {
+	return p.inbound
+}
+
+func (p *Peer) Version() *VersionMsg {
+	return p.version
+}
+
+func (p *Peer) Latency() time.Duration {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	return p.latency
+}
+
+// exchanges version and verack, synchronously, before any other message
+func (p *Peer) handshake() error {
+	p.conn.SetDeadline(time.Now().Add(HANDSHAKE_TIMEOUT))
+	defer p.conn.SetDeadline(time.Time{})
+
+	v, _ := NewMessage(CMD_VERSION, p.server.versionMsg())
+	if err := WriteMessage(p.conn, v); err != nil {
+		return err
+	}
+	gotVersion, gotVerack := false, false
+	for !gotVersion || !gotVerack {
+		m, err := readMessage(p.conn, MAX_HANDSHAKE_PAYLOAD)
+		if err != nil {
+			return err
+		}
+		switch m.Command {
+		case CMD_VERSION:
+			var their VersionMsg
+			if err := m.Decode(&their); err != nil {
+				return err
+			}
+			if err := p.server.checkVersion(&their); err != nil {
+				return err
+			}
+			p.version = &their
+			p.pruneHeight = their.PruneHeight
+			gotVersion = true
+			ack, _ := NewMessage(CMD_VERACK, nil)
+			if err := WriteMessage(p.conn, ack); err != nil {
+				return err
+			}
+		case CMD_VERACK:
+			gotVerack = true
+		default:
+			return fmt.Errorf("unexpected %s before handshake", m.Command)
+		}
+	}
+	return nil
+}
+
+// the server added one to its wait group for the read loop
+func (p *Peer) start() {
+	go func() {
+		defer p.server.wg.Done()
+		p.readLoop()
+	}()
+	go p.writeLoop()
+	go p.pingLoop()
+}
+
+// queues a message, dropping it if the peer can't keep up
+func (p *Peer) Send(m *Message) {
+	select {
+	case p.send <- m:
+	case <-p.quit:
+	default:
+		log.Printf("p2p: send queue of %s full, dropping %s", p.Addr(), m.Command)
+	}
+}
+
+func (p *Peer) Disconnect() {
+	p.once.Do(func() {
+		close(p.quit)
+		p.conn.Close()
+		p.server.removePeer(p)
+	})
+}
+
+func (p *Peer) readLoop() {
+	defer p.Disconnect()
+	for {
+		p.conn.SetReadDeadline(time.Now().Add(IDLE_TIMEOUT))
+		m, err := ReadMessage(p.conn)
+		if errors.Is(err, ErrBadChecksum) {
+			p.server.misbehaving(p, PENALTY_PROTOCOL, err.Error())
+			return
+		}
+		if err != nil {
+			select {
+			case <-p.quit:
+			default:
+				log.Printf("p2p: read from %s: %v", p.Addr(), err)
+			}
+			return
+		}
+		if err := p.server.handleMessage(p, m); err != nil {
+			log.Printf("p2p: %s from %s: %v", m.Command, p.Addr(), err)
+			p.server.misbehaving(p, PENALTY_PROTOCOL, err.Error())
+			return
+		}
+	}
+}
+
+func (p *Peer) writeLoop() {
+	defer p.Disconnect()
+	for {
+		select {
+		case m := <-p.send:
+			p.conn.SetWriteDeadline(time.Now().Add(WRITE_TIMEOUT))
+			if err := WriteMessage(p.conn, m); err != nil {
+				log.Printf("p2p: write to %s: %v", p.Addr(), err)
+				return
+			}
+		case <-p.quit:
+			return
+		}
+	}
+}
+
+func (p *Peer) pingLoop() {
+	ticker := time.NewTicker(PING_INTERVAL)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			p.mux.Lock()
+			p.pingNonce = rand.Uint64()
+			p.pingSent = time.Now()
+			nonce := p.pingNonce
+			p.mux.Unlock()
+			m, _ := NewMessage(CMD_PING, PingMsg{nonce})
+			p.Send(m)
+		case <-p.quit:
+			return
+		}
+	}
+}
+
+// a pong to an earlier ping, answered late, is ignored
+func (p *Peer) handlePong(pong *PingMsg) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	if pong.Nonce != p.pingNonce {
+		return
+	}
+	p.latency = time.Since(p.pingSent)
+}
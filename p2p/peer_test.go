@@ -0,0 +1,38 @@
+package p2p
+
+import (
+	"blockchain/block"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHandshakePayloadLimit(t *testing.T) {
+	s := NewServer(testChain(&block.Genesis{ChainID: "test", Timestamp: 1, Difficulty: 1, MiningReward: 1}, 0), 0, nil)
+	conn, other := net.Pipe()
+	defer conn.Close()
+	defer other.Close()
+	go func() {
+		// our version first, then a payload a real version never needs
+		ReadMessage(other)
+		m, _ := NewMessage(CMD_VERSION, strings.Repeat("a", MAX_HANDSHAKE_PAYLOAD))
+		WriteMessage(other, m)
+	}()
+	err := newPeer(s, conn, true).handshake()
+	if err == nil || !strings.Contains(err.Error(), "too large") {
+		t.Errorf("handshake error %v, want a too large payload", err)
+	}
+}
+
+func TestStalePong(t *testing.T) {
+	p := &Peer{pingNonce: 2, pingSent: time.Now().Add(-time.Second)}
+	p.handlePong(&PingMsg{1})
+	if p.Latency() != 0 {
+		t.Error("latency taken from the pong to an earlier ping")
+	}
+	p.handlePong(&PingMsg{2})
+	if p.Latency() < time.Second {
+		t.Errorf("latency %v, want a second", p.Latency())
+	}
+}
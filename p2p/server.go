@@ -0,0 +1,536 @@
+package p2p
+
+import (
+	"blockchain/block"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	MAX_INBOUND  = 16
+	MAX_OUTBOUND = 8
+	// how often missing outbound connections are refilled
+	RECONNECT_INTERVAL = 30 * time.Second
+	// score lost for a malformed or unexpected message
+	PENALTY_PROTOCOL = 20
+	// score lost for a block that extends our tip but is invalid
+	PENALTY_INVALID_BLOCK = 50
+)
+
+// peer to peer node running next to the http api, it relays blocks and
+// transactions of bc to every connected peer
+type Server struct {
+	MaxInbound  int
+	MaxOutbound int
+
+	bc       *block.Blockchain
+	port     uint16
+	nonce    uint64
+	addrs    *AddrManager
+	listener net.Listener
+	peers    map[*Peer]bool
+	syncer   *Syncer
+	compact  *compactRelay
+	quit     chan struct{}
+	// the server's loops and the read loops of its peers, which handle
+	// the messages
+	wg  sync.WaitGroup
+	mux sync.Mutex
+}
+
+func NewServer(bc *block.Blockchain, port uint16, addrs *AddrManager) *Server {
+	s := &Server{
+		MaxInbound:  MAX_INBOUND,
+		MaxOutbound: MAX_OUTBOUND,
+		bc:          bc,
+		port:        port,
+		nonce:       rand.Uint64(),
+		addrs:       addrs,
+		peers:       make(map[*Peer]bool),
+		quit:        make(chan struct{}),
+	}
+	s.syncer = newSyncer(s)
+	s.compact = newCompactRelay(s)
+	return s
+}
+
+func (s *Server) SyncProgress() SyncProgress {
+	return s.syncer.Progress()
+}
+
+func (s *Server) AddrManager() *AddrManager {
+	return s.addrs
+}
+
+func (s *Server) GetPort() uint16 {
+	return s.port
+}
+
+func (s *Server) Start() error {
+	l, err := net.Listen("tcp", "0.0.0.0:"+strconv.Itoa(int(s.port)))
+	if err != nil {
+		return err
+	}
+	s.listener = l
+	// new blocks go out compact, peers already have most of the
+	// transactions in their pools
+	s.bc.OnBlock(func(b *block.Block, height int) {
+		m, _ := NewMessage(CMD_CMPCTBLOCK, NewCompactBlock(b))
+		s.Broadcast(m)
+	})
+	s.bc.OnTransaction(func(t *block.Transaction, tip int) {
+		m, _ := NewMessage(CMD_INV, InvMsg{[]InvVect{txInv(t)}})
+		s.Broadcast(m)
+	})
+	log.Printf("p2p: listening on %s", l.Addr())
+	s.wg.Add(3)
+	go func() {
+		defer s.wg.Done()
+		s.acceptLoop()
+	}()
+	go func() {
+		defer s.wg.Done()
+		s.connectLoop()
+	}()
+	go func() {
+		defer s.wg.Done()
+		s.syncer.run(s.quit)
+	}()
+	return nil
+}
+
+// keeps the outbound connections filled from the known addresses
+func (s *Server) connectLoop() {
+	ticker := time.NewTicker(RECONNECT_INTERVAL)
+	defer ticker.Stop()
+	for {
+		s.fillOutbound()
+		if err := s.addrs.Save(); err != nil {
+			log.Printf("p2p: saving peers: %v", err)
+		}
+		select {
+		case <-ticker.C:
+		case <-s.quit:
+			return
+		}
+	}
+}
+
+func (s *Server) fillOutbound() {
+	connected := make(map[string]bool)
+	outbound := 0
+	for _, p := range s.Peers() {
+		connected[p.ListenAddr()] = true
+		connected[p.dialAddr] = true
+		if !p.inbound {
+			outbound++
+		}
+	}
+	for _, addr := range s.addrs.Candidates(s.MaxOutbound-outbound, connected) {
+		go s.Connect(addr)
+	}
+}
+
+func (s *Server) count(inbound bool) int {
+	n := 0
+	for _, p := range s.Peers() {
+		if p.inbound == inbound {
+			n++
+		}
+	}
+	return n
+}
+
+func (s *Server) acceptLoop() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			log.Printf("p2p: accept: %v", err)
+			continue
+		}
+		if s.addrs.IsBanned(conn.RemoteAddr().String()) || s.count(true) >= s.MaxInbound {
+			conn.Close()
+			continue
+		}
+		go s.addPeer(conn, "", true)
+	}
+}
+
+func (s *Server) Connect(addr string) error {
+	if s.addrs.IsBanned(addr) {
+		return errors.New("address is banned")
+	}
+	if s.count(false) >= s.MaxOutbound {
+		return errors.New("too many outbound connections")
+	}
+	s.addrs.Attempt(addr)
+	conn, err := net.DialTimeout("tcp", addr, HANDSHAKE_TIMEOUT)
+	if err != nil {
+		s.addrs.Failed(addr)
+		return err
+	}
+	return s.addPeer(conn, addr, false)
+}
+
+func (s *Server) addPeer(conn net.Conn, dialAddr string, inbound bool) error {
+	p := newPeer(s, conn, inbound)
+	p.dialAddr = dialAddr
+	if err := p.handshake(); err != nil {
+		log.Printf("p2p: handshake with %s: %v", p.Addr(), err)
+		conn.Close()
+		if !inbound {
+			s.addrs.Failed(dialAddr)
+		}
+		return err
+	}
+	s.mux.Lock()
+	select {
+	case <-s.quit:
+		s.mux.Unlock()
+		conn.Close()
+		return errors.New("server is stopping")
+	default:
+	}
+	s.peers[p] = true
+	// under the lock so it comes before Stop closes quit and waits
+	s.wg.Add(1)
+	s.mux.Unlock()
+	log.Printf("p2p: connected to %s (inbound=%v, height=%d)", p.Addr(), inbound, p.version.Height)
+	p.start()
+	if inbound {
+		s.addrs.Add([]string{p.ListenAddr()}, p.Addr())
+	} else {
+		s.addrs.Add([]string{dialAddr}, "connection")
+		s.addrs.Good(dialAddr)
+		getaddr, _ := NewMessage(CMD_GETADDR, nil)
+		p.Send(getaddr)
+	}
+	s.syncer.maybeStart(p, p.version.Height)
+	return nil
+}
+
+// penalizes the peer, disconnecting it if it got banned
+func (s *Server) misbehaving(p *Peer, penalty int, reason string) {
+	if s.addrs.Misbehaving(p.Addr(), penalty, reason) {
+		p.Disconnect()
+	}
+}
+
+func (s *Server) removePeer(p *Peer) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.peers[p] {
+		delete(s.peers, p)
+		log.Printf("p2p: disconnected from %s", p.Addr())
+		go s.syncer.peerGone(p)
+		go s.compact.peerGone(p)
+	}
+}
+
+func (s *Server) Peers() []*Peer {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	peers := make([]*Peer, 0, len(s.peers))
+	for p := range s.peers {
+		peers = append(peers, p)
+	}
+	return peers
+}
+
+type PeerInfo struct {
+	Addr       string `json:"addr"`
+	ListenAddr string `json:"listen_addr"`
+	Inbound    bool   `json:"inbound"`
+	Height     int    `json:"height"`
+	Pruned     bool   `json:"pruned"`
+	// lowest height the peer said it serves blocks from
+	PruneHeight int   `json:"prune_height"`
+	LatencyMs   int64 `json:"latency_ms"`
+}
+
+// the connected peers as the api shows them, sorted by address
+func (s *Server) PeerInfo() []*PeerInfo {
+	info := make([]*PeerInfo, 0)
+	for _, p := range s.Peers() {
+		pi := &PeerInfo{
+			Addr:       p.Addr(),
+			ListenAddr: p.ListenAddr(),
+			Inbound:    p.Inbound(),
+			LatencyMs:  p.Latency().Milliseconds(),
+		}
+		if v := p.Version(); v != nil {
+			pi.Height = v.Height
+			pi.Pruned = v.Pruned
+			pi.PruneHeight = v.PruneHeight
+		}
+		info = append(info, pi)
+	}
+	sort.Slice(info, func(i, j int) bool { return info[i].Addr < info[j].Addr })
+	return info
+}
+
+func (s *Server) Broadcast(m *Message) {
+	for _, p := range s.Peers() {
+		p.Send(m)
+	}
+}
+
+// stops accepting and dialing peers, disconnects every peer and saves
+// the known addresses once the messages being handled are done
+func (s *Server) Stop(ctx context.Context) error {
+	s.mux.Lock()
+	close(s.quit)
+	s.mux.Unlock()
+	if s.listener != nil {
+		s.listener.Close()
+	}
+	for _, p := range s.Peers() {
+		p.Disconnect()
+	}
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	var err error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	return errors.Join(err, s.addrs.Save())
+}
+
+func (s *Server) versionMsg() *VersionMsg {
+	return &VersionMsg{
+		Version:     PROTOCOL_VERSION,
+		ChainID:     s.bc.ChainID,
+		GenesisHash: s.bc.Genesis().Hash(),
+		Height:      s.bc.Height(),
+		ListenPort:  s.port,
+		Nonce:       s.nonce,
+		Timestamp:   time.Now().Unix(),
+		Pruned:      s.bc.Pruning() > 0,
+		PruneHeight: s.bc.PruneHeight(),
+	}
+}
+
+func (s *Server) checkVersion(v *VersionMsg) error {
+	if v.Nonce == s.nonce {
+		return errors.New("connected to self")
+	}
+	if v.Version != PROTOCOL_VERSION {
+		return fmt.Errorf("unsupported protocol version %d", v.Version)
+	}
+	if v.ChainID != s.bc.ChainID || v.GenesisHash != s.bc.Genesis().Hash() {
+		return fmt.Errorf("peer is on chain %q", v.ChainID)
+	}
+	return nil
+}
+
+func (s *Server) handleMessage(p *Peer, m *Message) error {
+	switch m.Command {
+	case CMD_PING:
+		var ping PingMsg
+		if err := m.Decode(&ping); err != nil {
+			return err
+		}
+		pong, _ := NewMessage(CMD_PONG, ping)
+		p.Send(pong)
+	case CMD_PONG:
+		var pong PingMsg
+		if err := m.Decode(&pong); err != nil {
+			return err
+		}
+		p.handlePong(&pong)
+	case CMD_INV:
+		var inv InvMsg
+		if err := m.Decode(&inv); err != nil {
+			return err
+		}
+		s.handleInv(p, &inv)
+	case CMD_GETDATA:
+		var inv InvMsg
+		if err := m.Decode(&inv); err != nil {
+			return err
+		}
+		s.handleGetData(p, &inv)
+	case CMD_NOTFOUND:
+		var inv InvMsg
+		if err := m.Decode(&inv); err != nil {
+			return err
+		}
+		s.syncer.handleNotFound(p, &inv)
+	case CMD_BLOCK:
+		var b block.Block
+		if err := m.Decode(&b); err != nil {
+			return err
+		}
+		if b.Malformed() {
+			return errors.New("block with a null transaction")
+		}
+		if handled, ok := s.syncer.handleBlock(p, &b); handled {
+			if !ok {
+				s.misbehaving(p, PENALTY_INVALID_BLOCK, "block does not match header")
+			}
+			return nil
+		}
+		if s.bc.GetBlock(b.Hash()) == nil {
+			extendsTip := b.PrevHash == s.bc.LastBlock().Hash()
+			if !s.bc.AddBlock(&b) && extendsTip {
+				s.misbehaving(p, PENALTY_INVALID_BLOCK, "invalid block")
+			}
+			if !extendsTip && s.bc.GetBlock(b.PrevHash) == nil {
+				// we're behind this peer
+				s.syncer.maybeStart(p, s.bc.Height()+1)
+			}
+		}
+	case CMD_CMPCTBLOCK:
+		var c CompactBlockMsg
+		if err := m.Decode(&c); err != nil {
+			return err
+		}
+		if !s.compact.handleCompactBlock(p, &c) {
+			s.misbehaving(p, PENALTY_INVALID_BLOCK, "invalid compact block")
+		}
+	case CMD_GETBLKTXN:
+		var gb GetBlockTxnMsg
+		if err := m.Decode(&gb); err != nil {
+			return err
+		}
+		s.handleGetBlockTxn(p, &gb)
+	case CMD_BLKTXN:
+		var bt BlockTxnMsg
+		if err := m.Decode(&bt); err != nil {
+			return err
+		}
+		if !s.compact.handleBlockTxn(p, &bt) {
+			s.misbehaving(p, PENALTY_PROTOCOL, "blocktxn doesn't match request")
+		}
+	case CMD_GETHEADERS:
+		var gh GetHeadersMsg
+		if err := m.Decode(&gh); err != nil {
+			return err
+		}
+		s.handleGetHeaders(p, &gh)
+	case CMD_HEADERS:
+		var h HeadersMsg
+		if err := m.Decode(&h); err != nil {
+			return err
+		}
+		if !s.syncer.handleHeaders(p, &h) {
+			s.misbehaving(p, PENALTY_INVALID_BLOCK, "invalid headers")
+		}
+	case CMD_TX:
+		var t block.Transaction
+		if err := m.Decode(&t); err != nil {
+			return err
+		}
+		if !s.bc.HasTransaction(t.Hash()) {
+			if err := s.bc.AddTransaction(&t, nil, nil); err != nil {
+				log.Printf("p2p: tx from %s rejected: %v", p.Addr(), err)
+			}
+		}
+	case CMD_GETADDR:
+		addrs := make([]string, 0)
+		for _, ka := range s.addrs.Addresses() {
+			if ka.Score >= 0 && ka.Addr != p.ListenAddr() && !s.addrs.IsBanned(ka.Addr) {
+				addrs = append(addrs, ka.Addr)
+			}
+		}
+		addr, _ := NewMessage(CMD_ADDR, AddrMsg{addrs})
+		p.Send(addr)
+	case CMD_ADDR:
+		var addr AddrMsg
+		if err := m.Decode(&addr); err != nil {
+			return err
+		}
+		added := s.addrs.Add(addr.Addrs, p.Addr())
+		s.relayAddrs(p, added)
+	case CMD_VERSION, CMD_VERACK:
+		return errors.New("duplicate handshake")
+	default:
+		log.Printf("p2p: ignoring unknown command %q from %s", m.Command, p.Addr())
+	}
+	return nil
+}
+
+// gossips freshly learned addresses to a couple of other peers
+func (s *Server) relayAddrs(from *Peer, addrs []string) {
+	if len(addrs) == 0 {
+		return
+	}
+	if len(addrs) > MAX_ADDR_RELAY {
+		addrs = addrs[:MAX_ADDR_RELAY]
+	}
+	m, _ := NewMessage(CMD_ADDR, AddrMsg{addrs})
+	peers := s.Peers()
+	rand.Shuffle(len(peers), func(i, j int) { peers[i], peers[j] = peers[j], peers[i] })
+	sent := 0
+	for _, p := range peers {
+		if p == from || sent == 2 {
+			continue
+		}
+		p.Send(m)
+		sent++
+	}
+}
+
+// asks for everything announced that we don't have yet
+func (s *Server) handleInv(p *Peer, inv *InvMsg) {
+	want := make([]InvVect, 0)
+	for _, iv := range inv.Items {
+		switch iv.Type {
+		case INV_TYPE_BLOCK:
+			if s.bc.GetBlock(iv.Hash) == nil {
+				want = append(want, iv)
+			}
+		case INV_TYPE_TX:
+			if !s.bc.HasTransaction(iv.Hash) {
+				want = append(want, iv)
+			}
+		}
+	}
+	if len(want) > 0 {
+		m, _ := NewMessage(CMD_GETDATA, InvMsg{want})
+		p.Send(m)
+	}
+}
+
+// blocks we only keep the header of are answered with notfound
+func (s *Server) handleGetData(p *Peer, inv *InvMsg) {
+	notFound := make([]InvVect, 0)
+	for _, iv := range inv.Items {
+		var m *Message
+		switch iv.Type {
+		case INV_TYPE_BLOCK:
+			if b := s.bc.GetBlock(iv.Hash); b != nil && b.Pruned() {
+				notFound = append(notFound, iv)
+			} else if b != nil {
+				m, _ = NewMessage(CMD_BLOCK, b)
+			}
+		case INV_TYPE_TX:
+			if t := s.bc.GetTransaction(iv.Hash); t != nil {
+				m, _ = NewMessage(CMD_TX, t)
+			}
+		}
+		if m != nil {
+			p.Send(m)
+		}
+	}
+	if len(notFound) > 0 {
+		m, _ := NewMessage(CMD_NOTFOUND, InvMsg{notFound})
+		p.Send(m)
+	}
+}
@@ -0,0 +1,446 @@
+package p2p
+
+import (
+	"blockchain/block"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	MAX_HEADERS = 2000
+	// blocks requested but not yet received, over all peers
+	DOWNLOAD_WINDOW     = 64
+	MAX_BLOCKS_PER_PEER = 16
+	// a block request not answered in time goes to another peer
+	BLOCK_TIMEOUT   = 20 * time.Second
+	HEADERS_TIMEOUT = 30 * time.Second
+	// headers accepted past the height the sync peer advertised, for the
+	// blocks mined while we sync
+	HEADERS_SLACK     = MAX_HEADERS
+	PROGRESS_INTERVAL = 10 * time.Second
+)
+
+type GetHeadersMsg struct {
+	// hashes of our chain, from the tip backwards, getting sparser
+	Locator [][32]byte `json:"locator"`
+	// stop after this hash, zero for as many as allowed
+	Stop [32]byte `json:"stop"`
+}
+
+type HeadersMsg struct {
+	Headers []*block.BlockHeader `json:"headers"`
+}
+
+type blockRequest struct {
+	peer *Peer
+	sent time.Time
+}
+
+type SyncProgress struct {
+	Syncing      bool    `json:"syncing"`
+	SyncPeer     string  `json:"sync_peer"`
+	Height       int     `json:"height"`
+	HeaderHeight int     `json:"header_height"`
+	Downloaded   int     `json:"downloaded"`
+	InFlight     int     `json:"in_flight"`
+	Percent      float64 `json:"percent"`
+}
+
+// headers first initial block download: the header chain is fetched from
+// one peer and checked, then bodies are fetched from every peer that has
+// them, a window at a time, and each is checked against its header
+type Syncer struct {
+	server *Server
+
+	mux        sync.Mutex
+	syncing    bool
+	syncPeer   *Peer
+	lastHeader time.Time
+	// the height the sync peer claimed, at least one past our tip
+	peerHeight int
+	// all headers are in, block bodies are being fetched
+	headersDone bool
+	// base is the last block we share with the header chain
+	base     int
+	headers  []*block.BlockHeader
+	index    map[[32]byte]int
+	blocks   map[int]*block.Block
+	inflight map[int]*blockRequest
+	next     int
+	applied  int
+}
+
+func newSyncer(s *Server) *Syncer {
+	return &Syncer{server: s}
+}
+
+func (sy *Syncer) run(quit chan struct{}) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	lastLog := time.Now()
+	for {
+		select {
+		case <-ticker.C:
+			sy.mux.Lock()
+			sy.checkTimeouts()
+			sy.fillWindow()
+			sy.mux.Unlock()
+			if time.Since(lastLog) >= PROGRESS_INTERVAL {
+				if p := sy.Progress(); p.Syncing {
+					log.Printf("p2p: sync height=%d headers=%d downloaded=%d in_flight=%d (%.1f%%)",
+						p.Height, p.HeaderHeight, p.Downloaded, p.InFlight, p.Percent)
+				}
+				lastLog = time.Now()
+			}
+		case <-quit:
+			return
+		}
+	}
+}
+
+func (sy *Syncer) Progress() SyncProgress {
+	sy.mux.Lock()
+	defer sy.mux.Unlock()
+	bc := sy.server.bc
+	p := SyncProgress{
+		Syncing:      sy.syncing,
+		Height:       bc.Height(),
+		HeaderHeight: bc.Height(),
+	}
+	if !sy.syncing {
+		p.Percent = 100
+		return p
+	}
+	if sy.syncPeer != nil {
+		p.SyncPeer = sy.syncPeer.Addr()
+	}
+	p.HeaderHeight = sy.base + len(sy.headers)
+	p.Downloaded = sy.applied + len(sy.blocks)
+	p.InFlight = len(sy.inflight)
+	if len(sy.headers) > 0 {
+		p.Percent = 100 * float64(p.Downloaded) / float64(len(sy.headers))
+	}
+	return p
+}
+
+// starts syncing from p if it claims a longer chain and we're idle
+func (sy *Syncer) maybeStart(p *Peer, height int) {
+	sy.mux.Lock()
+	defer sy.mux.Unlock()
+	if sy.syncing {
+		return
+	}
+	sy.start(p, height)
+}
+
+// same as maybeStart with mux held and no sync running
+func (sy *Syncer) start(p *Peer, height int) {
+	if height <= sy.server.bc.Height() {
+		return
+	}
+	if p.version != nil && p.version.Height > height {
+		height = p.version.Height
+	}
+	sy.syncing = true
+	sy.syncPeer = p
+	sy.peerHeight = height
+	sy.base = -1
+	sy.headers = nil
+	sy.index = make(map[[32]byte]int)
+	sy.blocks = make(map[int]*block.Block)
+	sy.inflight = make(map[int]*blockRequest)
+	sy.next = 0
+	sy.applied = 0
+	sy.headersDone = false
+	log.Printf("p2p: starting sync from %s (height %d, ours %d)", p.Addr(), height, sy.server.bc.Height())
+	sy.requestHeaders(sy.locator())
+}
+
+func (sy *Syncer) stop(reason string) {
+	log.Printf("p2p: sync stopped: %s", reason)
+	sy.syncing = false
+	sy.syncPeer = nil
+	sy.headers = nil
+	sy.blocks = nil
+	sy.inflight = nil
+}
+
+func (sy *Syncer) requestHeaders(locator [][32]byte) {
+	m, _ := NewMessage(CMD_GETHEADERS, GetHeadersMsg{Locator: locator})
+	sy.syncPeer.Send(m)
+	sy.lastHeader = time.Now()
+}
+
+// tip, then the 10 blocks before it, then exponentially further back, genesis last
+func (sy *Syncer) locator() [][32]byte {
+	chain := sy.server.bc.Blocks()
+	locator := make([][32]byte, 0)
+	step := 1
+	for i := len(chain) - 1; i > 0; i -= step {
+		locator = append(locator, chain[i].Hash())
+		if len(locator) >= 10 {
+			step *= 2
+		}
+	}
+	return append(locator, chain[0].Hash())
+}
+
+// answers a getheaders from the first locator hash we know
+func (s *Server) handleGetHeaders(p *Peer, msg *GetHeadersMsg) {
+	start := 0
+	for _, h := range msg.Locator {
+		if height := s.bc.GetBlockHeight(h); height >= 0 {
+			start = height + 1
+			break
+		}
+	}
+	chain := s.bc.Blocks()
+	headers := make([]*block.BlockHeader, 0)
+	for i := start; i < len(chain) && len(headers) < MAX_HEADERS; i++ {
+		b := chain[i]
+		headers = append(headers, b.Header())
+		if b.Hash() == msg.Stop {
+			break
+		}
+	}
+	m, _ := NewMessage(CMD_HEADERS, HeadersMsg{headers})
+	p.Send(m)
+}
+
+func (sy *Syncer) handleHeaders(p *Peer, msg *HeadersMsg) bool {
+	sy.mux.Lock()
+	defer sy.mux.Unlock()
+	if !sy.syncing || p != sy.syncPeer {
+		return true
+	}
+	bc := sy.server.bc
+	for _, h := range msg.Headers {
+		if h == nil {
+			sy.stop("null header")
+			return false
+		}
+		var prev *block.BlockHeader
+		if len(sy.headers) == 0 {
+			sy.base = bc.GetBlockHeight(h.PrevHash)
+			if sy.base < 0 {
+				sy.stop("headers don't connect to our chain")
+				return false
+			}
+			prev = bc.BlockAt(sy.base).Header()
+		} else {
+			prev = sy.headers[len(sy.headers)-1]
+		}
+		if !bc.ValidHeader(h, prev) {
+			sy.stop("invalid header")
+			return false
+		}
+		sy.index[h.Hash()] = len(sy.headers)
+		sy.headers = append(sy.headers, h)
+		if sy.base+len(sy.headers) > sy.peerHeight+HEADERS_SLACK {
+			sy.stop(fmt.Sprintf("more headers than the advertised height %d", sy.peerHeight))
+			return false
+		}
+	}
+	if len(msg.Headers) == MAX_HEADERS {
+		last := sy.headers[len(sy.headers)-1].Hash()
+		sy.requestHeaders([][32]byte{last})
+		return true
+	}
+	if sy.base+len(sy.headers) <= bc.Height() {
+		sy.stop("peer has no longer chain")
+		return true
+	}
+	sy.headersDone = true
+	log.Printf("p2p: got %d headers from %s, downloading blocks", len(sy.headers), p.Addr())
+	sy.fillWindow()
+	return true
+}
+
+// hands out block requests to peers that are known to have them
+func (sy *Syncer) fillWindow() {
+	if !sy.syncing || !sy.headersDone {
+		return
+	}
+	perPeer := make(map[*Peer]int)
+	for _, r := range sy.inflight {
+		perPeer[r.peer]++
+	}
+	peers := sy.server.Peers()
+	requests := make(map[*Peer][]InvVect)
+	for sy.next < len(sy.headers) && len(sy.inflight) < DOWNLOAD_WINDOW {
+		i := sy.next
+		if _, ok := sy.blocks[i]; ok {
+			sy.next++
+			continue
+		}
+		peer := sy.pickPeer(peers, perPeer, sy.base+i+1)
+		if peer == nil {
+			break
+		}
+		perPeer[peer]++
+		sy.inflight[i] = &blockRequest{peer, time.Now()}
+		requests[peer] = append(requests[peer], InvVect{INV_TYPE_BLOCK, sy.headers[i].Hash()})
+		sy.next++
+	}
+	for peer, items := range requests {
+		m, _ := NewMessage(CMD_GETDATA, InvMsg{items})
+		peer.Send(m)
+	}
+}
+
+// least busy peer whose chain reaches height and that hasn't pruned it
+func (sy *Syncer) pickPeer(peers []*Peer, perPeer map[*Peer]int, height int) *Peer {
+	var best *Peer
+	for _, p := range peers {
+		if p != sy.syncPeer && p.version.Height < height {
+			continue
+		}
+		if height < p.pruneHeight {
+			continue
+		}
+		if perPeer[p] >= MAX_BLOCKS_PER_PEER {
+			continue
+		}
+		if best == nil || perPeer[p] < perPeer[best] {
+			best = p
+		}
+	}
+	return best
+}
+
+func (sy *Syncer) checkTimeouts() {
+	if !sy.syncing {
+		return
+	}
+	if !sy.headersDone && time.Since(sy.lastHeader) > HEADERS_TIMEOUT {
+		p := sy.syncPeer
+		sy.stop("headers timed out")
+		sy.server.misbehaving(p, PENALTY_PROTOCOL, "headers timed out")
+		return
+	}
+	for i, r := range sy.inflight {
+		if time.Since(r.sent) > BLOCK_TIMEOUT {
+			log.Printf("p2p: block %d timed out from %s", sy.base+i+1, r.peer.Addr())
+			delete(sy.inflight, i)
+			if i < sy.next {
+				sy.next = i
+			}
+		}
+	}
+}
+
+// true if the block belonged to the sync, it is then checked against its
+// header and applied once every block before it is there
+func (sy *Syncer) handleBlock(p *Peer, b *block.Block) (bool, bool) {
+	sy.mux.Lock()
+	defer sy.mux.Unlock()
+	if !sy.syncing || !sy.headersDone {
+		return false, true
+	}
+	i, ok := sy.index[b.Hash()]
+	if !ok {
+		return false, true
+	}
+	// the hash covers the merkle root, so a matching hash means the body
+	// is the one the header committed to
+	if b.Pruned() || b.Header().Hash() != sy.headers[i].Hash() {
+		return true, false
+	}
+	delete(sy.inflight, i)
+	if i < sy.applied {
+		return true, true
+	}
+	sy.blocks[i] = b
+	sy.apply()
+	return true, true
+}
+
+func (sy *Syncer) apply() {
+	bc := sy.server.bc
+	// blocks relayed to us meanwhile are already in
+	for sy.applied < len(sy.headers) && bc.GetBlockHeight(sy.headers[sy.applied].Hash()) >= 0 {
+		delete(sy.blocks, sy.applied)
+		sy.applied++
+	}
+	if sy.base+sy.applied == bc.Height() {
+		// plain extension of our chain, blocks go in as they arrive
+		for {
+			b, ok := sy.blocks[sy.applied]
+			if !ok {
+				break
+			}
+			if !bc.AddBlock(b) {
+				sy.stop("downloaded block rejected")
+				return
+			}
+			delete(sy.blocks, sy.applied)
+			sy.applied++
+		}
+	} else if sy.applied == 0 && len(sy.blocks) == len(sy.headers) {
+		// the peer's chain forks off ours, switch once we have all of it
+		chain := append([]*block.Block{}, bc.Blocks()[:sy.base+1]...)
+		for i := 0; i < len(sy.headers); i++ {
+			chain = append(chain, sy.blocks[i])
+		}
+		if !bc.ReplaceChain(chain) {
+			sy.stop("downloaded chain rejected")
+			return
+		}
+		sy.applied = len(sy.headers)
+	} else if sy.applied > 0 {
+		// our tip moved to a block that isn't on the header chain, the
+		// blocks left don't connect to it anymore. start over from it
+		p, height := sy.syncPeer, sy.base+len(sy.headers)
+		sy.stop("our tip left the header chain")
+		sy.start(p, height)
+		return
+	}
+	if sy.applied == len(sy.headers) {
+		sy.stop(fmt.Sprintf("done at height %d", bc.Height()))
+	}
+}
+
+// a peer that pruned blocks we asked it for, they go to other peers
+func (sy *Syncer) handleNotFound(p *Peer, inv *InvMsg) {
+	sy.mux.Lock()
+	defer sy.mux.Unlock()
+	if !sy.syncing || !sy.headersDone {
+		return
+	}
+	for _, iv := range inv.Items {
+		i, ok := sy.index[iv.Hash]
+		if !ok || iv.Type != INV_TYPE_BLOCK {
+			continue
+		}
+		if r, ok := sy.inflight[i]; ok && r.peer == p {
+			delete(sy.inflight, i)
+			if i < sy.next {
+				sy.next = i
+			}
+			p.pruneHeight = max(p.pruneHeight, sy.base+i+2)
+		}
+	}
+	sy.fillWindow()
+}
+
+func (sy *Syncer) peerGone(p *Peer) {
+	sy.mux.Lock()
+	defer sy.mux.Unlock()
+	if !sy.syncing {
+		return
+	}
+	if p == sy.syncPeer && !sy.headersDone {
+		sy.stop("sync peer disconnected")
+		return
+	}
+	for i, r := range sy.inflight {
+		if r.peer == p {
+			delete(sy.inflight, i)
+			if i < sy.next {
+				sy.next = i
+			}
+		}
+	}
+}
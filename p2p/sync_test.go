@@ -0,0 +1,111 @@
+package p2p
+
+import (
+	"blockchain/block"
+	"blockchain/wallet"
+	"net"
+	"testing"
+	"time"
+)
+
+func testChain(genesis *block.Genesis, blocks int) *block.Blockchain {
+	address := wallet.NewWallet().BlockchainAddress
+	bc := block.NewBlockChain(address, 0, genesis)
+	bc.SetMiningSettings(block.MiningSettings{IntervalSec: 1, Address: address, MineEmpty: true})
+	for i := 0; i < blocks; i++ {
+		bc.Mining()
+	}
+	return bc
+}
+
+// the last getheaders sent to p
+func lastLocator(t *testing.T, p *Peer) [][32]byte {
+	var locator [][32]byte
+	for len(p.send) > 0 {
+		m := <-p.send
+		if m.Command != CMD_GETHEADERS {
+			continue
+		}
+		var msg GetHeadersMsg
+		if err := m.Decode(&msg); err != nil {
+			t.Fatal(err)
+		}
+		locator = msg.Locator
+	}
+	return locator
+}
+
+func TestSyncRestartsWhenTipLeavesHeaders(t *testing.T) {
+	genesis := &block.Genesis{ChainID: "test", Timestamp: 1, Difficulty: 1, MiningReward: 1}
+	theirs := testChain(genesis, 3)
+	ours := testChain(genesis, 0)
+	s := NewServer(ours, 0, nil)
+	conn, other := net.Pipe()
+	defer conn.Close()
+	defer other.Close()
+	p := newPeer(s, conn, false)
+	sy := s.syncer
+
+	sy.maybeStart(p, theirs.Height())
+	sy.handleHeaders(p, &HeadersMsg{theirs.Headers(1, MAX_HEADERS)})
+	sy.handleBlock(p, theirs.BlockAt(1))
+	if ours.Height() != 1 {
+		t.Fatalf("height %d after the first synced block, want 1", ours.Height())
+	}
+
+	// a block of our own takes the tip while the sync waits for block 2
+	ours.SetMiningSettings(block.MiningSettings{IntervalSec: 1, Address: wallet.NewWallet().BlockchainAddress, MineEmpty: true})
+	ours.Mining()
+	sy.handleBlock(p, theirs.BlockAt(2))
+	if sy.headersDone {
+		t.Fatal("sync still waits for blocks that don't connect to our tip")
+	}
+	locator := lastLocator(t, p)
+	if len(locator) == 0 || locator[0] != ours.LastBlock().Hash() {
+		t.Fatal("sync didn't start over from our new tip")
+	}
+
+	// their answer to the new locator forks off at block 1
+	sy.handleHeaders(p, &HeadersMsg{theirs.Headers(2, MAX_HEADERS)})
+	sy.handleBlock(p, theirs.BlockAt(2))
+	sy.handleBlock(p, theirs.BlockAt(3))
+	if ours.LastBlock().Hash() != theirs.LastBlock().Hash() {
+		t.Errorf("tip at height %d isn't theirs after the sync", ours.Height())
+	}
+	if sy.Progress().Syncing {
+		t.Error("still syncing after the last block")
+	}
+}
+
+func TestSyncStopsPastAdvertisedHeight(t *testing.T) {
+	genesis := &block.Genesis{ChainID: "test", Timestamp: 1, Difficulty: 1, MiningReward: 1}
+	theirs := testChain(genesis, MAX_HEADERS+HEADERS_SLACK+2)
+	s := NewServer(testChain(genesis, 0), 0, NewAddrManager("", nil))
+	conn, other := net.Pipe()
+	defer conn.Close()
+	defer other.Close()
+	p := newPeer(s, conn, false)
+	sy := s.syncer
+
+	// it claimed one block and keeps sending full batches
+	sy.maybeStart(p, 1)
+	if !sy.handleHeaders(p, &HeadersMsg{theirs.Headers(1, MAX_HEADERS)}) {
+		t.Fatal("first batch within the slack refused")
+	}
+	if sy.handleHeaders(p, &HeadersMsg{theirs.Headers(MAX_HEADERS+1, MAX_HEADERS)}) {
+		t.Error("headers past the advertised height and the slack accepted")
+	}
+	if sy.Progress().Syncing {
+		t.Error("still syncing from a peer sending more headers than it advertised")
+	}
+
+	// a peer that stops answering is dropped as the sync peer
+	sy.maybeStart(p, theirs.Height())
+	sy.mux.Lock()
+	sy.lastHeader = sy.lastHeader.Add(-HEADERS_TIMEOUT - time.Second)
+	sy.checkTimeouts()
+	sy.mux.Unlock()
+	if sy.Progress().Syncing {
+		t.Error("still syncing after the headers timed out")
+	}
+}
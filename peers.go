@@ -0,0 +1,92 @@
+package main
+
+import (
+	"blockchain/blockchain_server"
+	"blockchain/p2p"
+	"fmt"
+	"os"
+)
+
+var peersCommands = map[string]*command{
+	"list": {args: "[-offline]", short: "connected and known peers", run: peersList},
+	"add":  {args: "[-offline] <host:port>", short: "add a peer address and connect to it", run: peersAdd},
+}
+
+// the peers file a node run from the data directory keeps
+func localPeers(env *Env, file string) (*p2p.AddrManager, error) {
+	am := p2p.NewAddrManager(blockchain_server.InDatadir(env.Datadir, file), nil)
+	if err := am.Load(); err != nil {
+		return nil, err
+	}
+	return am, nil
+}
+
+func printKnown(known []p2p.KnownAddress) {
+	for _, ka := range known {
+		fmt.Printf("%s score=%d last_seen=%d failures=%d\n", ka.Addr, ka.Score, ka.LastSeen, ka.Failures)
+	}
+}
+
+func peersList(env *Env, args []string) error {
+	fs := env.flags("peers", "list", "")
+	offline := fs.Bool("offline", false, "Read the peers file in the data directory instead of asking the node")
+	file := fs.String("peers_file", "peers.json", "Peers file for -offline")
+	fs.Parse(args)
+
+	if *offline {
+		am, err := localPeers(env, *file)
+		if err != nil {
+			return err
+		}
+		printKnown(am.Addresses())
+		return nil
+	}
+	peers, err := env.Client.GetPeers(env.Ctx)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("connected: %d\n", len(peers.Connected))
+	for _, p := range peers.Connected {
+		fmt.Printf("%s listen=%s inbound=%v height=%d latency=%dms", p.Addr, p.ListenAddr, p.Inbound, p.Height, p.LatencyMs)
+		if p.Pruned {
+			fmt.Printf(" pruned prune_height=%d", p.PruneHeight)
+		}
+		fmt.Println()
+	}
+	fmt.Printf("known: %d\n", len(peers.Known))
+	printKnown(peers.Known)
+	return nil
+}
+
+func peersAdd(env *Env, args []string) error {
+	fs := env.flags("peers", "add", "<host:port>")
+	offline := fs.Bool("offline", false, "Add to the peers file in the data directory instead of telling the node")
+	file := fs.String("peers_file", "peers.json", "Peers file for -offline")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	addr := fs.Arg(0)
+
+	if *offline {
+		am, err := localPeers(env, *file)
+		if err != nil {
+			return err
+		}
+		if len(am.Add([]string{addr}, "cli")) == 0 {
+			return fmt.Errorf("%s is not a new host:port address", addr)
+		}
+		return am.Save()
+	}
+	added, err := env.Client.AddPeer(env.Ctx, addr)
+	if err != nil {
+		return err
+	}
+	if !added.Connected {
+		fmt.Printf("added %s, not connected: %s\n", added.Addr, added.Error)
+		return nil
+	}
+	fmt.Printf("connected to %s\n", added.Addr)
+	return nil
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"blockchain/client"
+	"blockchain/wallet"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	ROUND_PENDING   = "pending"
+	ROUND_CONFIRMED = "confirmed"
+	// the block left the chain, nobody is credited
+	ROUND_ORPHANED = "orphaned"
+)
+
+// payouts kept in the state file, older ones are dropped
+const PAYOUT_HISTORY = 1000
+
+// a block the pool found and how its reward is split
+type Round struct {
+	Height  int                `json:"height"`
+	Hash    string             `json:"hash"`
+	Finder  string             `json:"finder"`
+	Reward  float32            `json:"reward"`
+	Fee     float32            `json:"fee"`
+	Shares  int                `json:"shares"`
+	Credits map[string]float32 `json:"credits"`
+	Found   int64              `json:"found"`
+	Status  string             `json:"status"`
+}
+
+type Payout struct {
+	Address string  `json:"address"`
+	Value   float32 `json:"value"`
+	Time    int64   `json:"time"`
+}
+
+// what the pool owes: rounds are credited once their block has enough
+// confirmations, balances are paid from the pool wallet once they reach
+// the minimum payout
+type Ledger struct {
+	path          string
+	feePercent    float64
+	confirmations int
+	minPayout     float32
+	wallet        *wallet.Wallet
+	rounds        []*Round
+	balances      map[string]float32
+	paid          map[string]float32
+	payouts       []*Payout
+	mux           sync.Mutex
+}
+
+type ledgerFile struct {
+	Wallet   *wallet.WalletResponse `json:"wallet"`
+	Rounds   []*Round               `json:"rounds"`
+	Balances map[string]float32     `json:"balances"`
+	Paid     map[string]float32     `json:"paid"`
+	Payouts  []*Payout              `json:"payouts"`
+}
+
+func NewLedger(path string, feePercent float64, confirmations int, minPayout float32) *Ledger {
+	return &Ledger{
+		path:          path,
+		feePercent:    feePercent,
+		confirmations: confirmations,
+		minPayout:     minPayout,
+		rounds:        make([]*Round, 0),
+		balances:      make(map[string]float32),
+		paid:          make(map[string]float32),
+		payouts:       make([]*Payout, 0),
+	}
+}
+
+// reads the state file, a pool without one gets a new wallet
+func (l *Ledger) Load() error {
+	if l.path != "" {
+		f, err := os.ReadFile(l.path)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err == nil {
+			return l.load(f)
+		}
+	}
+	l.wallet = wallet.NewWallet()
+	return l.Save()
+}
+
+func (l *Ledger) load(f []byte) error {
+	var lf ledgerFile
+	if err := json.Unmarshal(f, &lf); err != nil {
+		return err
+	}
+	if lf.Wallet == nil {
+		return fmt.Errorf("no pool wallet in %s", l.path)
+	}
+	w, err := wallet.LoadWallet(lf.Wallet.PrivateKey, lf.Wallet.PublicKey)
+	if err != nil {
+		return err
+	}
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	l.wallet = w
+	l.rounds = append(l.rounds, lf.Rounds...)
+	for a, v := range lf.Balances {
+		l.balances[a] = v
+	}
+	for a, v := range lf.Paid {
+		l.paid[a] = v
+	}
+	l.payouts = append(l.payouts, lf.Payouts...)
+	return nil
+}
+
+func (l *Ledger) Save() error {
+	if l.path == "" {
+		return nil
+	}
+	l.mux.Lock()
+	w := &wallet.WalletResponse{
+		PrivateKey:        l.wallet.PrivateKeyStr(),
+		PublicKey:         l.wallet.PublicKeyStr(),
+		BlockchainAddress: l.wallet.GetBlockchainAddress(),
+	}
+	lf := ledgerFile{Wallet: w, Rounds: l.rounds, Balances: l.balances, Paid: l.paid, Payouts: l.payouts}
+	m, err := json.MarshalIndent(lf, "", "  ")
+	l.mux.Unlock()
+	if err != nil {
+		return err
+	}
+	tmp := l.path + ".tmp"
+	if err := os.WriteFile(tmp, m, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, l.path)
+}
+
+// the wallet block rewards are mined to and payouts are sent from
+func (l *Ledger) Wallet() *wallet.Wallet {
+	return l.wallet
+}
+
+// splits reward by PPLNS: the fee is kept, the rest goes to the
+// addresses of the last shares in proportion to their work
+func (l *Ledger) AddRound(height int, hash string, finder string, reward float32, shares []*Share) *Round {
+	r := &Round{
+		Height:  height,
+		Hash:    hash,
+		Finder:  finder,
+		Reward:  reward,
+		Fee:     reward * float32(l.feePercent/100),
+		Shares:  len(shares),
+		Credits: make(map[string]float32),
+		Found:   time.Now().Unix(),
+		Status:  ROUND_PENDING,
+	}
+	var total float64
+	for _, s := range shares {
+		total += s.Weight()
+	}
+	for _, s := range shares {
+		r.Credits[s.Address] += (r.Reward - r.Fee) * float32(s.Weight()/total)
+	}
+	l.mux.Lock()
+	l.rounds = append(l.rounds, r)
+	l.mux.Unlock()
+	if err := l.Save(); err != nil {
+		log.Printf("ERROR: saving %s: %v", l.path, err)
+	}
+	return r
+}
+
+// credits rounds that are deep enough, drops orphaned ones and pays
+// balances that reached the minimum payout
+func (l *Ledger) Process(ctx context.Context, c *client.Client) {
+	status, err := c.Status(ctx)
+	if err != nil {
+		log.Printf("ERROR: ledger: %v", err)
+		return
+	}
+	changed := l.confirm(ctx, c, status.Height)
+	if l.pay(ctx, c, status.ChainID) {
+		changed = true
+	}
+	if !changed {
+		return
+	}
+	if err := l.Save(); err != nil {
+		log.Printf("ERROR: saving %s: %v", l.path, err)
+	}
+}
+
+func (l *Ledger) confirm(ctx context.Context, c *client.Client, tip int) bool {
+	l.mux.Lock()
+	pending := make([]*Round, 0)
+	for _, r := range l.rounds {
+		if r.Status == ROUND_PENDING && tip-r.Height+1 >= l.confirmations {
+			pending = append(pending, r)
+		}
+	}
+	l.mux.Unlock()
+
+	changed := false
+	for _, r := range pending {
+		b, err := c.GetBlock(ctx, r.Height)
+		if err != nil && !client.IsNotFound(err) {
+			log.Printf("ERROR: ledger: block %d: %v", r.Height, err)
+			continue
+		}
+		l.mux.Lock()
+		if b == nil || fmt.Sprintf("%x", b.Hash()) != r.Hash {
+			r.Status = ROUND_ORPHANED
+			log.Printf("round %d orphaned, block %s is no longer on the chain", r.Height, r.Hash)
+		} else {
+			r.Status = ROUND_CONFIRMED
+			for a, v := range r.Credits {
+				l.balances[a] += v
+			}
+			log.Printf("round %d confirmed, %v credited to %d addresses", r.Height, r.Reward-r.Fee, len(r.Credits))
+		}
+		l.mux.Unlock()
+		changed = true
+	}
+	return changed
+}
+
+// sends one transaction per address owed at least minPayout. a payout the
+// node turns down, most likely for immature rewards, is tried again
+// the next time
+func (l *Ledger) pay(ctx context.Context, c *client.Client, chainID string) bool {
+	l.mux.Lock()
+	due := make([]string, 0)
+	for a, v := range l.balances {
+		if v >= l.minPayout && v > 0 {
+			due = append(due, a)
+		}
+	}
+	l.mux.Unlock()
+	sort.Strings(due)
+
+	changed := false
+	for _, a := range due {
+		l.mux.Lock()
+		value := l.balances[a]
+		l.mux.Unlock()
+		if err := c.SubmitTransaction(ctx, l.wallet.TransactionRequest(chainID, a, value)); err != nil {
+			log.Printf("ERROR: payout of %v to %s: %v", value, a, err)
+			break
+		}
+		l.mux.Lock()
+		l.balances[a] -= value
+		if l.balances[a] <= 0 {
+			delete(l.balances, a)
+		}
+		l.paid[a] += value
+		l.payouts = append(l.payouts, &Payout{Address: a, Value: value, Time: time.Now().Unix()})
+		if len(l.payouts) > PAYOUT_HISTORY {
+			l.payouts = l.payouts[len(l.payouts)-PAYOUT_HISTORY:]
+		}
+		l.mux.Unlock()
+		log.Printf("action=Payout, address=%s, value=%v", a, value)
+		changed = true
+	}
+	return changed
+}
+
+type LedgerStats struct {
+	Address  string             `json:"address"`
+	Rounds   []*Round           `json:"rounds"`
+	Balances map[string]float32 `json:"balances"`
+	Paid     map[string]float32 `json:"paid"`
+	Payouts  []*Payout          `json:"payouts"`
+}
+
+// the last rounds and payouts, newest first
+func (l *Ledger) Stats(last int) *LedgerStats {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	ls := &LedgerStats{
+		Address:  l.wallet.GetBlockchainAddress(),
+		Rounds:   make([]*Round, 0, last),
+		Balances: make(map[string]float32, len(l.balances)),
+		Paid:     make(map[string]float32, len(l.paid)),
+		Payouts:  make([]*Payout, 0, last),
+	}
+	for i := len(l.rounds) - 1; i >= 0 && len(ls.Rounds) < last; i-- {
+		r := *l.rounds[i]
+		ls.Rounds = append(ls.Rounds, &r)
+	}
+	for i := len(l.payouts) - 1; i >= 0 && len(ls.Payouts) < last; i-- {
+		ls.Payouts = append(ls.Payouts, l.payouts[i])
+	}
+	for a, v := range l.balances {
+		ls.Balances[a] = v
+	}
+	for a, v := range l.paid {
+		ls.Paid[a] = v
+	}
+	return ls
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"blockchain/block"
+	"blockchain/client"
+	"blockchain/utils"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"time"
+)
+
+func init() {
+	log.SetPrefix("Pool: ")
+}
+
+// pool statistics: connections, workers, rounds and payouts
+func statsHandler(p *Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		switch req.Method {
+		case http.MethodGet:
+			m, _ := json.Marshal(p.Stats())
+			w.Header().Add("Content-Type", "application/json")
+			io.WriteString(w, string(m))
+		default:
+			utils.MethodNotAllowed(w, req, http.MethodGet)
+		}
+	}
+}
+
+func main() {
+	node := flag.String("node", "http://127.0.0.1:5000", "Blockchain Server to get templates from and submit blocks to")
+	port := flag.Uint("port", 3333, "TCP Port Number miners connect to")
+	statsPort := flag.Uint("stats_port", 3334, "TCP Port Number of the http statistics, 0 for none")
+	stateFile := flag.String("state_file", "pool.json", "File the pool wallet, rounds and balances are kept in")
+	shareDifficulty := flag.Int("share_difficulty", block.MINING_DIFFICULTY-1, "Leading hex zeros of a share, capped at the block difficulty")
+	window := flag.Int("window", 1000, "Number of last shares a block reward is split over (PPLNS)")
+	fee := flag.Float64("fee", 1, "Percent of each block reward the pool keeps")
+	confirmations := flag.Int("confirmations", block.MainnetGenesis.CoinbaseMaturity, "Confirmations before a block's reward is credited")
+	minPayout := flag.Float64("min_payout", 0.1, "Smallest balance that is paid out")
+	refresh := flag.Duration("refresh", 10*time.Second, "How often miners get a new job while no block is found")
+	flag.Parse()
+
+	if *shareDifficulty < 1 {
+		log.Fatal("-share_difficulty must be at least 1")
+	}
+	if *window < 1 {
+		log.Fatal("-window must be at least 1")
+	}
+	if *fee < 0 || *fee > 100 {
+		log.Fatal("-fee must be between 0 and 100")
+	}
+
+	ledger := NewLedger(*stateFile, *fee, *confirmations, float32(*minPayout))
+	if err := ledger.Load(); err != nil {
+		log.Fatalf("state file %s: %v", *stateFile, err)
+	}
+	log.Printf("pool address: %s", ledger.Wallet().GetBlockchainAddress())
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	p := NewPool(client.NewClient(*node, 0), ledger, *shareDifficulty, *window, *refresh)
+	l, err := net.Listen("tcp", fmt.Sprintf(":%d", *port))
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("stratum on :%d, share difficulty %d, PPLNS window %d, fee %v%%", *port, *shareDifficulty, *window, *fee)
+	go p.Serve(ctx, l)
+
+	if *statsPort != 0 {
+		mux := http.NewServeMux()
+		mux.HandleFunc("/stats", statsHandler(p))
+		go func() {
+			log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", *statsPort), utils.WithRequestID(mux)))
+		}()
+	}
+	p.Run(ctx)
+}
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"blockchain/block"
+	"blockchain/client"
+	"blockchain/stratum"
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// jobs on the current tip that still take shares
+	JOB_HISTORY = 8
+	// wait before reconnecting to the event stream or retrying a template
+	RETRY_INTERVAL = 3 * time.Second
+	// rounds and payouts shown on /stats
+	STATS_HISTORY = 20
+)
+
+// work a worker did towards the pool's blocks
+type Share struct {
+	Address    string `json:"address"`
+	Worker     string `json:"worker"`
+	Difficulty int    `json:"difficulty"`
+	Time       int64  `json:"time"`
+}
+
+// hashes a share takes on average, 16 per leading hex zero
+func (s *Share) Weight() float64 {
+	return math.Pow(16, float64(s.Difficulty))
+}
+
+type WorkerStats struct {
+	Name      string `json:"name"`
+	Shares    int    `json:"shares"`
+	Stale     int    `json:"stale"`
+	Invalid   int    `json:"invalid"`
+	Blocks    int    `json:"blocks"`
+	LastShare int64  `json:"last_share"`
+	// estimated from the shares since the worker's first one
+	HashRate float64 `json:"hash_rate"`
+	first    time.Time
+	work     float64
+}
+
+type poolJob struct {
+	job      *stratum.Job
+	template *block.BlockTemplate
+	nonces   map[int]bool
+}
+
+// hands out the node's block templates as jobs at share difficulty,
+// keeps the last window shares for PPLNS and submits full solutions
+type Pool struct {
+	client          *client.Client
+	ledger          *Ledger
+	shareDifficulty int
+	window          int
+	refresh         time.Duration
+	jobs            map[string]*poolJob
+	current         *poolJob
+	nextJob         uint64
+	sessions        map[*Session]bool
+	nextExtranonce  int
+	shares          []*Share
+	workers         map[string]*WorkerStats
+	blocks          chan struct{}
+	mux             sync.Mutex
+}
+
+func NewPool(c *client.Client, ledger *Ledger, shareDifficulty int, window int, refresh time.Duration) *Pool {
+	return &Pool{
+		client:          c,
+		ledger:          ledger,
+		shareDifficulty: shareDifficulty,
+		window:          window,
+		refresh:         refresh,
+		jobs:            make(map[string]*poolJob),
+		sessions:        make(map[*Session]bool),
+		shares:          make([]*Share, 0, window),
+		workers:         make(map[string]*WorkerStats),
+		blocks:          make(chan struct{}, 1),
+	}
+}
+
+// sends new jobs every refresh and whenever the tip moves, until ctx ends
+func (p *Pool) Run(ctx context.Context) {
+	go p.watchBlocks(ctx)
+	ticker := time.NewTicker(p.refresh)
+	defer ticker.Stop()
+	for {
+		if err := p.update(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("ERROR: block template: %v", err)
+		}
+		p.ledger.Process(ctx, p.client)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-p.blocks:
+		}
+	}
+}
+
+// a new job from the node's template. a new tip makes it a clean job
+// and drops the jobs on the old one
+func (p *Pool) update(ctx context.Context) error {
+	t, err := p.client.GetBlockTemplate(ctx, p.ledger.Wallet().GetBlockchainAddress())
+	if err != nil {
+		return err
+	}
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	clean := p.current == nil || p.current.template.PrevHash != t.PrevHash
+	if clean {
+		p.jobs = make(map[string]*poolJob)
+	}
+	p.nextJob++
+	id := strconv.FormatUint(p.nextJob, 16)
+	pj := &poolJob{job: stratum.NewJob(id, t, clean), template: t, nonces: make(map[int]bool)}
+	p.jobs[id] = pj
+	delete(p.jobs, strconv.FormatUint(p.nextJob-JOB_HISTORY, 16))
+	p.current = pj
+	for s := range p.sessions {
+		p.notify(s)
+	}
+	return nil
+}
+
+// share difficulty of a job, never above the block's
+func (p *Pool) difficulty(t *block.BlockTemplate) int {
+	return min(p.shareDifficulty, t.Difficulty)
+}
+
+// sends the current job to s, called with the lock held
+func (p *Pool) notify(s *Session) {
+	if p.current == nil || !s.subscribed {
+		return
+	}
+	s.send(stratum.METHOD_SET_DIFFICULTY, []int{p.difficulty(p.current.template)})
+	s.send(stratum.METHOD_NOTIFY, []*stratum.Job{p.current.job})
+}
+
+func (p *Pool) addSession(s *Session) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	p.sessions[s] = true
+}
+
+func (p *Pool) removeSession(s *Session) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	delete(p.sessions, s)
+}
+
+// gives s its extranonce
+func (p *Pool) subscribe(s *Session) int {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	if !s.subscribed {
+		p.nextExtranonce++
+		s.extranonce = p.nextExtranonce
+		s.subscribed = true
+	}
+	return s.extranonce
+}
+
+// jobs are only sent once the answer to the subscription is out
+func (p *Pool) sendJob(s *Session) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	p.notify(s)
+}
+
+// workers are named after the address they are paid to, optionally
+// followed by a dot and the name of the rig
+func workerAddress(worker string) string {
+	address, _, _ := strings.Cut(worker, ".")
+	return address
+}
+
+func (p *Pool) authorize(worker string) bool {
+	address := workerAddress(worker)
+	if address == "" || address == block.MINING_SENDER {
+		return false
+	}
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	if _, ok := p.workers[worker]; !ok {
+		p.workers[worker] = &WorkerStats{Name: worker}
+	}
+	return true
+}
+
+// checks and counts a share, submitting the block when it solves one
+func (p *Pool) submit(ctx context.Context, s *Session, worker string, jobID string, nonce int) *stratum.Error {
+	pj, window, err := p.accept(s, worker, jobID, nonce)
+	if err != nil {
+		return err
+	}
+	if window != nil {
+		go p.found(ctx, pj.template, worker, nonce, window)
+	}
+	return nil
+}
+
+// counts the share, for a block solution it also returns the PPLNS
+// window the reward is split over
+func (p *Pool) accept(s *Session, worker string, jobID string, nonce int) (*poolJob, []*Share, *stratum.Error) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	ws := p.workers[worker]
+	pj, ok := p.jobs[jobID]
+	switch {
+	case !ok:
+		ws.Stale++
+		return nil, nil, &stratum.Error{Code: stratum.ERR_JOB_NOT_FOUND, Message: "job not found"}
+	case stratum.Extranonce(nonce) != s.extranonce:
+		ws.Invalid++
+		return nil, nil, &stratum.Error{Code: stratum.ERR_OTHER, Message: "nonce outside the connection's range"}
+	case pj.nonces[nonce]:
+		ws.Invalid++
+		return nil, nil, &stratum.Error{Code: stratum.ERR_DUPLICATE_SHARE, Message: "duplicate share"}
+	}
+	difficulty := p.difficulty(pj.template)
+	if !pj.template.CheckDifficulty(nonce, difficulty) {
+		ws.Invalid++
+		return nil, nil, &stratum.Error{Code: stratum.ERR_LOW_DIFFICULTY, Message: "low difficulty share"}
+	}
+	pj.nonces[nonce] = true
+
+	share := &Share{Address: workerAddress(worker), Worker: worker, Difficulty: difficulty, Time: time.Now().Unix()}
+	p.shares = append(p.shares, share)
+	if len(p.shares) > p.window {
+		p.shares = p.shares[len(p.shares)-p.window:]
+	}
+	if ws.Shares == 0 {
+		ws.first = time.Now()
+	}
+	ws.Shares++
+	ws.LastShare = share.Time
+	ws.work += share.Weight()
+	if !pj.template.Check(nonce) {
+		return pj, nil, nil
+	}
+	window := make([]*Share, len(p.shares))
+	copy(window, p.shares)
+	return pj, window, nil
+}
+
+func (p *Pool) found(ctx context.Context, t *block.BlockTemplate, worker string, nonce int, shares []*Share) {
+	b := t.Block(nonce)
+	hash := fmt.Sprintf("%x", b.Hash())
+	height, err := p.client.SubmitBlock(ctx, b)
+	if client.IsCode(err, "stale_block") {
+		log.Printf("block %s at height %d came too late", hash, t.Height)
+		return
+	}
+	if err != nil {
+		log.Printf("ERROR: submit block: %v", err)
+		return
+	}
+	p.mux.Lock()
+	p.workers[worker].Blocks++
+	p.mux.Unlock()
+
+	r := p.ledger.AddRound(height, hash, worker, t.CoinbaseValue, shares)
+	log.Printf("action=BlockFound, height=%d, hash=%s, worker=%s, shares=%d, addresses=%d",
+		height, hash, worker, len(shares), len(r.Credits))
+	p.newBlock()
+}
+
+func (p *Pool) newBlock() {
+	select {
+	case p.blocks <- struct{}{}:
+	default:
+	}
+}
+
+// new jobs as soon as the node has a new block, reconnecting when the
+// stream breaks
+func (p *Pool) watchBlocks(ctx context.Context) {
+	for ctx.Err() == nil {
+		if err := p.followBlocks(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("ERROR: block events: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(RETRY_INTERVAL):
+		}
+	}
+}
+
+func (p *Pool) followBlocks(ctx context.Context) error {
+	stream, err := p.client.Events(ctx, []string{block.EVENT_BLOCK}, nil, "")
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+	for {
+		e, err := stream.Next()
+		if err != nil {
+			return err
+		}
+		if e.Type == block.EVENT_BLOCK {
+			p.newBlock()
+		}
+	}
+}
+
+type PoolStats struct {
+	Connections     int            `json:"connections"`
+	ShareDifficulty int            `json:"share_difficulty"`
+	Window          int            `json:"window"`
+	WindowShares    int            `json:"window_shares"`
+	Height          int            `json:"height"`
+	HashRate        float64        `json:"hash_rate"`
+	Workers         []*WorkerStats `json:"workers"`
+	*LedgerStats
+}
+
+func (p *Pool) Stats() *PoolStats {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	ps := &PoolStats{
+		Connections:     len(p.sessions),
+		ShareDifficulty: p.shareDifficulty,
+		Window:          p.window,
+		WindowShares:    len(p.shares),
+		Workers:         make([]*WorkerStats, 0, len(p.workers)),
+	}
+	if p.current != nil {
+		ps.ShareDifficulty = p.difficulty(p.current.template)
+		ps.Height = p.current.template.Height
+	}
+	for _, ws := range p.workers {
+		w := *ws
+		if elapsed := time.Since(ws.first).Seconds(); ws.Shares > 0 && elapsed > 0 {
+			w.HashRate = ws.work / elapsed
+		}
+		ps.HashRate += w.HashRate
+		ps.Workers = append(ps.Workers, &w)
+	}
+	sort.Slice(ps.Workers, func(i, j int) bool { return ps.Workers[i].Name < ps.Workers[j].Name })
+	ps.LedgerStats = p.ledger.Stats(STATS_HISTORY)
+	return ps
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"blockchain/stratum"
+	"bufio"
+	"context"
+	"encoding/json"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// a slow miner must not hold up the jobs sent to everyone else
+const WRITE_TIMEOUT = 5 * time.Second
+
+// one miner connection
+type Session struct {
+	conn       net.Conn
+	extranonce int
+	subscribed bool
+	// workers authorized on this connection
+	workers  map[string]bool
+	writeMux sync.Mutex
+}
+
+// accepts miners until ctx ends
+func (p *Pool) Serve(ctx context.Context, l net.Listener) {
+	go func() {
+		<-ctx.Done()
+		l.Close()
+	}()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("ERROR: accept: %v", err)
+			}
+			return
+		}
+		go p.handle(ctx, conn)
+	}
+}
+
+func (p *Pool) handle(ctx context.Context, conn net.Conn) {
+	s := &Session{conn: conn, workers: make(map[string]bool)}
+	p.addSession(s)
+	defer func() {
+		p.removeSession(s)
+		conn.Close()
+		log.Printf("miner %s disconnected", conn.RemoteAddr())
+	}()
+	log.Printf("miner %s connected", conn.RemoteAddr())
+
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 4096), stratum.MAX_LINE_SIZE)
+	for scanner.Scan() {
+		var r stratum.Request
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			log.Printf("ERROR: miner %s: %v", conn.RemoteAddr(), err)
+			return
+		}
+		result, serr := p.call(ctx, s, &r)
+		resp := &stratum.Response{ID: r.ID, Error: serr}
+		if serr == nil {
+			resp.Result, _ = json.Marshal(result)
+		}
+		if err := s.write(resp); err != nil {
+			return
+		}
+		if r.Method == stratum.METHOD_SUBSCRIBE && serr == nil {
+			p.sendJob(s)
+		}
+	}
+}
+
+func (p *Pool) call(ctx context.Context, s *Session, r *stratum.Request) (interface{}, *stratum.Error) {
+	switch r.Method {
+	case stratum.METHOD_SUBSCRIBE:
+		return &stratum.SubscribeResult{
+			SubscriptionID: s.conn.RemoteAddr().String(),
+			Extranonce:     p.subscribe(s),
+		}, nil
+	case stratum.METHOD_AUTHORIZE:
+		var params []string
+		if err := json.Unmarshal(r.Params, &params); err != nil || len(params) < 1 {
+			return nil, &stratum.Error{Code: stratum.ERR_OTHER, Message: "params must be [worker, password]"}
+		}
+		if !p.authorize(params[0]) {
+			return false, nil
+		}
+		s.workers[params[0]] = true
+		log.Printf("worker %s authorized from %s", params[0], s.conn.RemoteAddr())
+		return true, nil
+	case stratum.METHOD_SUBMIT:
+		var params []json.RawMessage
+		var worker, jobID string
+		var nonce int
+		if err := json.Unmarshal(r.Params, &params); err != nil || len(params) != 3 ||
+			json.Unmarshal(params[0], &worker) != nil || json.Unmarshal(params[1], &jobID) != nil ||
+			json.Unmarshal(params[2], &nonce) != nil {
+			return nil, &stratum.Error{Code: stratum.ERR_OTHER, Message: "params must be [worker, job_id, nonce]"}
+		}
+		if !s.subscribed {
+			return nil, &stratum.Error{Code: stratum.ERR_NOT_SUBSCRIBED, Message: "not subscribed"}
+		}
+		if !s.workers[worker] {
+			return nil, &stratum.Error{Code: stratum.ERR_UNAUTHORIZED, Message: "unauthorized worker"}
+		}
+		if err := p.submit(ctx, s, worker, jobID, nonce); err != nil {
+			return nil, err
+		}
+		return true, nil
+	}
+	return nil, &stratum.Error{Code: stratum.ERR_OTHER, Message: "unknown method " + r.Method}
+}
+
+// a notification, the pool doesn't wait for it to arrive
+func (s *Session) send(method string, params interface{}) {
+	m, _ := json.Marshal(params)
+	if err := s.write(&stratum.Request{ID: json.RawMessage("null"), Method: method, Params: m}); err != nil {
+		log.Printf("ERROR: miner %s: %v", s.conn.RemoteAddr(), err)
+		s.conn.Close()
+	}
+}
+
+func (s *Session) write(v interface{}) error {
+	m, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	s.writeMux.Lock()
+	defer s.writeMux.Unlock()
+	s.conn.SetWriteDeadline(time.Now().Add(WRITE_TIMEOUT))
+	_, err = s.conn.Write(append(m, '\n'))
+	return err
+}
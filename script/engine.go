@@ -0,0 +1,318 @@
+package script
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// lock times below this are block heights, above are unix timestamps
+const LOCKTIME_THRESHOLD = 500000000
+
+const MAX_STACK_SIZE = 1000
+
+// everything the scripts can see about the spending transaction
+type Context struct {
+	SigHash [32]byte // hash the signatures are checked against
+	Height  int64    // height of the block the spend is included in
+	Time    int64    // unix seconds of the block the spend is included in
+}
+
+type Engine struct {
+	ctx   *Context
+	stack [][]byte
+	cond  []bool
+}
+
+var (
+	ErrVerifyFailed   = errors.New("script verification failed")
+	ErrStackUnderflow = errors.New("stack underflow")
+	ErrUnbalancedIf   = errors.New("unbalanced conditional")
+	ErrLockTime       = errors.New("lock time not reached")
+)
+
+func NewEngine(ctx *Context) *Engine {
+	return &Engine{ctx: ctx, stack: make([][]byte, 0)}
+}
+
+// runs the unlock script, then the lock script on the resulting stack.
+// the spend is valid if the top of the stack is true at the end
+func Verify(unlock Script, lock Script, ctx *Context) error {
+	if !unlock.IsPushOnly() {
+		return errors.New("unlock script must only push data")
+	}
+	e := NewEngine(ctx)
+	if err := e.Execute(unlock); err != nil {
+		return err
+	}
+	if err := e.Execute(lock); err != nil {
+		return err
+	}
+	if len(e.stack) == 0 || !asBool(e.stack[len(e.stack)-1]) {
+		return ErrVerifyFailed
+	}
+	return nil
+}
+
+func (e *Engine) Stack() [][]byte {
+	return e.stack
+}
+
+func (e *Engine) executing() bool {
+	for _, c := range e.cond {
+		if !c {
+			return false
+		}
+	}
+	return true
+}
+
+func (e *Engine) push(b []byte) error {
+	if len(e.stack) >= MAX_STACK_SIZE {
+		return fmt.Errorf("stack size exceeds %d", MAX_STACK_SIZE)
+	}
+	e.stack = append(e.stack, b)
+	return nil
+}
+
+func (e *Engine) pop() ([]byte, error) {
+	if len(e.stack) == 0 {
+		return nil, ErrStackUnderflow
+	}
+	b := e.stack[len(e.stack)-1]
+	e.stack = e.stack[:len(e.stack)-1]
+	return b, nil
+}
+
+func (e *Engine) peek() ([]byte, error) {
+	if len(e.stack) == 0 {
+		return nil, ErrStackUnderflow
+	}
+	return e.stack[len(e.stack)-1], nil
+}
+
+func (e *Engine) Execute(s Script) error {
+	ins, err := s.parse()
+	if err != nil {
+		return err
+	}
+	e.cond = e.cond[:0]
+	for _, in := range ins {
+		if err := e.step(in); err != nil {
+			return fmt.Errorf("%s: %w", Script(encodeInstruction(in)).Disassemble(), err)
+		}
+	}
+	if len(e.cond) != 0 {
+		return ErrUnbalancedIf
+	}
+	return nil
+}
+
+func (e *Engine) step(in instruction) error {
+	switch in.op {
+	case OP_IF, OP_NOTIF:
+		if !e.executing() {
+			e.cond = append(e.cond, false)
+			return nil
+		}
+		b, err := e.pop()
+		if err != nil {
+			return err
+		}
+		v := asBool(b)
+		if in.op == OP_NOTIF {
+			v = !v
+		}
+		e.cond = append(e.cond, v)
+		return nil
+	case OP_ELSE:
+		if len(e.cond) == 0 {
+			return ErrUnbalancedIf
+		}
+		e.cond[len(e.cond)-1] = !e.cond[len(e.cond)-1]
+		return nil
+	case OP_ENDIF:
+		if len(e.cond) == 0 {
+			return ErrUnbalancedIf
+		}
+		e.cond = e.cond[:len(e.cond)-1]
+		return nil
+	}
+
+	if !e.executing() {
+		return nil
+	}
+
+	if in.data != nil {
+		return e.push(in.data)
+	}
+	if isSmallInt(in.op) {
+		return e.push(encodeNum(smallIntValue(in.op)))
+	}
+
+	switch in.op {
+	case OP_1NEGATE:
+		return e.push(encodeNum(-1))
+	case OP_NOP:
+		return nil
+	case OP_VERIFY:
+		b, err := e.pop()
+		if err != nil {
+			return err
+		}
+		if !asBool(b) {
+			return ErrVerifyFailed
+		}
+		return nil
+	case OP_RETURN:
+		return errors.New("script returned early")
+	case OP_DROP:
+		_, err := e.pop()
+		return err
+	case OP_DUP:
+		b, err := e.peek()
+		if err != nil {
+			return err
+		}
+		return e.push(b)
+	case OP_SWAP:
+		if len(e.stack) < 2 {
+			return ErrStackUnderflow
+		}
+		n := len(e.stack)
+		e.stack[n-1], e.stack[n-2] = e.stack[n-2], e.stack[n-1]
+		return nil
+	case OP_SIZE:
+		b, err := e.peek()
+		if err != nil {
+			return err
+		}
+		return e.push(encodeNum(int64(len(b))))
+	case OP_EQUAL, OP_EQUALVERIFY:
+		a, err := e.pop()
+		if err != nil {
+			return err
+		}
+		b, err := e.pop()
+		if err != nil {
+			return err
+		}
+		eq := bytes.Equal(a, b)
+		if in.op == OP_EQUALVERIFY {
+			if !eq {
+				return ErrVerifyFailed
+			}
+			return nil
+		}
+		return e.push(fromBool(eq))
+	case OP_SHA256, OP_HASH160, OP_HASH256:
+		b, err := e.pop()
+		if err != nil {
+			return err
+		}
+		var h []byte
+		switch in.op {
+		case OP_SHA256:
+			s := sha256.Sum256(b)
+			h = s[:]
+		case OP_HASH160:
+			h = Hash160(b)
+		default:
+			first := sha256.Sum256(b)
+			second := sha256.Sum256(first[:])
+			h = second[:]
+		}
+		return e.push(h)
+	case OP_CHECKSIG, OP_CHECKSIGVERIFY:
+		pub, err := e.pop()
+		if err != nil {
+			return err
+		}
+		sig, err := e.pop()
+		if err != nil {
+			return err
+		}
+		ok := e.checkSig(pub, sig)
+		if in.op == OP_CHECKSIGVERIFY {
+			if !ok {
+				return ErrVerifyFailed
+			}
+			return nil
+		}
+		return e.push(fromBool(ok))
+	case OP_CHECKLOCKTIMEVERIFY:
+		b, err := e.peek()
+		if err != nil {
+			return err
+		}
+		lockTime, err := decodeNum(b, 5)
+		if err != nil {
+			return err
+		}
+		if lockTime < 0 {
+			return errors.New("negative lock time")
+		}
+		if !LockTimeReached(lockTime, e.ctx.Height, e.ctx.Time) {
+			return ErrLockTime
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported opcode %#x", in.op)
+}
+
+// public keys are the 64 byte X||Y of a P256 point, signatures the 64 byte R||S
+func (e *Engine) checkSig(pub []byte, sig []byte) bool {
+	if len(pub) != 64 || len(sig) != 64 {
+		return false
+	}
+	publicKey := &ecdsa.PublicKey{
+		Curve: elliptic.P256(),
+		X:     new(big.Int).SetBytes(pub[:32]),
+		Y:     new(big.Int).SetBytes(pub[32:]),
+	}
+	if !publicKey.Curve.IsOnCurve(publicKey.X, publicKey.Y) {
+		return false
+	}
+	r := new(big.Int).SetBytes(sig[:32])
+	s := new(big.Int).SetBytes(sig[32:])
+	return ecdsa.Verify(publicKey, e.ctx.SigHash[:], r, s)
+}
+
+// reports whether a lock time (height or unix seconds) has passed
+func LockTimeReached(lockTime int64, height int64, time int64) bool {
+	if lockTime < LOCKTIME_THRESHOLD {
+		return height >= lockTime
+	}
+	return time >= lockTime
+}
+
+func asBool(b []byte) bool {
+	for i, v := range b {
+		if v != 0 {
+			// negative zero is false
+			if i == len(b)-1 && v == 0x80 {
+				return false
+			}
+			return true
+		}
+	}
+	return false
+}
+
+func fromBool(v bool) []byte {
+	if v {
+		return []byte{1}
+	}
+	return []byte{}
+}
+
+func encodeInstruction(in instruction) []byte {
+	if in.data == nil {
+		return []byte{in.op}
+	}
+	return NewBuilder().AddData(in.data).Script()
+}
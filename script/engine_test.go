@@ -0,0 +1,133 @@
+package script
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"testing"
+)
+
+type testKey struct {
+	key *ecdsa.PrivateKey
+	pub []byte
+}
+
+func newTestKey(t *testing.T) *testKey {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub := make([]byte, 64)
+	key.X.FillBytes(pub[:32])
+	key.Y.FillBytes(pub[32:])
+	return &testKey{key, pub}
+}
+
+// 64 byte R||S over hash, as checkSig reads it
+func (k *testKey) sign(t *testing.T, hash [32]byte) []byte {
+	r, s, err := ecdsa.Sign(rand.Reader, k.key, hash[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig := make([]byte, 64)
+	r.FillBytes(sig[:32])
+	s.FillBytes(sig[32:])
+	return sig
+}
+
+func ops(ops ...byte) Script {
+	b := NewBuilder()
+	for _, op := range ops {
+		b.AddOp(op)
+	}
+	return b.Script()
+}
+
+func TestVerify(t *testing.T) {
+	ctx := &Context{SigHash: sha256.Sum256([]byte("spend")), Height: 100, Time: 1700000000}
+	recipient, other := newTestKey(t), newTestKey(t)
+	preimage := []byte("secret")
+	hashLock := sha256.Sum256(preimage)
+
+	tests := []struct {
+		name   string
+		unlock Script
+		lock   Script
+		err    error
+	}{
+		{"true", Script{}, ops(OP_1), nil},
+		{"false", Script{}, ops(OP_0), ErrVerifyFailed},
+		{"empty stack", Script{}, Script{}, ErrVerifyFailed},
+		{"negative zero is false", NewBuilder().AddData([]byte{0x80}).Script(), Script{}, ErrVerifyFailed},
+		{"hash lock", NewBuilder().AddData(preimage).Script(),
+			NewBuilder().AddOp(OP_SHA256).AddData(hashLock[:]).AddOp(OP_EQUAL).Script(), nil},
+		{"wrong preimage", NewBuilder().AddData([]byte("guess")).Script(),
+			NewBuilder().AddOp(OP_SHA256).AddData(hashLock[:]).AddOp(OP_EQUAL).Script(), ErrVerifyFailed},
+		{"equalverify", ops(OP_1, OP_0), ops(OP_EQUALVERIFY, OP_1), ErrVerifyFailed},
+		{"verify", Script{}, ops(OP_0, OP_VERIFY, OP_1), ErrVerifyFailed},
+		{"if branch", ops(OP_1), ops(OP_IF, OP_1, OP_ELSE, OP_0, OP_ENDIF), nil},
+		{"else branch", ops(OP_0), ops(OP_IF, OP_0, OP_ELSE, OP_1, OP_ENDIF), nil},
+		{"notif", ops(OP_0), ops(OP_NOTIF, OP_1, OP_ELSE, OP_0, OP_ENDIF), nil},
+		{"unbalanced if", ops(OP_1), ops(OP_IF, OP_1), ErrUnbalancedIf},
+		{"else without if", Script{}, ops(OP_ELSE), ErrUnbalancedIf},
+		{"endif without if", Script{}, ops(OP_ENDIF), ErrUnbalancedIf},
+		{"stack underflow", Script{}, ops(OP_DUP), ErrStackUnderflow},
+		{"swap underflow", ops(OP_1), ops(OP_SWAP), ErrStackUnderflow},
+		{"size", NewBuilder().AddData(preimage).Script(), NewBuilder().AddOp(OP_SIZE).AddInt(6).AddOp(OP_EQUAL).Script(), nil},
+		{"lock time height reached", Script{}, NewBuilder().AddInt(100).AddOp(OP_CHECKLOCKTIMEVERIFY).Script(), nil},
+		{"lock time height not reached", Script{}, NewBuilder().AddInt(101).AddOp(OP_CHECKLOCKTIMEVERIFY).Script(), ErrLockTime},
+		{"lock time timestamp not reached", Script{}, NewBuilder().AddInt(ctx.Time + 1).AddOp(OP_CHECKLOCKTIMEVERIFY).Script(), ErrLockTime},
+		{"signature", NewBuilder().AddData(recipient.sign(t, ctx.SigHash)).Script(),
+			NewBuilder().AddData(recipient.pub).AddOp(OP_CHECKSIG).Script(), nil},
+		{"signature of another key", NewBuilder().AddData(other.sign(t, ctx.SigHash)).Script(),
+			NewBuilder().AddData(recipient.pub).AddOp(OP_CHECKSIG).Script(), ErrVerifyFailed},
+		{"signature of another hash", NewBuilder().AddData(recipient.sign(t, sha256.Sum256([]byte("other")))).Script(),
+			NewBuilder().AddData(recipient.pub).AddOp(OP_CHECKSIGVERIFY).AddOp(OP_1).Script(), ErrVerifyFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Verify(tt.unlock, tt.lock, ctx)
+			if tt.err == nil && err != nil {
+				t.Errorf("Verify = %v, want success", err)
+			}
+			if tt.err != nil && !errors.Is(err, tt.err) {
+				t.Errorf("Verify = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestVerifyRejects(t *testing.T) {
+	ctx := &Context{}
+	tests := []struct {
+		name   string
+		unlock Script
+		lock   Script
+	}{
+		{"unlock with opcodes", ops(OP_1, OP_DUP), ops(OP_EQUAL)},
+		{"early return", Script{}, ops(OP_1, OP_RETURN)},
+		{"unknown opcode", Script{}, ops(0xff)},
+		{"truncated push", Script{}, Script{0x05, 0x01}},
+		{"negative lock time", Script{}, NewBuilder().AddInt(-1).AddOp(OP_CHECKLOCKTIMEVERIFY).Script()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := Verify(tt.unlock, tt.lock, ctx); err == nil {
+				t.Error("Verify accepted the spend")
+			}
+		})
+	}
+}
+
+func TestEngineStackLimit(t *testing.T) {
+	e := NewEngine(&Context{})
+	s := NewBuilder()
+	for i := 0; i <= MAX_STACK_SIZE; i++ {
+		s.AddOp(OP_1)
+	}
+	if err := e.Execute(s.Script()); err == nil {
+		t.Errorf("pushed %d items past the limit of %d", len(e.Stack()), MAX_STACK_SIZE)
+	}
+}
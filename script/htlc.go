@@ -0,0 +1,63 @@
+package script
+
+import "errors"
+
+// hashed time lock contract:
+//
+//	OP_IF
+//	    OP_SHA256 <hash> OP_EQUALVERIFY <recipient pubkey>
+//	OP_ELSE
+//	    <lock time> OP_CHECKLOCKTIMEVERIFY OP_DROP <refund pubkey>
+//	OP_ENDIF
+//	OP_CHECKSIG
+//
+// the recipient claims with "<sig> <preimage> OP_1", the refund key
+// gets the funds back after the lock time with "<sig> OP_0"
+func HTLCScript(hashLock []byte, recipientPub []byte, refundPub []byte, lockTime int64) Script {
+	return NewBuilder().
+		AddOp(OP_IF).
+		AddOp(OP_SHA256).AddData(hashLock).AddOp(OP_EQUALVERIFY).AddData(recipientPub).
+		AddOp(OP_ELSE).
+		AddInt(lockTime).AddOp(OP_CHECKLOCKTIMEVERIFY).AddOp(OP_DROP).AddData(refundPub).
+		AddOp(OP_ENDIF).
+		AddOp(OP_CHECKSIG).
+		Script()
+}
+
+var ErrNotHTLC = errors.New("script is not a htlc")
+
+// extracts the parameters of a script built by HTLCScript
+func ParseHTLC(s Script) (hashLock []byte, recipientPub []byte, refundPub []byte, lockTime int64, err error) {
+	ins, err := s.parse()
+	if err != nil {
+		return nil, nil, nil, 0, err
+	}
+	ops := []byte{OP_IF, OP_SHA256, 0, OP_EQUALVERIFY, 0, OP_ELSE, 0,
+		OP_CHECKLOCKTIMEVERIFY, OP_DROP, 0, OP_ENDIF, OP_CHECKSIG}
+	if len(ins) != len(ops) {
+		return nil, nil, nil, 0, ErrNotHTLC
+	}
+	for i, op := range ops {
+		if op == 0 {
+			continue
+		}
+		if ins[i].data != nil || ins[i].op != op {
+			return nil, nil, nil, 0, ErrNotHTLC
+		}
+	}
+	if len(ins[2].data) != 32 || len(ins[4].data) != 64 || len(ins[9].data) != 64 {
+		return nil, nil, nil, 0, ErrNotHTLC
+	}
+	lock := ins[6]
+	if lock.data != nil {
+		lockTime, err = decodeNum(lock.data, 5)
+		if err != nil {
+			return nil, nil, nil, 0, err
+		}
+	} else if isSmallInt(lock.op) {
+		lockTime = smallIntValue(lock.op)
+	} else {
+		return nil, nil, nil, 0, ErrNotHTLC
+	}
+	return ins[2].data, ins[4].data, ins[9].data, lockTime, nil
+}
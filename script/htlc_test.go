@@ -0,0 +1,83 @@
+package script
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"testing"
+)
+
+func TestHTLCSpends(t *testing.T) {
+	recipient, refund := newTestKey(t), newTestKey(t)
+	preimage := []byte("secret")
+	hashLock := sha256.Sum256(preimage)
+	lock := HTLCScript(hashLock[:], recipient.pub, refund.pub, 100)
+	sigHash := sha256.Sum256([]byte("spend"))
+	claim := func(k *testKey, preimage []byte) Script {
+		return NewBuilder().AddData(k.sign(t, sigHash)).AddData(preimage).AddOp(OP_1).Script()
+	}
+	refundWith := func(k *testKey) Script {
+		return NewBuilder().AddData(k.sign(t, sigHash)).AddOp(OP_0).Script()
+	}
+
+	// the claim doesn't wait for the lock time, the refund does
+	for _, height := range []int64{1, 100} {
+		ctx := &Context{SigHash: sigHash, Height: height}
+		if err := Verify(claim(recipient, preimage), lock, ctx); err != nil {
+			t.Errorf("claim at height %d: %v", height, err)
+		}
+		if err := Verify(claim(refund, preimage), lock, ctx); !errors.Is(err, ErrVerifyFailed) {
+			t.Errorf("refund key claimed at height %d: %v", height, err)
+		}
+		if err := Verify(claim(recipient, []byte("guess")), lock, ctx); !errors.Is(err, ErrVerifyFailed) {
+			t.Errorf("claim without the preimage at height %d: %v", height, err)
+		}
+		if err := Verify(refundWith(recipient), lock, ctx); err == nil {
+			t.Errorf("recipient took the refund at height %d", height)
+		}
+	}
+	if err := Verify(refundWith(refund), lock, &Context{SigHash: sigHash, Height: 99}); !errors.Is(err, ErrLockTime) {
+		t.Errorf("refund before the lock time: %v", err)
+	}
+	if err := Verify(refundWith(refund), lock, &Context{SigHash: sigHash, Height: 100}); err != nil {
+		t.Errorf("refund at the lock time: %v", err)
+	}
+
+	// a timestamp lock time is checked against the block time
+	at := int64(1700000000)
+	timeLock := HTLCScript(hashLock[:], recipient.pub, refund.pub, at)
+	if err := Verify(refundWith(refund), timeLock, &Context{SigHash: sigHash, Height: 1 << 20, Time: at - 1}); !errors.Is(err, ErrLockTime) {
+		t.Errorf("refund before the lock timestamp: %v", err)
+	}
+	if err := Verify(refundWith(refund), timeLock, &Context{SigHash: sigHash, Time: at}); err != nil {
+		t.Errorf("refund at the lock timestamp: %v", err)
+	}
+}
+
+func TestParseHTLC(t *testing.T) {
+	recipient, refund := newTestKey(t), newTestKey(t)
+	hashLock := sha256.Sum256([]byte("secret"))
+	for _, lockTime := range []int64{0, 16, 100, 1700000000} {
+		h, r, f, l, err := ParseHTLC(HTLCScript(hashLock[:], recipient.pub, refund.pub, lockTime))
+		if err != nil {
+			t.Fatalf("lock time %d: %v", lockTime, err)
+		}
+		if !bytes.Equal(h, hashLock[:]) || !bytes.Equal(r, recipient.pub) || !bytes.Equal(f, refund.pub) || l != lockTime {
+			t.Errorf("lock time %d: parsed %d and other keys", lockTime, l)
+		}
+	}
+
+	swapped := HTLCScript(hashLock[:], recipient.pub, refund.pub, 100)
+	swapped[len(swapped)-1] = OP_CHECKSIGVERIFY
+	for name, s := range map[string]Script{
+		"pay to key":       NewBuilder().AddData(recipient.pub).AddOp(OP_CHECKSIG).Script(),
+		"short hash":       HTLCScript(hashLock[:20], recipient.pub, refund.pub, 100),
+		"short key":        HTLCScript(hashLock[:], recipient.pub[:33], refund.pub, 100),
+		"other last op":    swapped,
+		"truncated script": HTLCScript(hashLock[:], recipient.pub, refund.pub, 100)[:40],
+	} {
+		if _, _, _, _, err := ParseHTLC(s); err == nil {
+			t.Errorf("%s parsed as a htlc", name)
+		}
+	}
+}
@@ -0,0 +1,241 @@
+package script
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/btcsuite/btcutil/base58"
+	"golang.org/x/crypto/ripemd160"
+)
+
+// version byte of script addresses, same as bitcoin p2sh ("3...")
+const SCRIPT_ADDRESS_VERSION = 0x05
+
+const MAX_SCRIPT_SIZE = 10000
+
+type Script []byte
+
+type instruction struct {
+	op   byte
+	data []byte
+}
+
+var ErrMalformedScript = errors.New("malformed script")
+
+func FromHex(s string) (Script, error) {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return Script(b), nil
+}
+
+func (s Script) Hex() string {
+	return hex.EncodeToString(s)
+}
+
+// splits the script into opcodes and their push data
+func (s Script) parse() ([]instruction, error) {
+	if len(s) > MAX_SCRIPT_SIZE {
+		return nil, fmt.Errorf("script size %d exceeds %d", len(s), MAX_SCRIPT_SIZE)
+	}
+	ins := make([]instruction, 0)
+	for i := 0; i < len(s); {
+		op := s[i]
+		i++
+		var n int
+		switch {
+		case op >= 0x01 && op < OP_PUSHDATA1:
+			n = int(op)
+		case op == OP_PUSHDATA1:
+			if i+1 > len(s) {
+				return nil, ErrMalformedScript
+			}
+			n = int(s[i])
+			i++
+		case op == OP_PUSHDATA2:
+			if i+2 > len(s) {
+				return nil, ErrMalformedScript
+			}
+			n = int(binary.LittleEndian.Uint16(s[i : i+2]))
+			i += 2
+		default:
+			ins = append(ins, instruction{op: op})
+			continue
+		}
+		if i+n > len(s) {
+			return nil, ErrMalformedScript
+		}
+		ins = append(ins, instruction{op: op, data: s[i : i+n]})
+		i += n
+	}
+	return ins, nil
+}
+
+// true when the script only pushes data, required for unlock scripts
+func (s Script) IsPushOnly() bool {
+	ins, err := s.parse()
+	if err != nil {
+		return false
+	}
+	for _, in := range ins {
+		if in.op > OP_16 {
+			return false
+		}
+	}
+	return true
+}
+
+// human readable form of the script, data pushes are printed as hex
+func (s Script) Disassemble() string {
+	ins, err := s.parse()
+	if err != nil {
+		return fmt.Sprintf("[error: %v]", err)
+	}
+	parts := make([]string, 0, len(ins))
+	for _, in := range ins {
+		switch {
+		case in.data != nil:
+			parts = append(parts, hex.EncodeToString(in.data))
+		case in.op >= OP_1 && in.op <= OP_16:
+			parts = append(parts, fmt.Sprintf("OP_%d", smallIntValue(in.op)))
+		default:
+			name, ok := opcodeNames[in.op]
+			if !ok {
+				name = fmt.Sprintf("OP_UNKNOWN_%#x", in.op)
+			}
+			parts = append(parts, name)
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+func (s Script) String() string {
+	return s.Disassemble()
+}
+
+func Hash160(b []byte) []byte {
+	h := sha256.Sum256(b)
+	r := ripemd160.New()
+	r.Write(h[:])
+	return r.Sum(nil)
+}
+
+// pay-to-script address: funds sent here can only be spent by
+// providing the script itself and an unlock script satisfying it
+func (s Script) Address() string {
+	vd := make([]byte, 21)
+	vd[0] = SCRIPT_ADDRESS_VERSION
+	copy(vd[1:], Hash160(s))
+	first := sha256.Sum256(vd)
+	second := sha256.Sum256(first[:])
+	b := make([]byte, 25)
+	copy(b[:21], vd)
+	copy(b[21:], second[:4])
+	return base58.Encode(b)
+}
+
+func IsScriptAddress(address string) bool {
+	b := base58.Decode(address)
+	if len(b) != 25 || b[0] != SCRIPT_ADDRESS_VERSION {
+		return false
+	}
+	first := sha256.Sum256(b[:21])
+	second := sha256.Sum256(first[:])
+	return string(second[:4]) == string(b[21:])
+}
+
+type Builder struct {
+	script Script
+}
+
+func NewBuilder() *Builder {
+	return &Builder{script: Script{}}
+}
+
+func (b *Builder) AddOp(op byte) *Builder {
+	b.script = append(b.script, op)
+	return b
+}
+
+func (b *Builder) AddData(data []byte) *Builder {
+	n := len(data)
+	switch {
+	case n == 0:
+		b.script = append(b.script, OP_0)
+		return b
+	case n < int(OP_PUSHDATA1):
+		b.script = append(b.script, byte(n))
+	case n <= 0xff:
+		b.script = append(b.script, OP_PUSHDATA1, byte(n))
+	default:
+		b.script = append(b.script, OP_PUSHDATA2, byte(n), byte(n>>8))
+	}
+	b.script = append(b.script, data...)
+	return b
+}
+
+func (b *Builder) AddInt(n int64) *Builder {
+	switch {
+	case n == 0:
+		return b.AddOp(OP_0)
+	case n == -1:
+		return b.AddOp(OP_1NEGATE)
+	case n >= 1 && n <= 16:
+		return b.AddOp(OP_1 + byte(n-1))
+	}
+	return b.AddData(encodeNum(n))
+}
+
+func (b *Builder) Script() Script {
+	return b.script
+}
+
+// little endian sign-magnitude encoding used for numbers on the stack
+func encodeNum(n int64) []byte {
+	if n == 0 {
+		return []byte{}
+	}
+	neg := n < 0
+	abs := uint64(n)
+	if neg {
+		abs = uint64(-n)
+	}
+	r := make([]byte, 0, 9)
+	for abs > 0 {
+		r = append(r, byte(abs&0xff))
+		abs >>= 8
+	}
+	if r[len(r)-1]&0x80 != 0 {
+		if neg {
+			r = append(r, 0x80)
+		} else {
+			r = append(r, 0x00)
+		}
+	} else if neg {
+		r[len(r)-1] |= 0x80
+	}
+	return r
+}
+
+func decodeNum(b []byte, maxLen int) (int64, error) {
+	if len(b) > maxLen {
+		return 0, fmt.Errorf("number of %d bytes exceeds %d", len(b), maxLen)
+	}
+	if len(b) == 0 {
+		return 0, nil
+	}
+	var n int64
+	for i, v := range b {
+		n |= int64(v) << uint(8*i)
+	}
+	if b[len(b)-1]&0x80 != 0 {
+		n &= ^(int64(0x80) << uint(8*(len(b)-1)))
+		return -n, nil
+	}
+	return n, nil
+}
@@ -0,0 +1,201 @@
+package stratum
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const DIAL_TIMEOUT = 10 * time.Second
+
+// a miner's connection to a pool. jobs and difficulty changes arrive on
+// their own, calls wait for the pool's answer
+type Client struct {
+	conn       net.Conn
+	writeMux   sync.Mutex
+	nextID     atomic.Int64
+	pending    map[int64]chan *Response
+	pendingMux sync.Mutex
+	jobs       chan *Job
+	difficulty atomic.Int64
+	done       chan struct{}
+	err        error
+}
+
+func Dial(addr string) (*Client, error) {
+	conn, err := net.DialTimeout("tcp", addr, DIAL_TIMEOUT)
+	if err != nil {
+		return nil, err
+	}
+	c := &Client{
+		conn:    conn,
+		pending: make(map[int64]chan *Response),
+		jobs:    make(chan *Job, 8),
+		done:    make(chan struct{}),
+	}
+	go c.read()
+	return c, nil
+}
+
+func (c *Client) Subscribe() (*SubscribeResult, error) {
+	var r SubscribeResult
+	if err := c.call(METHOD_SUBSCRIBE, []interface{}{}, &r); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+func (c *Client) Authorize(worker string, password string) error {
+	var ok bool
+	if err := c.call(METHOD_AUTHORIZE, []interface{}{worker, password}, &ok); err != nil {
+		return err
+	}
+	if !ok {
+		return &Error{Code: ERR_UNAUTHORIZED, Message: "unauthorized worker"}
+	}
+	return nil
+}
+
+// nil if the pool took the share
+func (c *Client) Submit(worker string, jobID string, nonce int) error {
+	var ok bool
+	return c.call(METHOD_SUBMIT, []interface{}{worker, jobID, nonce}, &ok)
+}
+
+// jobs in the order the pool sent them, closed with the connection
+func (c *Client) Jobs() <-chan *Job {
+	return c.jobs
+}
+
+// the share difficulty the pool set last
+func (c *Client) Difficulty() int {
+	return int(c.difficulty.Load())
+}
+
+// closed when the connection ends, Err then tells why
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+func (c *Client) Err() error {
+	<-c.done
+	return c.err
+}
+
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+func (c *Client) call(method string, params interface{}, out interface{}) error {
+	id := c.nextID.Add(1)
+	p, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	wait := make(chan *Response, 1)
+	c.pendingMux.Lock()
+	c.pending[id] = wait
+	c.pendingMux.Unlock()
+	defer func() {
+		c.pendingMux.Lock()
+		delete(c.pending, id)
+		c.pendingMux.Unlock()
+	}()
+
+	if err := c.write(&Request{ID: json.RawMessage(strconv.FormatInt(id, 10)), Method: method, Params: p}); err != nil {
+		return err
+	}
+	select {
+	case r := <-wait:
+		if r.Error != nil {
+			return r.Error
+		}
+		return json.Unmarshal(r.Result, out)
+	case <-c.done:
+		return fmt.Errorf("%s: %w", method, c.err)
+	}
+}
+
+func (c *Client) write(v interface{}) error {
+	m, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	c.writeMux.Lock()
+	defer c.writeMux.Unlock()
+	_, err = c.conn.Write(append(m, '\n'))
+	return err
+}
+
+// answers go to the call waiting for them, notifications to Jobs and
+// Difficulty
+func (c *Client) read() {
+	defer close(c.jobs)
+	defer close(c.done)
+	scanner := bufio.NewScanner(c.conn)
+	scanner.Buffer(make([]byte, 4096), MAX_LINE_SIZE)
+	for scanner.Scan() {
+		var msg struct {
+			Response
+			Method string          `json:"method"`
+			Params json.RawMessage `json:"params"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			log.Printf("ERROR: stratum: %v", err)
+			continue
+		}
+		if msg.Method != "" {
+			c.notification(msg.Method, msg.Params)
+			continue
+		}
+		id, err := strconv.ParseInt(string(msg.ID), 10, 64)
+		if err != nil {
+			continue
+		}
+		c.pendingMux.Lock()
+		wait, ok := c.pending[id]
+		c.pendingMux.Unlock()
+		if ok {
+			wait <- &msg.Response
+		}
+	}
+	c.err = scanner.Err()
+	if c.err == nil {
+		c.err = errors.New("connection closed by the pool")
+	}
+}
+
+func (c *Client) notification(method string, params json.RawMessage) {
+	switch method {
+	case METHOD_SET_DIFFICULTY:
+		var p []int
+		if err := json.Unmarshal(params, &p); err != nil || len(p) != 1 {
+			log.Printf("ERROR: stratum: bad %s params", method)
+			return
+		}
+		c.difficulty.Store(int64(p[0]))
+	case METHOD_NOTIFY:
+		var p []*Job
+		if err := json.Unmarshal(params, &p); err != nil || len(p) != 1 {
+			log.Printf("ERROR: stratum: bad %s params", method)
+			return
+		}
+		select {
+		case c.jobs <- p[0]:
+		default:
+			// a miner that fell behind only needs the newest job
+			select {
+			case <-c.jobs:
+			default:
+			}
+			c.jobs <- p[0]
+		}
+	}
+}
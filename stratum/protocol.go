@@ -0,0 +1,135 @@
+package stratum
+
+import (
+	"blockchain/block"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// messages are JSON objects, one per line, in the style of stratum v1:
+// requests carry an id, notifications from the pool have a null id and
+// errors are [code, message, null]
+const (
+	METHOD_SUBSCRIBE      = "mining.subscribe"
+	METHOD_AUTHORIZE      = "mining.authorize"
+	METHOD_SUBMIT         = "mining.submit"
+	METHOD_NOTIFY         = "mining.notify"
+	METHOD_SET_DIFFICULTY = "mining.set_difficulty"
+)
+
+// error codes as stratum pools use them
+const (
+	ERR_OTHER           = 20
+	ERR_JOB_NOT_FOUND   = 21
+	ERR_DUPLICATE_SHARE = 22
+	ERR_LOW_DIFFICULTY  = 23
+	ERR_UNAUTHORIZED    = 24
+	ERR_NOT_SUBSCRIBED  = 25
+)
+
+// a connection only submits nonces whose upper bits are its extranonce,
+// so no two connections search the same nonces
+const EXTRANONCE_BITS = 32
+
+// longest line either side reads
+const MAX_LINE_SIZE = 64 * 1024
+
+type Request struct {
+	ID     json.RawMessage `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+}
+
+type Response struct {
+	ID     json.RawMessage `json:"id"`
+	Result json.RawMessage `json:"result"`
+	Error  *Error          `json:"error"`
+}
+
+type Error struct {
+	Code    int
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("stratum error %d: %s", e.Code, e.Message)
+}
+
+func (e *Error) MarshalJSON() ([]byte, error) {
+	return json.Marshal([]interface{}{e.Code, e.Message, nil})
+}
+
+func (e *Error) UnmarshalJSON(m []byte) error {
+	var v []json.RawMessage
+	if err := json.Unmarshal(m, &v); err != nil {
+		return err
+	}
+	if len(v) < 2 {
+		return errors.New("stratum error needs a code and a message")
+	}
+	if err := json.Unmarshal(v[0], &e.Code); err != nil {
+		return err
+	}
+	return json.Unmarshal(v[1], &e.Message)
+}
+
+// true if err is a stratum Error with code
+func IsCode(err error, code int) bool {
+	var se *Error
+	return errors.As(err, &se) && se.Code == code
+}
+
+type SubscribeResult struct {
+	SubscriptionID string `json:"subscription_id"`
+	Extranonce     int    `json:"extranonce"`
+}
+
+// the header of a block template, all a worker needs to search nonces.
+// with CleanJobs set shares for earlier jobs are no longer taken
+type Job struct {
+	JobID      string `json:"job_id"`
+	Height     int    `json:"height"`
+	PrevHash   string `json:"prev_hash"`
+	MerkleRoot string `json:"merkle_root"`
+	Timestamp  int64  `json:"timestamp"`
+	CleanJobs  bool   `json:"clean_jobs"`
+}
+
+func NewJob(id string, t *block.BlockTemplate, clean bool) *Job {
+	return &Job{
+		JobID:      id,
+		Height:     t.Height,
+		PrevHash:   hex.EncodeToString(t.PrevHash[:]),
+		MerkleRoot: hex.EncodeToString(t.MerkleRoot[:]),
+		Timestamp:  t.Timestamp,
+		CleanJobs:  clean,
+	}
+}
+
+// the job as a template without transactions, enough to check nonces
+func (j *Job) Template(difficulty int) (*block.BlockTemplate, error) {
+	t := &block.BlockTemplate{Height: j.Height, Timestamp: j.Timestamp, Difficulty: difficulty}
+	prevHash, err := hex.DecodeString(j.PrevHash)
+	if err != nil || len(prevHash) != 32 {
+		return nil, errors.New("prev_hash must be 64 hex characters")
+	}
+	merkleRoot, err := hex.DecodeString(j.MerkleRoot)
+	if err != nil || len(merkleRoot) != 32 {
+		return nil, errors.New("merkle_root must be 64 hex characters")
+	}
+	t.PrevHash = [32]byte(prevHash)
+	t.MerkleRoot = [32]byte(merkleRoot)
+	return t, nil
+}
+
+// first nonce of a connection's range
+func FirstNonce(extranonce int) int {
+	return extranonce << EXTRANONCE_BITS
+}
+
+// the extranonce a nonce belongs to
+func Extranonce(nonce int) int {
+	return nonce >> EXTRANONCE_BITS
+}
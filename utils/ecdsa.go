@@ -3,9 +3,13 @@ package utils
 import (
 	"crypto/ecdsa"
 	"crypto/elliptic"
+	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
 	"math/big"
+
+	"github.com/btcsuite/btcutil/base58"
+	"golang.org/x/crypto/ripemd160"
 )
 
 type Signature struct {
@@ -15,8 +19,9 @@ type Signature struct {
 	S *big.Int
 }
 
+// fixed 128 hex characters, R then S, as SignatureFromString reads it
 func (s Signature) String() string {
-	return fmt.Sprintf("%x%x", s.R, s.S)
+	return fmt.Sprintf("%x", s.Bytes())
 }
 
 func String2BigIntTuple(s string) (big.Int, big.Int) {
@@ -47,3 +52,63 @@ func PrivateKeyFromString(s string, publicKey *ecdsa.PublicKey) *ecdsa.PrivateKe
 	_ = bi.SetBytes(b)
 	return &ecdsa.PrivateKey{*publicKey, &bi}
 }
+
+// half the order of the curve, the largest S a valid signature has
+var halfOrder = new(big.Int).Rsh(elliptic.P256().Params().N, 1)
+
+// (R, N-S) verifies just as (R, S) does, so only the low S form is taken,
+// otherwise anyone could make a second signature of a signed transfer
+func (s *Signature) LowS() bool {
+	return s.S.Cmp(halfOrder) <= 0
+}
+
+// the low S form of s
+func (s *Signature) ToLowS() *Signature {
+	if s.LowS() {
+		return s
+	}
+	return &Signature{R: s.R, S: new(big.Int).Sub(elliptic.P256().Params().N, s.S)}
+}
+
+// fixed size 64 byte R||S form used inside scripts
+func (s *Signature) Bytes() []byte {
+	b := make([]byte, 64)
+	s.R.FillBytes(b[:32])
+	s.S.FillBytes(b[32:])
+	return b
+}
+
+// fixed size 64 byte X||Y form used inside scripts
+func PublicKeyBytes(publicKey *ecdsa.PublicKey) []byte {
+	b := make([]byte, 64)
+	publicKey.X.FillBytes(b[:32])
+	publicKey.Y.FillBytes(b[32:])
+	return b
+}
+
+// the blockchain address of a key: base58 of a version byte, the
+// ripemd160 of the sha256 of the key and a 4 byte checksum
+func AddressFromPublicKey(publicKey *ecdsa.PublicKey) string {
+	//address calculation
+	h2 := sha256.New()
+	h2.Write(publicKey.X.Bytes())
+	h2.Write(publicKey.Y.Bytes())
+	digest2 := h2.Sum(nil)
+	h3 := ripemd160.New()
+	h3.Write(digest2)
+	digest3 := h3.Sum(nil)
+	vd4 := make([]byte, 21)
+	vd4[0] = 0x00
+	copy(vd4[1:], digest3[:])
+	h5 := sha256.New()
+	h5.Write(vd4)
+	digest5 := h5.Sum(nil)
+	h6 := sha256.New()
+	h6.Write(digest5)
+	digest6 := h6.Sum(nil)
+	chsum := digest6[:4]
+	dc8 := make([]byte, 25)
+	copy(dc8[:21], vd4[:])
+	copy(dc8[21:], chsum[:])
+	return base58.Encode(dc8)
+}
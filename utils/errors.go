@@ -0,0 +1,94 @@
+package utils
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// machine readable error codes shared by both servers
+const (
+	ERR_INVALID_JSON       = "invalid_json"
+	ERR_MISSING_FIELDS     = "missing_fields"
+	ERR_INVALID_FIELD      = "invalid_field"
+	ERR_METHOD_NOT_ALLOWED = "method_not_allowed"
+	ERR_UNAUTHORIZED       = "unauthorized"
+	ERR_FORBIDDEN          = "forbidden"
+	ERR_NOT_FOUND          = "not_found"
+	ERR_CONFLICT           = "conflict"
+	ERR_GONE               = "gone"
+	ERR_UNPROCESSABLE      = "unprocessable"
+	ERR_UNAVAILABLE        = "unavailable"
+	ERR_INTERNAL           = "internal"
+)
+
+const REQUEST_ID_HEADER = "X-Request-ID"
+
+// body of every error reply. Message stays where {"message":"fail"} used
+// to be, so callers that only look at it keep working
+type ErrorResponse struct {
+	Code      string      `json:"code"`
+	Message   string      `json:"message"`
+	Details   interface{} `json:"details,omitempty"`
+	RequestID string      `json:"request_id,omitempty"`
+}
+
+type requestIDKey struct{}
+
+// gives every request an id, taken from the X-Request-ID header when the
+// caller sent one, and echoes it back
+func WithRequestID(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		id := req.Header.Get(REQUEST_ID_HEADER)
+		if id == "" || len(id) > 64 {
+			b := make([]byte, 8)
+			rand.Read(b)
+			id = hex.EncodeToString(b)
+		}
+		w.Header().Set(REQUEST_ID_HEADER, id)
+		ctx := context.WithValue(req.Context(), requestIDKey{}, id)
+		h.ServeHTTP(w, req.WithContext(ctx))
+	})
+}
+
+func RequestID(req *http.Request) string {
+	return RequestIDFromContext(req.Context())
+}
+
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+func JsonError(w http.ResponseWriter, req *http.Request, status int, code string, message string, details interface{}) {
+	id := RequestID(req)
+	log.Printf("ERROR: %s %s [%s]: %s", req.Method, req.URL.Path, id, message)
+	m, _ := json.Marshal(&ErrorResponse{
+		Code:      code,
+		Message:   message,
+		Details:   details,
+		RequestID: id,
+	})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(m)
+}
+
+func MethodNotAllowed(w http.ResponseWriter, req *http.Request, allowed ...string) {
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	JsonError(w, req, http.StatusMethodNotAllowed, ERR_METHOD_NOT_ALLOWED,
+		"method "+req.Method+" not allowed", map[string][]string{"allowed": allowed})
+}
+
+// true if s is exactly n hex characters
+func IsHex(s string, n int) bool {
+	if len(s) != n {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
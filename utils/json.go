@@ -2,10 +2,12 @@ package utils
 
 import "encoding/json"
 
+type StatusResponse struct {
+	Message string `json:"message"`
+}
+
 func JsonStatus(message string) []byte {
-	m, _ := json.Marshal(struct {
-		Message string `json:"message"`
-	}{
+	m, _ := json.Marshal(&StatusResponse{
 		Message: message,
 	})
 	return m
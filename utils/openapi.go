@@ -0,0 +1,478 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	OPENAPI_VERSION = "3.0.3"
+	// request bodies larger than this are turned away before decoding
+	MAX_BODY_SIZE = 1 << 20
+)
+
+// OpenAPI 3 document of a server. schemas are generated from the Go
+// types the handlers decode and encode, so they can't drift apart.
+// struct fields are read through their json tags, non pointer fields
+// without omitempty are required, and an openapi tag adds
+//
+//	required   for pointer fields that must be set
+//	hex=N      for strings of exactly N hex characters
+type OpenAPI struct {
+	OpenAPI    string                           `json:"openapi"`
+	Info       OpenAPIInfo                      `json:"info"`
+	Paths      map[string]map[string]*Operation `json:"paths"`
+	Components Components                       `json:"components"`
+
+	types map[string]reflect.Type
+	// compiled while the document is built, read only once it's served
+	patterns map[string]*regexp.Regexp
+}
+
+type OpenAPIInfo struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+type Components struct {
+	Schemas map[string]*Schema `json:"schemas"`
+}
+
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Pattern              string             `json:"pattern,omitempty"`
+	Nullable             bool               `json:"nullable,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	MinItems             *int               `json:"minItems,omitempty"`
+	MaxItems             *int               `json:"maxItems,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+}
+
+type Operation struct {
+	Summary     string               `json:"summary,omitempty"`
+	Parameters  []*Parameter         `json:"parameters,omitempty"`
+	RequestBody *RequestBody         `json:"requestBody,omitempty"`
+	Responses   map[string]*Response `json:"responses"`
+}
+
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+type RequestBody struct {
+	Required bool                  `json:"required"`
+	Content  map[string]*MediaType `json:"content"`
+}
+
+type Response struct {
+	Description string                `json:"description"`
+	Content     map[string]*MediaType `json:"content,omitempty"`
+}
+
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+// query parameter of a Route, Type is a json schema type
+type Param struct {
+	Name        string
+	Description string
+	Required    bool
+	Type        string
+}
+
+// what Add needs to describe one method on one path. Body and Response
+// are values of the Go types that are decoded and encoded, nil for none
+type Route struct {
+	Summary  string
+	Query    []Param
+	Body     interface{}
+	Status   int
+	Response interface{}
+	// content type of the success response when it isn't JSON
+	ContentType string
+	Errors      []int
+}
+
+func NewOpenAPI(title string, version string) *OpenAPI {
+	o := &OpenAPI{
+		OpenAPI:    OPENAPI_VERSION,
+		Info:       OpenAPIInfo{Title: title, Version: version},
+		Paths:      make(map[string]map[string]*Operation),
+		Components: Components{Schemas: make(map[string]*Schema)},
+		types:      make(map[string]reflect.Type),
+		patterns:   make(map[string]*regexp.Regexp),
+	}
+	o.SchemaOf(ErrorResponse{})
+	return o
+}
+
+func (o *OpenAPI) Add(method string, p string, r Route) {
+	op := &Operation{Summary: r.Summary, Responses: make(map[string]*Response)}
+	for _, q := range r.Query {
+		op.Parameters = append(op.Parameters, &Parameter{
+			Name:        q.Name,
+			In:          "query",
+			Description: q.Description,
+			Required:    q.Required,
+			Schema:      &Schema{Type: q.Type},
+		})
+	}
+	if r.Body != nil {
+		op.RequestBody = &RequestBody{
+			Required: true,
+			Content:  map[string]*MediaType{"application/json": {Schema: o.SchemaOf(r.Body)}},
+		}
+	}
+	status := r.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	success := &Response{Description: http.StatusText(status)}
+	if r.Response != nil {
+		success.Content = map[string]*MediaType{"application/json": {Schema: o.SchemaOf(r.Response)}}
+	} else if r.ContentType != "" {
+		success.Content = map[string]*MediaType{r.ContentType: {Schema: &Schema{Type: "string"}}}
+	}
+	op.Responses[strconv.Itoa(status)] = success
+	failures := append([]int{http.StatusMethodNotAllowed}, r.Errors...)
+	if op.RequestBody != nil || len(op.Parameters) > 0 {
+		failures = append(failures, http.StatusBadRequest)
+	}
+	for _, e := range failures {
+		op.Responses[strconv.Itoa(e)] = &Response{
+			Description: http.StatusText(e),
+			Content:     map[string]*MediaType{"application/json": {Schema: o.SchemaOf(ErrorResponse{})}},
+		}
+	}
+	if o.Paths[p] == nil {
+		o.Paths[p] = make(map[string]*Operation)
+	}
+	o.Paths[p][strings.ToLower(method)] = op
+}
+
+// schema of the json encoding of v. named structs go to the components
+// and are referenced from there
+func (o *OpenAPI) SchemaOf(v interface{}) *Schema {
+	return o.schemaOf(reflect.TypeOf(v))
+}
+
+func (o *OpenAPI) schemaOf(t reflect.Type) *Schema {
+	if t == reflect.TypeOf(json.RawMessage{}) {
+		return &Schema{}
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		return o.schemaOf(t.Elem())
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "integer", Format: "int64"}
+	case reflect.Float32:
+		return &Schema{Type: "number", Format: "float"}
+	case reflect.Float64:
+		return &Schema{Type: "number", Format: "double"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte"}
+		}
+		return &Schema{Type: "array", Items: o.schemaOf(t.Elem()), Nullable: true}
+	case reflect.Array:
+		n := t.Len()
+		return &Schema{Type: "array", Items: o.schemaOf(t.Elem()), MinItems: &n, MaxItems: &n}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: o.schemaOf(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return o.structSchema(t)
+		}
+		name := o.componentName(t)
+		if _, ok := o.Components.Schemas[name]; !ok {
+			// placeholder first, types may refer to themselves
+			o.Components.Schemas[name] = &Schema{Type: "object"}
+			o.Components.Schemas[name] = o.structSchema(t)
+		}
+		return &Schema{Ref: "#/components/schemas/" + name}
+	}
+	// interfaces and anything else can hold any value
+	return &Schema{}
+}
+
+// the type name, prefixed with its package when another package already
+// took it
+func (o *OpenAPI) componentName(t reflect.Type) string {
+	name := t.Name()
+	if other, ok := o.types[name]; ok && other != t {
+		pkg := path.Base(t.PkgPath())
+		name = strings.ToUpper(pkg[:1]) + pkg[1:] + name
+	}
+	o.types[name] = t
+	return name
+}
+
+func (o *OpenAPI) structSchema(t reflect.Type) *Schema {
+	s := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, omitempty := f.Name, false
+		if tag := f.Tag.Get("json"); tag != "" {
+			if tag == "-" {
+				continue
+			}
+			parts := strings.Split(tag, ",")
+			if parts[0] != "" {
+				name = parts[0]
+			}
+			for _, opt := range parts[1:] {
+				omitempty = omitempty || opt == "omitempty"
+			}
+		}
+		if f.Anonymous && f.Type.Kind() == reflect.Struct && f.Tag.Get("json") == "" {
+			// embedded fields are flattened into the outer object
+			inner := o.structSchema(f.Type)
+			for k, v := range inner.Properties {
+				s.Properties[k] = v
+			}
+			s.Required = append(s.Required, inner.Required...)
+			continue
+		}
+		fs := o.schemaOf(f.Type)
+		required := f.Type.Kind() != reflect.Pointer && !omitempty
+		for _, opt := range strings.Split(f.Tag.Get("openapi"), ",") {
+			switch {
+			case opt == "required":
+				required = true
+			case strings.HasPrefix(opt, "hex="):
+				fs.Pattern = fmt.Sprintf("^[0-9a-fA-F]{%s}$", strings.TrimPrefix(opt, "hex="))
+				o.patterns[fs.Pattern] = regexp.MustCompile(fs.Pattern)
+			}
+		}
+		if f.Type.Kind() == reflect.Pointer && fs.Ref == "" {
+			fs.Nullable = true
+		}
+		s.Properties[name] = fs
+		if required {
+			s.Required = append(s.Required, name)
+		}
+	}
+	return s
+}
+
+// serves the document itself
+func (o *OpenAPI) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		m, _ := json.Marshal(o)
+		w.Header().Add("Content-Type", "application/json")
+		w.Write(m)
+	default:
+		MethodNotAllowed(w, req, http.MethodGet)
+	}
+}
+
+// checks query parameters and JSON bodies against the document before
+// the handler sees them. requests for paths or methods the document
+// doesn't know are passed on, the handlers answer those with 404 and 405
+func (o *OpenAPI) Validate(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		op := o.Paths[req.URL.Path][strings.ToLower(req.Method)]
+		if op == nil {
+			h.ServeHTTP(w, req)
+			return
+		}
+		query := req.URL.Query()
+		missing := make([]string, 0)
+		for _, p := range op.Parameters {
+			v := query.Get(p.Name)
+			if v == "" {
+				if p.Required {
+					missing = append(missing, p.Name)
+				}
+				continue
+			}
+			if reason := checkParam(p.Schema, v); reason != "" {
+				JsonError(w, req, http.StatusBadRequest, ERR_INVALID_FIELD, p.Name+" "+reason,
+					map[string]string{"field": p.Name})
+				return
+			}
+		}
+		if len(missing) > 0 {
+			JsonError(w, req, http.StatusBadRequest, ERR_MISSING_FIELDS, "missing fields",
+				map[string][]string{"fields": missing})
+			return
+		}
+		if op.RequestBody == nil {
+			h.ServeHTTP(w, req)
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, req.Body, MAX_BODY_SIZE))
+		if err != nil {
+			JsonError(w, req, http.StatusBadRequest, ERR_INVALID_JSON, err.Error(), nil)
+			return
+		}
+		var v interface{}
+		if err := json.Unmarshal(body, &v); err != nil {
+			JsonError(w, req, http.StatusBadRequest, ERR_INVALID_JSON, err.Error(), nil)
+			return
+		}
+		schema := op.RequestBody.Content["application/json"].Schema
+		if missing := o.missingFields(schema, v, ""); len(missing) > 0 {
+			JsonError(w, req, http.StatusBadRequest, ERR_MISSING_FIELDS, "missing fields",
+				map[string][]string{"fields": missing})
+			return
+		}
+		if field, reason := o.check(schema, v, ""); reason != "" {
+			message := reason
+			if field != "" {
+				message = field + " " + reason
+			}
+			JsonError(w, req, http.StatusBadRequest, ERR_INVALID_FIELD, message,
+				map[string]string{"field": field})
+			return
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		h.ServeHTTP(w, req)
+	})
+}
+
+func (o *OpenAPI) resolve(s *Schema) *Schema {
+	for s.Ref != "" {
+		s = o.Components.Schemas[strings.TrimPrefix(s.Ref, "#/components/schemas/")]
+	}
+	return s
+}
+
+// required properties that are absent, as dotted paths
+func (o *OpenAPI) missingFields(s *Schema, v interface{}, at string) []string {
+	s = o.resolve(s)
+	obj, ok := v.(map[string]interface{})
+	if !ok || s.Type != "object" {
+		return nil
+	}
+	missing := make([]string, 0)
+	for _, name := range s.Required {
+		if _, ok := obj[name]; !ok {
+			missing = append(missing, join(at, name))
+		}
+	}
+	for name, ps := range s.Properties {
+		if pv, ok := obj[name]; ok {
+			missing = append(missing, o.missingFields(ps, pv, join(at, name))...)
+		}
+	}
+	return missing
+}
+
+// the first value that doesn't match its schema and what is wrong with it
+func (o *OpenAPI) check(s *Schema, v interface{}, at string) (string, string) {
+	s = o.resolve(s)
+	if v == nil {
+		if s.Nullable || s.Type == "" {
+			return "", ""
+		}
+		return at, "must not be null"
+	}
+	switch s.Type {
+	case "object":
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return at, "must be an object"
+		}
+		for name, pv := range obj {
+			ps := s.Properties[name]
+			if ps == nil {
+				ps = s.AdditionalProperties
+			}
+			if ps == nil {
+				// unknown fields are ignored, like encoding/json does
+				continue
+			}
+			if field, reason := o.check(ps, pv, join(at, name)); reason != "" {
+				return field, reason
+			}
+		}
+	case "array":
+		list, ok := v.([]interface{})
+		if !ok {
+			return at, "must be an array"
+		}
+		if (s.MinItems != nil && len(list) < *s.MinItems) || (s.MaxItems != nil && len(list) > *s.MaxItems) {
+			return at, fmt.Sprintf("must have %d items", *s.MaxItems)
+		}
+		for i, item := range list {
+			if field, reason := o.check(s.Items, item, fmt.Sprintf("%s[%d]", at, i)); reason != "" {
+				return field, reason
+			}
+		}
+	case "string":
+		str, ok := v.(string)
+		if !ok {
+			return at, "must be a string"
+		}
+		if re := o.patterns[s.Pattern]; re != nil && !re.MatchString(str) {
+			return at, "must match " + s.Pattern
+		}
+	case "integer":
+		n, ok := v.(float64)
+		if !ok || n != float64(int64(n)) {
+			return at, "must be an integer"
+		}
+	case "number":
+		if _, ok := v.(float64); !ok {
+			return at, "must be a number"
+		}
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			return at, "must be a boolean"
+		}
+	}
+	return "", ""
+}
+
+func checkParam(s *Schema, v string) string {
+	switch s.Type {
+	case "integer":
+		if _, err := strconv.ParseInt(v, 10, 64); err != nil {
+			return "must be an integer"
+		}
+	case "number":
+		if _, err := strconv.ParseFloat(v, 64); err != nil {
+			return "must be a number"
+		}
+	case "boolean":
+		if _, err := strconv.ParseBool(v); err != nil {
+			return "must be true or false"
+		}
+	}
+	return ""
+}
+
+func join(at string, name string) string {
+	if at == "" {
+		return name
+	}
+	return at + "." + name
+}
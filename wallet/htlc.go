@@ -0,0 +1,98 @@
+package wallet
+
+import (
+	"blockchain/block"
+	"blockchain/script"
+	"blockchain/utils"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+)
+
+// hashed time lock contract. funds sent to Address can be claimed by the
+// recipient with the preimage of HashLock, or refunded to the refund key
+// once LockTime (block height or unix timestamp) has passed
+type HTLC struct {
+	Script   script.Script
+	Address  string
+	HashLock []byte
+	LockTime int64
+}
+
+func NewHTLC(recipientPublicKey *ecdsa.PublicKey, refundPublicKey *ecdsa.PublicKey,
+	hashLock [32]byte, lockTime int64) *HTLC {
+	s := script.HTLCScript(hashLock[:], utils.PublicKeyBytes(recipientPublicKey),
+		utils.PublicKeyBytes(refundPublicKey), lockTime)
+	return &HTLC{s, s.Address(), hashLock[:], lockTime}
+}
+
+func HTLCFromScript(s script.Script) (*HTLC, error) {
+	hashLock, _, _, lockTime, err := script.ParseHTLC(s)
+	if err != nil {
+		return nil, err
+	}
+	return &HTLC{s, s.Address(), hashLock, lockTime}, nil
+}
+
+// random secret and its hash, the secret is revealed on claim
+func NewPreimage() ([]byte, [32]byte) {
+	preimage := make([]byte, 32)
+	_, _ = rand.Read(preimage)
+	return preimage, sha256.Sum256(preimage)
+}
+
+// transaction moving the locked funds to recipient by revealing the preimage,
+// w must hold the recipient key of the contract
+func (h *HTLC) Claim(w *Wallet, chainID string, recipient string, value float32, preimage []byte) *block.TransactionRequest {
+	t := NewTransaction(w.PrivateKey, w.PublicKey, h.Address, recipient, value)
+	t.ChainID = chainID
+	s := t.GenerateSignature()
+	unlock := script.NewBuilder().AddData(s.Bytes()).AddData(preimage).AddInt(1).Script()
+	return h.transactionRequest(t, unlock)
+}
+
+// transaction returning the locked funds after the lock time,
+// w must hold the refund key of the contract
+func (h *HTLC) Refund(w *Wallet, chainID string, recipient string, value float32) *block.TransactionRequest {
+	t := NewTransaction(w.PrivateKey, w.PublicKey, h.Address, recipient, value)
+	t.ChainID = chainID
+	t.LockTime = h.LockTime
+	s := t.GenerateSignature()
+	unlock := script.NewBuilder().AddData(s.Bytes()).AddInt(0).Script()
+	return h.transactionRequest(t, unlock)
+}
+
+func (h *HTLC) transactionRequest(t *Transaction, unlock script.Script) *block.TransactionRequest {
+	redeem := h.Script.Hex()
+	unlockHex := unlock.Hex()
+	tr := &block.TransactionRequest{
+		SenderBlockchainAddress:    &t.SenderBlockchainAddress,
+		RecipientBlockchainAddress: &t.RecipientBlockchainAddress,
+		Value:                      &t.Value,
+		ChainID:                    &t.ChainID,
+		RedeemScript:               &redeem,
+		UnlockScript:               &unlockHex,
+	}
+	if t.LockTime != 0 {
+		tr.LockTime = &t.LockTime
+	}
+	return tr
+}
+
+func (h *HTLC) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Script      string `json:"script"`
+		Disassembly string `json:"disassembly"`
+		Address     string `json:"address"`
+		HashLock    string `json:"hash_lock"`
+		LockTime    int64  `json:"lock_time"`
+	}{
+		Script:      h.Script.Hex(),
+		Disassembly: h.Script.Disassemble(),
+		Address:     h.Address,
+		HashLock:    hex.EncodeToString(h.HashLock),
+		LockTime:    h.LockTime,
+	})
+}
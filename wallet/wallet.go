@@ -1,16 +1,16 @@
 package wallet
 
 import (
+	"blockchain/block"
 	"blockchain/utils"
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
-
-	"github.com/btcsuite/btcutil/base58"
-	"golang.org/x/crypto/ripemd160"
 )
 
 type Wallet struct {
@@ -25,26 +25,34 @@ type Transaction struct {
 	SenderBlockchainAddress    string  `json:"SenderBlockchainAddress"`
 	RecipientBlockchainAddress string  `json:"RecipientBlockchainAddress"`
 	Value                      float32 `json:"Value"`
+	ChainID                    string  `json:"ChainID,omitempty"`
+	LockTime                   int64   `json:"LockTime,omitempty"`
 }
 
 type TransactionRequest struct {
-	SenderPrivateKey           *string `json:"sender_private_key"`
-	SenderBlockchainAddress    *string `json:"sender_blockchain_address"`
-	RecipientBlockchainAddress *string `json:"recipient_blockchain_address"`
-	SenderPublicKey            *string `json:"sender_public_key"`
-	Value                      *string `json:"value"`
+	SenderPrivateKey           *string `json:"sender_private_key" openapi:"required,hex=64"`
+	SenderBlockchainAddress    *string `json:"sender_blockchain_address" openapi:"required"`
+	RecipientBlockchainAddress *string `json:"recipient_blockchain_address" openapi:"required"`
+	SenderPublicKey            *string `json:"sender_public_key" openapi:"required,hex=128"`
+	Value                      *string `json:"value" openapi:"required"`
 }
 
 func NewTransaction(privKey *ecdsa.PrivateKey, publicKey *ecdsa.PublicKey,
 	senderBlockchainAddress string, recipientBlockchainAddress string, value float32) *Transaction {
-	return &Transaction{privKey, publicKey, senderBlockchainAddress, recipientBlockchainAddress, value}
+	return &Transaction{
+		senderPrivateKey:           privKey,
+		senderPublicKey:            publicKey,
+		SenderBlockchainAddress:    senderBlockchainAddress,
+		RecipientBlockchainAddress: recipientBlockchainAddress,
+		Value:                      value,
+	}
 }
 
 func (t *Transaction) GenerateSignature() *utils.Signature {
 	m, _ := json.Marshal(t)
 	h := sha256.Sum256([]byte(m))
 	r, s, _ := ecdsa.Sign(rand.Reader, t.senderPrivateKey, h[:])
-	return &utils.Signature{R: r, S: s}
+	return (&utils.Signature{R: r, S: s}).ToLowS()
 }
 
 func NewWallet() *Wallet {
@@ -52,40 +60,35 @@ func NewWallet() *Wallet {
 	privKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
 	w.PrivateKey = privKey
 	w.PublicKey = &privKey.PublicKey
-
-	//address calculation
-	h2 := sha256.New()
-	h2.Write(w.PublicKey.X.Bytes())
-	h2.Write(w.PublicKey.Y.Bytes())
-	digest2 := h2.Sum(nil)
-	h3 := ripemd160.New()
-	h3.Write(digest2)
-	digest3 := h3.Sum(nil)
-	vd4 := make([]byte, 21)
-	vd4[0] = 0x00
-	copy(vd4[1:], digest3[:])
-	h5 := sha256.New()
-	h5.Write(vd4)
-	digest5 := h5.Sum(nil)
-	h6 := sha256.New()
-	h6.Write(digest5)
-	digest6 := h6.Sum(nil)
-	chsum := digest6[:4]
-	dc8 := make([]byte, 25)
-	copy(dc8[:21], vd4[:])
-	copy(dc8[21:], chsum[:])
-	address := base58.Encode(dc8)
-
-	w.BlockchainAddress = address
+	w.BlockchainAddress = utils.AddressFromPublicKey(w.PublicKey)
 	return w
 }
 
+// a wallet from hex keys as PrivateKeyStr and PublicKeyStr print them
+func LoadWallet(privateKey string, publicKey string) (*Wallet, error) {
+	// PrivateKeyStr drops leading zero bytes
+	if _, err := hex.DecodeString(privateKey); err != nil || privateKey == "" || len(privateKey) > 64 {
+		return nil, errors.New("private key must be up to 64 hex characters")
+	}
+	if !utils.IsHex(publicKey, 128) {
+		return nil, errors.New("public key must be 128 hex characters")
+	}
+	w := new(Wallet)
+	w.PublicKey = utils.PublicKeyFromString(publicKey)
+	w.PrivateKey = utils.PrivateKeyFromString(privateKey, w.PublicKey)
+	w.BlockchainAddress = utils.AddressFromPublicKey(w.PublicKey)
+	return w, nil
+}
+
+// json form of a Wallet, keys hex encoded
+type WalletResponse struct {
+	PrivateKey        string `json:"private_key"`
+	PublicKey         string `json:"public_key"`
+	BlockchainAddress string `json:"blockchain_address"`
+}
+
 func (w *Wallet) MarshalJSON() ([]byte, error) {
-	return json.Marshal(struct {
-		PrivateKey        string `json:"private_key"`
-		PublicKey         string `json:"public_key"`
-		BlockchainAddress string `json:"blockchain_address"`
-	}{
+	return json.Marshal(&WalletResponse{
 		PrivateKey:        w.PrivateKeyStr(),
 		PublicKey:         w.PublicKeyStr(),
 		BlockchainAddress: w.GetBlockchainAddress(),
@@ -100,25 +103,58 @@ func (w *Wallet) GetPrivateKey() *ecdsa.PrivateKey {
 	return w.PrivateKey
 }
 
+// fixed 64 hex characters, a key with leading zero bytes keeps them
 func (w *Wallet) PrivateKeyStr() string {
-	return fmt.Sprintf("%x", w.PrivateKey.D.Bytes())
+	return fmt.Sprintf("%x", w.PrivateKey.D.FillBytes(make([]byte, 32)))
 }
 
 func (w *Wallet) GetPublicKey() *ecdsa.PublicKey {
 	return w.PublicKey
 }
 
+// fixed 128 hex characters, X then Y, as utils.PublicKeyFromString reads it
 func (w *Wallet) PublicKeyStr() string {
-	return fmt.Sprintf("%x %x", w.PublicKey.X.Bytes(), w.PublicKey.Y.Bytes())
+	return fmt.Sprintf("%x", utils.PublicKeyBytes(w.PublicKey))
+}
+
+// a signed transaction sending value from the wallet to recipient, ready
+// for a node's /transactions
+func (w *Wallet) TransactionRequest(chainID string, recipient string, value float32) *block.TransactionRequest {
+	t := NewTransaction(w.PrivateKey, w.PublicKey, w.BlockchainAddress, recipient, value)
+	t.ChainID = chainID
+	signature := t.GenerateSignature().String()
+	publicKey := w.PublicKeyStr()
+	return &block.TransactionRequest{
+		SenderBlockchainAddress:    &t.SenderBlockchainAddress,
+		RecipientBlockchainAddress: &t.RecipientBlockchainAddress,
+		SenderPublicKey:            &publicKey,
+		Value:                      &t.Value,
+		Signature:                  &signature,
+		ChainID:                    &t.ChainID,
+	}
 }
 
 func (tr *TransactionRequest) Validate() bool {
-	if tr.SenderPrivateKey == nil ||
-		tr.SenderBlockchainAddress == nil ||
-		tr.RecipientBlockchainAddress == nil ||
-		tr.SenderPublicKey == nil ||
-		tr.Value == nil {
-		return false
+	return len(tr.MissingFields()) == 0
+}
+
+// json names of the required fields that are not set
+func (tr *TransactionRequest) MissingFields() []string {
+	missing := make([]string, 0)
+	if tr.SenderPrivateKey == nil {
+		missing = append(missing, "sender_private_key")
+	}
+	if tr.SenderBlockchainAddress == nil {
+		missing = append(missing, "sender_blockchain_address")
+	}
+	if tr.RecipientBlockchainAddress == nil {
+		missing = append(missing, "recipient_blockchain_address")
+	}
+	if tr.SenderPublicKey == nil {
+		missing = append(missing, "sender_public_key")
+	}
+	if tr.Value == nil {
+		missing = append(missing, "value")
 	}
-	return true
+	return missing
 }
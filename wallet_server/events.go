@@ -0,0 +1,92 @@
+package main
+
+import (
+	"blockchain/block"
+	"blockchain/client"
+	"blockchain/utils"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+const (
+	EVENT_KEEPALIVE = 15 * time.Second
+	EVENT_RETRY_MS  = 3000
+)
+
+// events the wallet page reloads its balance on
+var walletEventTypes = []string{block.EVENT_ADDRESS, block.EVENT_BLOCK, block.EVENT_REORG}
+
+// passes a gateway's events for one address through to the browser.
+// they only say that something happened, the page then asks for the
+// balance the usual way, so in spv mode it stays verified
+func (ws *WalletServer) WalletEvents(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			utils.JsonError(w, req, http.StatusInternalServerError, utils.ERR_INTERNAL, "streaming not supported", nil)
+			return
+		}
+		blockchainAddress := req.URL.Query().Get("blockchain_address")
+		var stream *client.EventStream
+		err := ws.gateways.Do(func(c *client.Client) error {
+			var err error
+			stream, err = c.Events(req.Context(), walletEventTypes, []string{blockchainAddress}, "")
+			return err
+		})
+		if err != nil {
+			gatewayError(w, req, err)
+			return
+		}
+		defer stream.Close()
+
+		events := make(chan *client.Event)
+		go func() {
+			defer close(events)
+			for {
+				e, err := stream.Next()
+				if err != nil {
+					log.Printf("events: gateway stream ended: %v", err)
+					return
+				}
+				select {
+				case events <- e:
+				case <-req.Context().Done():
+					return
+				case <-ws.closing:
+					return
+				}
+			}
+		}()
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		fmt.Fprintf(w, "retry: %d\n\n", EVENT_RETRY_MS)
+		flusher.Flush()
+
+		keepalive := time.NewTicker(EVENT_KEEPALIVE)
+		defer keepalive.Stop()
+		for {
+			select {
+			case <-req.Context().Done():
+				return
+			case <-ws.closing:
+				return
+			case <-keepalive.C:
+				fmt.Fprint(w, ": keepalive\n\n")
+			case e, ok := <-events:
+				if !ok {
+					// the browser reconnects, maybe to another gateway
+					return
+				}
+				fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, e.Data)
+			}
+			flusher.Flush()
+		}
+	default:
+		utils.MethodNotAllowed(w, req, http.MethodGet)
+	}
+}
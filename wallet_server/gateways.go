@@ -0,0 +1,270 @@
+package main
+
+import (
+	"blockchain/client"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	HEALTH_CHECK_INTERVAL = 15 * time.Second
+	GATEWAY_TIMEOUT       = 5 * time.Second
+	// every healthy gateway is tried this many times before giving up,
+	// waiting RETRY_BACKOFF between rounds, doubled each round
+	MAX_RETRIES   = 3
+	RETRY_BACKOFF = 200 * time.Millisecond
+
+	STRATEGY_PRIORITY    = "priority"
+	STRATEGY_ROUND_ROBIN = "round_robin"
+)
+
+var ErrNoGateway = errors.New("no gateway available")
+
+type Gateway struct {
+	URL       string `json:"url"`
+	Healthy   bool   `json:"healthy"`
+	ChainID   string `json:"chain_id"`
+	Height    int    `json:"height"`
+	Failures  int    `json:"failures"`
+	LastCheck int64  `json:"last_check"`
+	client    *client.Client
+}
+
+// the nodes the wallet server talks to. unhealthy gateways are skipped
+// until a health check finds them up again
+type GatewayPool struct {
+	Strategy string
+	// matching answers needed for a balance to be believed
+	Quorum int
+
+	gateways []*Gateway
+	next     int
+	quit     chan struct{}
+	done     chan struct{}
+	mux      sync.Mutex
+}
+
+// urls are in priority order
+func NewGatewayPool(urls []string, strategy string, quorum int) *GatewayPool {
+	gp := &GatewayPool{
+		Strategy: strategy,
+		Quorum:   quorum,
+		quit:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	for _, u := range urls {
+		// healthy until a check says otherwise, so the first request
+		// doesn't wait for one
+		gp.gateways = append(gp.gateways, &Gateway{URL: u, Healthy: true, client: client.NewClient(u, GATEWAY_TIMEOUT)})
+	}
+	return gp
+}
+
+func (gp *GatewayPool) URLs() []string {
+	urls := make([]string, 0, len(gp.gateways))
+	for _, g := range gp.gateways {
+		urls = append(urls, g.URL)
+	}
+	return urls
+}
+
+func (gp *GatewayPool) Start() {
+	go func() {
+		defer close(gp.done)
+		for {
+			gp.CheckHealth()
+			select {
+			case <-time.After(HEALTH_CHECK_INTERVAL):
+			case <-gp.quit:
+				return
+			}
+		}
+	}()
+}
+
+// ends the health checks after the one running
+func (gp *GatewayPool) Stop(ctx context.Context) error {
+	close(gp.quit)
+	select {
+	case <-gp.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (gp *GatewayPool) CheckHealth() {
+	var wg sync.WaitGroup
+	for _, g := range gp.gateways {
+		wg.Add(1)
+		go func(g *Gateway) {
+			defer wg.Done()
+			status, err := g.client.Status(context.Background())
+			gp.mux.Lock()
+			defer gp.mux.Unlock()
+			g.LastCheck = time.Now().Unix()
+			if err != nil {
+				if g.Healthy {
+					log.Printf("ERROR: gateway %s is down: %v", g.URL, err)
+				}
+				g.Healthy = false
+				return
+			}
+			if !g.Healthy {
+				log.Printf("gateway %s is back up", g.URL)
+			}
+			g.Healthy = true
+			g.Failures = 0
+			g.ChainID = status.ChainID
+			g.Height = status.Height
+		}(g)
+	}
+	wg.Wait()
+}
+
+func (gp *GatewayPool) Status() []Gateway {
+	gp.mux.Lock()
+	defer gp.mux.Unlock()
+	list := make([]Gateway, 0, len(gp.gateways))
+	for _, g := range gp.gateways {
+		list = append(list, *g)
+	}
+	return list
+}
+
+// healthy gateways in the order they should be tried. with none healthy
+// every gateway is tried, the last health check may be stale
+func (gp *GatewayPool) healthy() []*Gateway {
+	gp.mux.Lock()
+	defer gp.mux.Unlock()
+	list := make([]*Gateway, 0, len(gp.gateways))
+	for _, g := range gp.gateways {
+		if g.Healthy {
+			list = append(list, g)
+		}
+	}
+	if len(list) == 0 {
+		list = append(list, gp.gateways...)
+	}
+	if gp.Strategy == STRATEGY_ROUND_ROBIN && len(list) > 0 {
+		start := gp.next % len(list)
+		gp.next++
+		list = append(list[start:], list[:start]...)
+	}
+	return list
+}
+
+// counts err against g if it is the gateway's fault, see
+// client.Retriable. true if it was
+func (gp *GatewayPool) failed(g *Gateway, err error) bool {
+	if !client.Retriable(err) {
+		return false
+	}
+	gp.mux.Lock()
+	defer gp.mux.Unlock()
+	g.Failures++
+	log.Printf("ERROR: gateway %s: %v", g.URL, err)
+	// a gateway that can't be reached waits for the next health check,
+	// one that answered with an error is still up
+	if errors.Is(err, client.ErrUnavailable) {
+		g.Healthy = false
+	}
+	return true
+}
+
+// runs f against one gateway after the other until it succeeds. an
+// error another gateway would answer the same, like a rejected
+// transaction, comes back at once
+func (gp *GatewayPool) Do(f func(c *client.Client) error) error {
+	backoff := RETRY_BACKOFF
+	lastErr := ErrNoGateway
+	for i := 0; i < MAX_RETRIES; i++ {
+		if i > 0 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		for _, g := range gp.healthy() {
+			err := f(g.client)
+			if err == nil {
+				return nil
+			}
+			if !gp.failed(g, err) {
+				return err
+			}
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+// runs f against every healthy gateway at once, returns how many succeeded
+// and one of the errors of those that didn't
+func (gp *GatewayPool) Broadcast(f func(c *client.Client) error) (int, error) {
+	var wg sync.WaitGroup
+	var mux sync.Mutex
+	ok := 0
+	var lastErr error
+	for _, g := range gp.healthy() {
+		wg.Add(1)
+		go func(g *Gateway) {
+			defer wg.Done()
+			if err := f(g.client); err != nil {
+				gp.failed(g, err)
+				mux.Lock()
+				lastErr = err
+				mux.Unlock()
+				return
+			}
+			mux.Lock()
+			ok++
+			mux.Unlock()
+		}(g)
+	}
+	wg.Wait()
+	return ok, lastErr
+}
+
+// asks every healthy gateway and returns the answer at least Quorum of
+// them agree on. answers are compared by their JSON encoding
+func (gp *GatewayPool) QuorumRead(f func(c *client.Client) (interface{}, error)) (interface{}, error) {
+	var wg sync.WaitGroup
+	var mux sync.Mutex
+	votes := make(map[string]int)
+	answers := make(map[string]interface{})
+	for _, g := range gp.healthy() {
+		wg.Add(1)
+		go func(g *Gateway) {
+			defer wg.Done()
+			v, err := f(g.client)
+			if err != nil {
+				gp.failed(g, err)
+				return
+			}
+			key, _ := json.Marshal(v)
+			mux.Lock()
+			votes[string(key)]++
+			answers[string(key)] = v
+			mux.Unlock()
+		}(g)
+	}
+	wg.Wait()
+	best, count := "", 0
+	for key, n := range votes {
+		if n > count {
+			best, count = key, n
+		}
+	}
+	if count == 0 {
+		return nil, ErrNoGateway
+	}
+	if count < gp.Quorum {
+		return nil, fmt.Errorf("no quorum: best answer from %d of %d gateways, %d needed",
+			count, len(gp.gateways), gp.Quorum)
+	}
+	return answers[best], nil
+}
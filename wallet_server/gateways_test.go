@@ -0,0 +1,48 @@
+package main
+
+import (
+	"blockchain/client"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestGatewayPoolDoRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		requests int32
+		failures int
+	}{
+		{"rejected request", http.StatusBadRequest, 1, 0},
+		{"unknown address", http.StatusNotFound, 1, 0},
+		{"node error", http.StatusInternalServerError, MAX_RETRIES, MAX_RETRIES},
+		{"node overloaded", http.StatusServiceUnavailable, MAX_RETRIES, MAX_RETRIES},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests atomic.Int32
+			node := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				requests.Add(1)
+				w.WriteHeader(tt.status)
+			}))
+			defer node.Close()
+			gp := NewGatewayPool([]string{node.URL}, STRATEGY_PRIORITY, 1)
+			err := gp.Do(func(c *client.Client) error {
+				_, err := c.GetBalance(context.Background(), "address")
+				return err
+			})
+			if !client.IsStatus(err, tt.status) {
+				t.Errorf("error %v, want status %d", err, tt.status)
+			}
+			if n := requests.Load(); n != tt.requests {
+				t.Errorf("%d requests, want %d", n, tt.requests)
+			}
+			if g := gp.gateways[0]; g.Failures != tt.failures || !g.Healthy {
+				t.Errorf("gateway has %d failures, healthy %v, want %d and healthy", g.Failures, g.Healthy, tt.failures)
+			}
+		})
+	}
+}
@@ -1,8 +1,11 @@
 package main
 
 import (
+	"blockchain/block"
+	"blockchain/config"
 	"flag"
 	"log"
+	"os"
 )
 
 func init() {
@@ -10,9 +13,62 @@ func init() {
 }
 
 func main() {
-	port := flag.Uint("port", 8080, "TCP Port Number for Wallet Server")
-	gateway := flag.String("gateway", "http://127.0.0.1:5000", "Blockchain Gateway")
+	cfg := config.DefaultWallet()
+	configFile := config.FindFlag(os.Args[1:], "config")
+	if configFile == "" {
+		configFile = os.Getenv(config.WALLET_ENV_PREFIX + "CONFIG")
+	}
+	if err := config.Load(cfg, configFile, config.WALLET_ENV_PREFIX); err != nil {
+		log.Fatalf("config: %v", err)
+	}
+
+	flag.String("config", configFile, "Config file, default $"+config.WALLET_ENV_PREFIX+"CONFIG")
+	flag.StringVar(&cfg.API.Host, "host", cfg.API.Host, "Interface the server listens on")
+	flag.UintVar(&cfg.API.Port, "port", cfg.API.Port, "TCP Port Number for Wallet Server")
+	flag.StringVar(&cfg.API.TLSCert, "tls_cert", cfg.API.TLSCert, "Certificate file, the server uses https when set")
+	flag.StringVar(&cfg.API.TLSKey, "tls_key", cfg.API.TLSKey, "Private key file of -tls_cert")
+	flag.Var(&cfg.Gateway.URLs, "gateway", "Comma separated Blockchain Gateways, in priority order")
+	flag.StringVar(&cfg.Gateway.Strategy, "strategy", cfg.Gateway.Strategy, "Gateway selection (priority, round_robin)")
+	flag.IntVar(&cfg.Gateway.Quorum, "quorum", cfg.Gateway.Quorum, "Gateways that must agree on a balance")
+	flag.BoolVar(&cfg.SPV.Enabled, "spv", cfg.SPV.Enabled, "Verify balances from block headers and merkle proofs instead of trusting the gateway")
+	flag.Var(&cfg.SPV.Nodes, "nodes", "Comma separated node urls headers are synced from in spv mode, defaults to the gateways")
+	flag.StringVar(&cfg.SPV.Network, "network", cfg.SPV.Network, "Built in network of the nodes in spv mode (mainnet, testnet)")
+	flag.StringVar(&cfg.SPV.Genesis, "genesis", cfg.SPV.Genesis, "Genesis spec file for spv mode, overrides -network")
 	flag.Parse()
-	app := NewWalletServer(uint16(*port), *gateway)
+	if err := cfg.Validate(); err != nil {
+		log.Fatalf("invalid settings:\n%v", err)
+	}
+	if configFile != "" {
+		log.Printf("config: %s", configFile)
+	}
+
+	gateways := NewGatewayPool(cfg.Gateway.URLs, cfg.Gateway.Strategy, cfg.Gateway.Quorum)
+	gateways.Start()
+	app := NewWalletServer(uint16(cfg.API.Port), gateways)
+	app.ListenOn(cfg.API.Host)
+	if cfg.API.TLS() {
+		app.UseTLS(cfg.API.TLSCert, cfg.API.TLSKey)
+	}
+	if cfg.SPV.Enabled {
+		genesis, ok := block.GenesisForNetwork(cfg.SPV.Network)
+		if !ok {
+			log.Fatalf("unknown network %q", cfg.SPV.Network)
+		}
+		if cfg.SPV.Genesis != "" {
+			g, err := block.LoadGenesis(cfg.SPV.Genesis)
+			if err != nil {
+				log.Fatal(err)
+			}
+			genesis = g
+		}
+		nodeList := gateways.URLs()
+		if len(cfg.SPV.Nodes) > 0 {
+			nodeList = cfg.SPV.Nodes
+		}
+		c := NewSPVClient(genesis, nodeList)
+		c.Start()
+		app.UseSPV(c)
+		log.Printf("spv mode, chain_id: %s, nodes: %v", genesis.ChainID, nodeList)
+	}
 	app.Run()
 }
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"blockchain/utils"
+	"blockchain/wallet"
+	"net/http"
+)
+
+const API_VERSION = "1.0.0"
+
+// balance of a wallet, Verified when it was computed from proven
+// transactions instead of taken from the gateways
+type WalletAmountResponse struct {
+	Message   string  `json:"message"`
+	Amount    float32 `json:"amount"`
+	Immature  float32 `json:"immature"`
+	Spendable float32 `json:"spendable"`
+	Verified  bool    `json:"verified"`
+}
+
+type WalletTransactionsResponse struct {
+	Message      string                 `json:"message"`
+	Height       int                    `json:"height"`
+	Transactions []*VerifiedTransaction `json:"transactions"`
+}
+
+var addressParam = utils.Param{Name: "blockchain_address", Required: true, Type: "string"}
+
+// the wallet server's http api, served on /openapi.json and checked by Validate
+func NewAPI() *utils.OpenAPI {
+	api := utils.NewOpenAPI("blockchain wallet", API_VERSION)
+	api.Add(http.MethodGet, "/", utils.Route{
+		Summary:     "the wallet page",
+		ContentType: "text/html",
+	})
+	api.Add(http.MethodPost, "/wallet", utils.Route{
+		Summary:  "create a new wallet",
+		Response: wallet.WalletResponse{},
+	})
+	api.Add(http.MethodGet, "/wallet/amount", utils.Route{
+		Summary:  "balance of a wallet",
+		Query:    []utils.Param{addressParam},
+		Response: WalletAmountResponse{},
+		Errors:   []int{http.StatusBadGateway},
+	})
+	api.Add(http.MethodGet, "/wallet/transactions", utils.Route{
+		Summary:  "proven transactions of a wallet, light client mode only",
+		Query:    []utils.Param{addressParam},
+		Response: WalletTransactionsResponse{},
+		Errors:   []int{http.StatusNotFound, http.StatusBadGateway},
+	})
+	api.Add(http.MethodGet, "/wallet/events", utils.Route{
+		Summary:     "server sent events for a wallet, passed on from a gateway",
+		Query:       []utils.Param{addressParam},
+		ContentType: "text/event-stream",
+		Errors:      []int{http.StatusBadGateway},
+	})
+	api.Add(http.MethodGet, "/gateways", utils.Route{
+		Summary:  "the nodes the wallet server talks to",
+		Response: []Gateway{},
+	})
+	api.Add(http.MethodPost, "/transaction", utils.Route{
+		Summary:  "sign a transaction and send it to the gateways",
+		Body:     wallet.TransactionRequest{},
+		Status:   http.StatusCreated,
+		Response: utils.StatusResponse{},
+		Errors:   []int{http.StatusConflict, http.StatusUnprocessableEntity, http.StatusBadGateway},
+	})
+	api.Add(http.MethodGet, "/openapi.json", utils.Route{
+		Summary: "this document",
+	})
+	return api
+}
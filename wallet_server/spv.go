@@ -0,0 +1,202 @@
+package main
+
+import (
+	"blockchain/block"
+	"blockchain/client"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	SPV_SYNC_INTERVAL = 10 * time.Second
+	// a node whose chain forks off ours further back than this is ignored
+	SPV_REORG_DEPTH = 100
+	SPV_TIMEOUT     = 10 * time.Second
+)
+
+// a transaction proven to be in a block of our header chain
+type VerifiedTransaction struct {
+	Transaction   *block.Transaction `json:"transaction"`
+	BlockHash     string             `json:"block_hash"`
+	Height        int                `json:"height"`
+	Confirmations int                `json:"confirmations"`
+}
+
+// light client: keeps only block headers, fetched from several nodes and
+// checked against the genesis spec and the proof of work, and believes a
+// transaction only with a merkle proof into one of those headers.
+// a node can still leave transactions out, so every node is asked and
+// the answers are merged
+type SPVClient struct {
+	genesis *block.Genesis
+	nodes   []*client.Client
+	headers []*block.BlockHeader
+	quit    chan struct{}
+	done    chan struct{}
+	mux     sync.Mutex
+}
+
+func NewSPVClient(genesis *block.Genesis, nodes []string) *SPVClient {
+	c := &SPVClient{
+		genesis: genesis,
+		headers: []*block.BlockHeader{genesis.Block().Header()},
+		quit:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	for _, node := range nodes {
+		c.nodes = append(c.nodes, client.NewClient(node, SPV_TIMEOUT))
+	}
+	return c
+}
+
+func (c *SPVClient) ChainID() string {
+	return c.genesis.ChainID
+}
+
+func (c *SPVClient) Height() int {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return len(c.headers) - 1
+}
+
+func (c *SPVClient) Start() {
+	go func() {
+		defer close(c.done)
+		for {
+			c.Sync()
+			select {
+			case <-time.After(SPV_SYNC_INTERVAL):
+			case <-c.quit:
+				return
+			}
+		}
+	}()
+}
+
+// ends the header sync after the round running
+func (c *SPVClient) Stop(ctx context.Context) error {
+	close(c.quit)
+	select {
+	case <-c.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// follows the longest valid header chain any node has
+func (c *SPVClient) Sync() {
+	for _, node := range c.nodes {
+		if err := c.syncFrom(node); err != nil {
+			log.Printf("ERROR: syncing headers from %s: %v", node.BaseURL, err)
+		}
+	}
+}
+
+func (c *SPVClient) syncFrom(node *client.Client) error {
+	c.mux.Lock()
+	ours := c.headers
+	c.mux.Unlock()
+
+	// start a little behind our tip so a node on a short fork is followed
+	from := max(1, len(ours)-SPV_REORG_DEPTH)
+	candidate := append([]*block.BlockHeader{}, ours[:from]...)
+	for {
+		headers, err := node.GetHeaders(context.Background(), len(candidate))
+		if err != nil {
+			return err
+		}
+		if len(headers) == 0 {
+			break
+		}
+		for _, h := range headers {
+			if !c.genesis.ValidHeader(h, candidate[len(candidate)-1]) {
+				return fmt.Errorf("invalid header at height %d", len(candidate))
+			}
+			candidate = append(candidate, h)
+		}
+	}
+
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if len(candidate) <= len(c.headers) {
+		return nil
+	}
+	tip := c.headers[len(c.headers)-1].Hash()
+	if candidate[len(c.headers)-1].Hash() != tip {
+		log.Printf("spv: reorg to %s at height %d", node.BaseURL, len(candidate)-1)
+	}
+	c.headers = candidate
+	return nil
+}
+
+// confirmed transactions of address, every one checked against our headers
+func (c *SPVClient) Transactions(ctx context.Context, address string) ([]*VerifiedTransaction, error) {
+	verified := make(map[[32]byte]*VerifiedTransaction)
+	answered := 0
+	for _, node := range c.nodes {
+		proven, err := node.GetProvenTransactions(ctx, address)
+		if err != nil {
+			log.Printf("ERROR: transactions from %s: %v", node.BaseURL, err)
+			continue
+		}
+		answered++
+		c.mux.Lock()
+		for _, pt := range proven {
+			if pt.Height < 0 || pt.Height >= len(c.headers) || !pt.Verify(c.headers[pt.Height]) {
+				// stale after a reorg, or made up
+				log.Printf("spv: %s sent a transaction without a valid proof", node.BaseURL)
+				continue
+			}
+			t := pt.Transaction
+			if t.SenderBlockchainAddress != address && t.RecipientBlockchainAddress != address {
+				continue
+			}
+			verified[pt.Proof.TxHash] = &VerifiedTransaction{
+				Transaction:   t,
+				BlockHash:     fmt.Sprintf("%x", pt.BlockHash),
+				Height:        pt.Height,
+				Confirmations: len(c.headers) - pt.Height,
+			}
+		}
+		c.mux.Unlock()
+	}
+	if answered == 0 {
+		return nil, errors.New("no node answered")
+	}
+	list := make([]*VerifiedTransaction, 0, len(verified))
+	for _, vt := range verified {
+		list = append(list, vt)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Height < list[j].Height })
+	return list, nil
+}
+
+// balance from verified transactions only. transactions still in a pool
+// can't be proven, so they don't lower the spendable amount here
+func (c *SPVClient) Amount(ctx context.Context, address string) (*block.AmountResponse, error) {
+	txns, err := c.Transactions(ctx, address)
+	if err != nil {
+		return nil, err
+	}
+	ar := &block.AmountResponse{}
+	for _, vt := range txns {
+		t := vt.Transaction
+		if t.RecipientBlockchainAddress == address {
+			ar.Amount += t.Value
+			if t.SenderBlockchainAddress == block.MINING_SENDER && vt.Height > 0 &&
+				vt.Confirmations < c.genesis.CoinbaseMaturity {
+				ar.Immature += t.Value
+			}
+		} else {
+			ar.Amount -= t.Value
+		}
+	}
+	ar.Spendable = ar.Amount - ar.Immature
+	return ar, nil
+}
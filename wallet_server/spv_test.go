@@ -0,0 +1,79 @@
+package main
+
+import (
+	"blockchain/block"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// a node that has only the genesis block and answers with proven
+func spvNode(proven []*block.ProvenTransaction) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/headers", func(w http.ResponseWriter, req *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"headers": []*block.BlockHeader{}})
+	})
+	mux.HandleFunc("/address/transactions", func(w http.ResponseWriter, req *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"transactions": proven})
+	})
+	return httptest.NewServer(mux)
+}
+
+func TestSPVRejectsUnprovenTransactions(t *testing.T) {
+	genesis := &block.Genesis{ChainID: "test", Timestamp: 1, Difficulty: 1, MiningReward: 1, Allocations: []block.Allocation{
+		{Address: "alice", Value: 10},
+		{Address: "bob", Value: 20},
+		{Address: "alice", Value: 30},
+		{Address: "carol", Value: 40},
+	}}
+	b := genesis.Block()
+	prove := func(i int) *block.ProvenTransaction {
+		return &block.ProvenTransaction{
+			Transaction: b.Transactions[i],
+			BlockHash:   b.Hash(),
+			Height:      0,
+			Proof:       block.NewMerkleProof(b.Transactions, i),
+		}
+	}
+	tests := []struct {
+		name   string
+		forge  func(pt *block.ProvenTransaction)
+		amount float32
+	}{
+		{"proven", func(pt *block.ProvenTransaction) {}, 40},
+		{"raised value", func(pt *block.ProvenTransaction) {
+			pt.Transaction = block.NewTransaction(block.MINING_SENDER, "alice", 1000)
+		}, 30},
+		{"made up transaction with its own proof", func(pt *block.ProvenTransaction) {
+			pt.Transaction = block.NewTransaction(block.MINING_SENDER, "alice", 1000)
+			pt.Proof.TxHash = pt.Transaction.Hash()
+		}, 30},
+		{"proof of a block we don't have", func(pt *block.ProvenTransaction) {
+			pt.BlockHash[0] ^= 1
+		}, 30},
+		{"height past our headers", func(pt *block.ProvenTransaction) { pt.Height = 1 }, 30},
+		{"negative height", func(pt *block.ProvenTransaction) { pt.Height = -1 }, 30},
+		{"no proof", func(pt *block.ProvenTransaction) { pt.Proof = nil }, 30},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// the first allocation to alice is forged, the second and
+			// one to bob are sent as they are
+			forged := prove(0)
+			tt.forge(forged)
+			node := spvNode([]*block.ProvenTransaction{forged, prove(1), prove(2)})
+			defer node.Close()
+			c := NewSPVClient(genesis, []string{node.URL})
+			c.Sync()
+			ar, err := c.Amount(context.Background(), "alice")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ar.Amount != tt.amount {
+				t.Errorf("amount %v, want %v", ar.Amount, tt.amount)
+			}
+		})
+	}
+}
@@ -2,14 +2,17 @@ package main
 
 import (
 	"blockchain/block"
+	"blockchain/client"
+	"blockchain/lifecycle"
 	"blockchain/utils"
 	"blockchain/wallet"
-	"bytes"
+	"context"
 	"encoding/json"
-	"fmt"
+	"errors"
 	"html/template"
 	"io"
 	"log"
+	"net"
 	"net/http"
 	"path"
 	"strconv"
@@ -18,23 +21,70 @@ import (
 const tempDir = "./templates"
 
 type WalletServer struct {
-	Port    uint16 `json:"Port"`
-	Gateway string `json:"Gateway"`
+	Port     uint16 `json:"Port"`
+	host     string
+	tlsCert  string
+	tlsKey   string
+	gateways *GatewayPool
+	// set in light client mode, balances then come from verified
+	// transactions instead of the gateway's word
+	spv *SPVClient
+	// closed when the server shuts down, it ends the event streams
+	closing   chan struct{}
+	lifecycle *lifecycle.Manager
 }
 
-func NewWalletServer(port uint16, gateway string) *WalletServer {
-	return &WalletServer{port, gateway}
+func NewWalletServer(port uint16, gateways *GatewayPool) *WalletServer {
+	return &WalletServer{Port: port, host: "0.0.0.0", gateways: gateways,
+		closing: make(chan struct{}), lifecycle: lifecycle.NewManager()}
+}
+
+// the interface the server listens on, all of them by default
+func (ws *WalletServer) ListenOn(host string) {
+	ws.host = host
+}
+
+// serves over https with the certificate and key files
+func (ws *WalletServer) UseTLS(certFile string, keyFile string) {
+	ws.tlsCert = certFile
+	ws.tlsKey = keyFile
+}
+
+func (ws *WalletServer) UseSPV(c *SPVClient) {
+	ws.spv = c
 }
 
 func (ws *WalletServer) GetPort() uint16 {
 	return ws.Port
 }
 
-func (ws *WalletServer) GetGateway() string {
-	return ws.Gateway
+func (ws *WalletServer) GetGateways() *GatewayPool {
+	return ws.gateways
+}
+
+// chain id of the gateway's network, signed into every transaction
+// so it can't be replayed on another network
+func (ws *WalletServer) ChainID(ctx context.Context) (string, error) {
+	if ws.spv != nil {
+		return ws.spv.ChainID(), nil
+	}
+	var chainID string
+	err := ws.gateways.Do(func(c *client.Client) error {
+		status, err := c.Status(ctx)
+		if err != nil {
+			return err
+		}
+		chainID = status.ChainID
+		return nil
+	})
+	return chainID, err
 }
 
 func (ws *WalletServer) Index(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path != "/" {
+		utils.JsonError(w, req, http.StatusNotFound, utils.ERR_NOT_FOUND, "no such endpoint "+req.URL.Path, nil)
+		return
+	}
 	switch req.Method {
 	case http.MethodGet:
 		tmplFile := path.Join(tempDir, "index.html")
@@ -51,8 +101,7 @@ func (ws *WalletServer) Index(w http.ResponseWriter, req *http.Request) {
 			return
 		}
 	default:
-		log.Printf("ERROR: Invalid HTTP method")
-		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		utils.MethodNotAllowed(w, req, http.MethodGet)
 	}
 }
 
@@ -64,10 +113,10 @@ func (ws *WalletServer) Wallet(w http.ResponseWriter, req *http.Request) {
 		m, _ := json.Marshal(myWallet)
 		io.WriteString(w, string(m[:]))
 	default:
-		w.WriteHeader(http.StatusBadRequest)
-		log.Println("ERROR: Invalid http method")
+		utils.MethodNotAllowed(w, req, http.MethodPost)
 	}
 }
+
 func (ws *WalletServer) CreateTransaction(w http.ResponseWriter, req *http.Request) {
 	switch req.Method {
 	case http.MethodPost:
@@ -75,102 +124,196 @@ func (ws *WalletServer) CreateTransaction(w http.ResponseWriter, req *http.Reque
 		var t wallet.TransactionRequest
 		err := decoder.Decode(&t)
 		if err != nil {
-			log.Printf("ERROR: %v", err)
-			io.WriteString(w, string(utils.JsonStatus("fail")))
+			utils.JsonError(w, req, http.StatusBadRequest, utils.ERR_INVALID_JSON, err.Error(), nil)
+			return
+		}
+		if missing := t.MissingFields(); len(missing) > 0 {
+			utils.JsonError(w, req, http.StatusBadRequest, utils.ERR_MISSING_FIELDS, "missing fields",
+				map[string][]string{"fields": missing})
 			return
 		}
-		if !t.Validate() {
-			log.Println("ERROR: missing field(s)")
-			io.WriteString(w, string(utils.JsonStatus("fail")))
+		if !utils.IsHex(*t.SenderPublicKey, 128) {
+			utils.JsonError(w, req, http.StatusBadRequest, utils.ERR_INVALID_FIELD,
+				"sender_public_key must be 128 hex characters", map[string]string{"field": "sender_public_key"})
+			return
+		}
+		if !utils.IsHex(*t.SenderPrivateKey, 64) {
+			utils.JsonError(w, req, http.StatusBadRequest, utils.ERR_INVALID_FIELD,
+				"sender_private_key must be 64 hex characters", map[string]string{"field": "sender_private_key"})
 			return
 		}
 		publicKey := utils.PublicKeyFromString(*t.SenderPublicKey)
 		privateKey := utils.PrivateKeyFromString(*t.SenderPrivateKey, publicKey)
 		value, err := strconv.ParseFloat(*t.Value, 32)
-		if err != nil {
-			log.Println("ERROR: parse error")
-			io.WriteString(w, string(utils.JsonStatus("fail")))
+		if err != nil || value <= 0 {
+			utils.JsonError(w, req, http.StatusBadRequest, utils.ERR_INVALID_FIELD,
+				"value must be a positive number", map[string]string{"field": "value"})
 			return
 		}
 		value32 := float32(value)
+		chainID, err := ws.ChainID(req.Context())
+		if err != nil {
+			gatewayError(w, req, err)
+			return
+		}
 		transaction := wallet.NewTransaction(privateKey, publicKey, *t.SenderBlockchainAddress, *t.RecipientBlockchainAddress, value32)
+		transaction.ChainID = chainID
 		signature := transaction.GenerateSignature()
 		signatureStr := signature.String()
 		bt := block.TransactionRequest{
-			t.SenderBlockchainAddress,
-			t.RecipientBlockchainAddress,
-			t.SenderPublicKey,
-			&value32, &signatureStr,
+			SenderBlockchainAddress:    t.SenderBlockchainAddress,
+			RecipientBlockchainAddress: t.RecipientBlockchainAddress,
+			SenderPublicKey:            t.SenderPublicKey,
+			Value:                      &value32,
+			Signature:                  &signatureStr,
+			ChainID:                    &chainID,
 		}
-		m, _ := json.Marshal(bt)
 
-		buf := bytes.NewBuffer(m)
-		resp, _ := http.Post(ws.GetGateway()+"/transaction", "application/json", buf)
-		if resp.StatusCode == 201 {
-			io.WriteString(w, string(utils.JsonStatus("success")))
+		// sent to every gateway, it's enough for one to take it
+		accepted, err := ws.gateways.Broadcast(func(c *client.Client) error {
+			return c.SubmitTransaction(req.Context(), &bt)
+		})
+		if accepted == 0 {
+			gatewayError(w, req, err)
 			return
 		}
-		io.WriteString(w, string(utils.JsonStatus("fail")))
+		w.Header().Add("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		io.WriteString(w, string(utils.JsonStatus("success")))
 
 	default:
-		w.WriteHeader(http.StatusBadRequest)
-		log.Println("ERROR: Invalid http method")
+		utils.MethodNotAllowed(w, req, http.MethodPost)
 	}
 }
 
+// passes on what the node said was wrong, or that no node could be asked
+func gatewayError(w http.ResponseWriter, req *http.Request, err error) {
+	var ae *client.APIError
+	if errors.As(err, &ae) && ae.Code != "" {
+		utils.JsonError(w, req, ae.StatusCode, ae.Code, ae.Message, ae.Details)
+		return
+	}
+	if err == nil {
+		err = ErrNoGateway
+	}
+	utils.JsonError(w, req, http.StatusBadGateway, utils.ERR_UNAVAILABLE, err.Error(), nil)
+}
+
 func (ws *WalletServer) WalletAmount(w http.ResponseWriter, req *http.Request) {
 	switch req.Method {
 	case http.MethodGet:
 		blockchainAddress := req.URL.Query().Get("blockchain_address")
-		endpoint := fmt.Sprintf("%s/amount", ws.GetGateway())
+		if blockchainAddress == "" {
+			utils.JsonError(w, req, http.StatusBadRequest, utils.ERR_MISSING_FIELDS, "blockchain_address required",
+				map[string][]string{"fields": {"blockchain_address"}})
+			return
+		}
+		var bar *block.AmountResponse
+		var err error
+		if ws.spv != nil {
+			bar, err = ws.spv.Amount(req.Context(), blockchainAddress)
+		} else {
+			bar, err = ws.gatewayAmount(req.Context(), blockchainAddress)
+		}
+		if err != nil {
+			gatewayError(w, req, err)
+			return
+		}
+		w.Header().Add("Content-Type", "application/json")
+		m, _ := json.Marshal(&WalletAmountResponse{
+			Message:   "success",
+			Amount:    bar.Amount,
+			Immature:  bar.Immature,
+			Spendable: bar.Spendable,
+			Verified:  ws.spv != nil,
+		})
+		io.WriteString(w, string(m[:]))
+	default:
+		utils.MethodNotAllowed(w, req, http.MethodGet)
+	}
+}
 
-		client := &http.Client{}
-		bcsReq, _ := http.NewRequest("GET", endpoint, nil)
-		q := bcsReq.URL.Query()
-		q.Add("blockchain_address", blockchainAddress)
-		bcsReq.URL.RawQuery = q.Encode()
+// balance as reported by the gateways, only believed when a quorum agrees
+func (ws *WalletServer) gatewayAmount(ctx context.Context, blockchainAddress string) (*block.AmountResponse, error) {
+	v, err := ws.gateways.QuorumRead(func(c *client.Client) (interface{}, error) {
+		return c.GetBalance(ctx, blockchainAddress)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(*block.AmountResponse), nil
+}
 
-		bcsResp, err := client.Do(bcsReq)
+// confirmed transactions of the wallet, only available as a light client
+func (ws *WalletServer) WalletTransactions(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		if ws.spv == nil {
+			utils.JsonError(w, req, http.StatusNotFound, utils.ERR_NOT_FOUND, "transactions are only served in spv mode", nil)
+			return
+		}
+		blockchainAddress := req.URL.Query().Get("blockchain_address")
+		if blockchainAddress == "" {
+			utils.JsonError(w, req, http.StatusBadRequest, utils.ERR_MISSING_FIELDS, "blockchain_address required",
+				map[string][]string{"fields": {"blockchain_address"}})
+			return
+		}
+		txns, err := ws.spv.Transactions(req.Context(), blockchainAddress)
 		if err != nil {
-			log.Printf("ERROR: %v", err)
-			io.WriteString(w, string(utils.JsonStatus("fail")))
+			gatewayError(w, req, err)
 			return
 		}
+		w.Header().Add("Content-Type", "application/json")
+		m, _ := json.Marshal(&WalletTransactionsResponse{
+			Message:      "success",
+			Height:       ws.spv.Height(),
+			Transactions: txns,
+		})
+		io.WriteString(w, string(m[:]))
+	default:
+		utils.MethodNotAllowed(w, req, http.MethodGet)
+	}
+}
 
+func (ws *WalletServer) Gateways(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		m, _ := json.Marshal(ws.gateways.Status())
 		w.Header().Add("Content-Type", "application/json")
-		if bcsResp.StatusCode == 200 {
-			decoder := json.NewDecoder(bcsResp.Body)
-			var bar block.AmountResponse
-			err := decoder.Decode(&bar)
-			if err != nil {
-				log.Printf("ERROR: %v", err)
-				io.WriteString(w, string(utils.JsonStatus("fail")))
-				return
-			}
-
-			m, _ := json.Marshal(struct {
-				Message string  `json:"message"`
-				Amount  float32 `json:"amount"`
-			}{
-				Message: "success",
-				Amount:  bar.Amount,
-			})
-			io.WriteString(w, string(m[:]))
-		} else {
-			io.WriteString(w, string(utils.JsonStatus("fail")))
-		}
+		io.WriteString(w, string(m[:]))
 	default:
-		log.Printf("ERROR: Invalid HTTP Method")
-		w.WriteHeader(http.StatusBadRequest)
+		utils.MethodNotAllowed(w, req, http.MethodGet)
 	}
 }
 
 func (ws *WalletServer) Run() {
-	http.HandleFunc("/", ws.Index)
-	http.HandleFunc("/wallet", ws.Wallet)
-	http.HandleFunc("/wallet/amount", ws.WalletAmount)
-	http.HandleFunc("/transaction", ws.CreateTransaction)
-	addr := "0.0.0.0:" + strconv.Itoa(int(ws.GetPort()))
-	log.Printf("Wallet server running on http://%s", addr)
-	log.Fatal(http.ListenAndServe(addr, nil))
+	api := NewAPI()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", ws.Index)
+	mux.HandleFunc("/wallet", ws.Wallet)
+	mux.HandleFunc("/wallet/amount", ws.WalletAmount)
+	mux.HandleFunc("/wallet/transactions", ws.WalletTransactions)
+	mux.HandleFunc("/wallet/events", ws.WalletEvents)
+	mux.HandleFunc("/gateways", ws.Gateways)
+	mux.HandleFunc("/transaction", ws.CreateTransaction)
+	mux.Handle("/openapi.json", api)
+	addr := net.JoinHostPort(ws.host, strconv.Itoa(int(ws.GetPort())))
+	srv := lifecycle.NewHTTPServer(addr, utils.WithRequestID(api.Validate(mux)))
+	srv.RegisterOnShutdown(func() { close(ws.closing) })
+
+	ws.lifecycle.Register("gateways", ws.gateways)
+	if ws.spv != nil {
+		ws.lifecycle.Register("spv", ws.spv)
+	}
+	ws.lifecycle.Register("http", lifecycle.StopFunc(srv.Shutdown))
+
+	scheme := "http"
+	if ws.tlsCert != "" {
+		scheme = "https"
+	}
+	log.Printf("Wallet server running on %s://%s", scheme, addr)
+	if err := ws.lifecycle.Run(lifecycle.Serve(srv, ws.tlsCert, ws.tlsKey)); err != nil {
+		log.Fatal(err)
+	}
+	log.Println("Wallet server stopped")
 }
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"blockchain/wallet"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// wallets are kept in the data directory, keys in the clear, readable
+// only by the owner
+const WALLETS_FILE = "wallets.json"
+
+type StoredWallet struct {
+	Name    string `json:"name"`
+	Created int64  `json:"created"`
+	wallet.WalletResponse
+}
+
+type walletsFile struct {
+	Wallets []*StoredWallet `json:"wallets"`
+}
+
+var walletCommands = map[string]*command{
+	"new":     {args: "[-name name]", short: "create a wallet in the data directory", run: walletNew},
+	"list":    {short: "wallets in the data directory", run: walletList},
+	"balance": {args: "<name|address>", short: "balance of a wallet or any address", run: walletBalance},
+	"send":    {args: "-from name -to address -amount value", short: "send coins from a wallet", run: walletSend},
+}
+
+func loadWallets(env *Env) (*walletsFile, error) {
+	wf := &walletsFile{Wallets: make([]*StoredWallet, 0)}
+	f, err := os.ReadFile(filepath.Join(env.Datadir, WALLETS_FILE))
+	if os.IsNotExist(err) {
+		return wf, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(f, wf); err != nil {
+		return nil, fmt.Errorf("%s: %w", WALLETS_FILE, err)
+	}
+	return wf, nil
+}
+
+func (wf *walletsFile) save(env *Env) error {
+	if err := os.MkdirAll(env.Datadir, 0700); err != nil {
+		return err
+	}
+	m, err := json.MarshalIndent(wf, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(env.Datadir, WALLETS_FILE)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, m, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// a wallet by name or by address
+func (wf *walletsFile) find(key string) *StoredWallet {
+	for _, sw := range wf.Wallets {
+		if sw.Name == key || sw.BlockchainAddress == key {
+			return sw
+		}
+	}
+	return nil
+}
+
+func walletNew(env *Env, args []string) error {
+	fs := env.flags("wallet", "new", "")
+	name := fs.String("name", "", "Name of the wallet, wallet-<n> if not given")
+	fs.Parse(args)
+
+	wf, err := loadWallets(env)
+	if err != nil {
+		return err
+	}
+	if *name == "" {
+		*name = "wallet-" + strconv.Itoa(len(wf.Wallets)+1)
+	}
+	if wf.find(*name) != nil {
+		return fmt.Errorf("there already is a wallet named %s", *name)
+	}
+	w := wallet.NewWallet()
+	wf.Wallets = append(wf.Wallets, &StoredWallet{
+		Name:    *name,
+		Created: time.Now().Unix(),
+		WalletResponse: wallet.WalletResponse{
+			PrivateKey:        w.PrivateKeyStr(),
+			PublicKey:         w.PublicKeyStr(),
+			BlockchainAddress: w.GetBlockchainAddress(),
+		},
+	})
+	if err := wf.save(env); err != nil {
+		return err
+	}
+	fmt.Printf("%s %s\n", *name, w.GetBlockchainAddress())
+	return nil
+}
+
+func walletList(env *Env, args []string) error {
+	env.flags("wallet", "list", "").Parse(args)
+	wf, err := loadWallets(env)
+	if err != nil {
+		return err
+	}
+	for _, sw := range wf.Wallets {
+		fmt.Printf("%s %s\n", sw.Name, sw.BlockchainAddress)
+	}
+	return nil
+}
+
+func walletBalance(env *Env, args []string) error {
+	fs := env.flags("wallet", "balance", "<name|address>")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	address := fs.Arg(0)
+	wf, err := loadWallets(env)
+	if err != nil {
+		return err
+	}
+	if sw := wf.find(address); sw != nil {
+		address = sw.BlockchainAddress
+	}
+	ar, err := env.Client.GetBalance(env.Ctx, address)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s amount=%v immature=%v spendable=%v\n", address, ar.Amount, ar.Immature, ar.Spendable)
+	return nil
+}
+
+func walletSend(env *Env, args []string) error {
+	fs := env.flags("wallet", "send", "")
+	from := fs.String("from", "", "Name or address of the sending wallet")
+	to := fs.String("to", "", "Address to send to")
+	amount := fs.Float64("amount", 0, "Value to send")
+	fs.Parse(args)
+	if *from == "" || *to == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if *amount <= 0 {
+		return errors.New("-amount must be a positive number")
+	}
+
+	wf, err := loadWallets(env)
+	if err != nil {
+		return err
+	}
+	sw := wf.find(*from)
+	if sw == nil {
+		return fmt.Errorf("no wallet %s in %s", *from, env.Datadir)
+	}
+	w, err := wallet.LoadWallet(sw.PrivateKey, sw.PublicKey)
+	if err != nil {
+		return fmt.Errorf("wallet %s: %w", sw.Name, err)
+	}
+	status, err := env.Client.Status(env.Ctx)
+	if err != nil {
+		return err
+	}
+	t := w.TransactionRequest(status.ChainID, *to, float32(*amount))
+	if err := env.Client.SubmitTransaction(env.Ctx, t); err != nil {
+		return err
+	}
+	fmt.Printf("sent %v from %s to %s\n", float32(*amount), w.GetBlockchainAddress(), *to)
+	return nil
+}